@@ -1,10 +1,13 @@
 package main
 
 import (
+	"bufio"
 	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"os/exec"
@@ -18,7 +21,9 @@ import (
 
 	"github.com/NowakAdmin/BizantiAgent/internal/agent"
 	"github.com/NowakAdmin/BizantiAgent/internal/config"
+	"github.com/NowakAdmin/BizantiAgent/internal/enroll"
 	"github.com/NowakAdmin/BizantiAgent/internal/setup"
+	"github.com/NowakAdmin/BizantiAgent/internal/transport"
 	"github.com/NowakAdmin/BizantiAgent/internal/tray"
 	"github.com/NowakAdmin/BizantiAgent/internal/version"
 )
@@ -29,6 +34,9 @@ func main() {
 		case "configure":
 			runConfigure()
 			return
+		case "enroll":
+			runEnroll()
+			return
 		case "headless":
 			runHeadless()
 			return
@@ -55,6 +63,10 @@ func runConfigure() {
 	tenantID := fs.String("tenant-id", cfg.TenantID, "Opcjonalny tenant ID")
 	githubRepo := fs.String("github-repo", cfg.Update.GitHubRepo, "Repo do auto-update, np. NowakAdmin/BizantiAgent")
 	checkHours := fs.Int("update-hours", cfg.Update.CheckIntervalHours, "Co ile godzin sprawdzać aktualizacje")
+	proxyURL := fs.String("proxy", cfg.Proxy.URL, "Proxy, np. http://proxy:3128 lub socks5://proxy:1080 (pusty = zmienne środowiskowe)")
+	proxyUser := fs.String("proxy-user", cfg.Proxy.Username, "Użytkownik proxy")
+	proxyPasswordStdin := fs.Bool("proxy-password-stdin", false, "Wczytaj hasło proxy ze standardowego wejścia (albo ustaw zmienną "+proxyPasswordEnv+")")
+	noProxy := fs.String("no-proxy", strings.Join(cfg.Proxy.NoProxy, ","), "Hosty bez proxy, oddzielone przecinkami")
 
 	_ = fs.Parse(os.Args[2:])
 
@@ -64,6 +76,20 @@ func runConfigure() {
 	cfg.TenantID = *tenantID
 	cfg.Update.GitHubRepo = *githubRepo
 	cfg.Update.CheckIntervalHours = *checkHours
+	cfg.Proxy.URL = *proxyURL
+	cfg.Proxy.Username = *proxyUser
+	if password, ok, err := readProxyPassword(*proxyPasswordStdin); err != nil {
+		fmt.Fprintf(os.Stderr, "Błąd odczytu hasła proxy: %v\n", err)
+		os.Exit(1)
+	} else if ok {
+		cfg.Proxy.Password = password
+	}
+	cfg.Proxy.NoProxy = nil
+	for _, host := range strings.Split(*noProxy, ",") {
+		if host = strings.TrimSpace(host); host != "" {
+			cfg.Proxy.NoProxy = append(cfg.Proxy.NoProxy, host)
+		}
+	}
 
 	if err := config.Save(cfg); err != nil {
 		fmt.Fprintf(os.Stderr, "Błąd zapisu konfiguracji: %v\n", err)
@@ -73,6 +99,86 @@ func runConfigure() {
 	fmt.Printf("Konfiguracja zapisana: %s\n", config.Path())
 }
 
+// proxyPasswordEnv carries the proxy password for configure, so it never
+// lands in argv or in the --help defaults.
+const proxyPasswordEnv = "BIZANTI_PROXY_PASSWORD"
+
+// readProxyPassword returns the password from the first line of stdin or from
+// proxyPasswordEnv. ok is false when neither was given and the stored password
+// stays.
+func readProxyPassword(fromStdin bool) (password string, ok bool, err error) {
+	if fromStdin {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return "", false, err
+		}
+		return strings.TrimRight(line, "\r\n"), true, nil
+	}
+
+	password, ok = os.LookupEnv(proxyPasswordEnv)
+	return password, ok, nil
+}
+
+func runEnroll() {
+	cfg, err := config.LoadOrCreateDefault()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Błąd odczytu konfiguracji: %v\n", err)
+		os.Exit(1)
+	}
+
+	fs := flag.NewFlagSet("enroll", flag.ExitOnError)
+	serverURL := fs.String("server", cfg.ServerURL, "Base URL API Bizanti, np. https://bizanti.pl")
+	_ = fs.Parse(os.Args[2:])
+
+	cfg.ServerURL = strings.TrimSpace(*serverURL)
+
+	clients, err := transport.New(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Błędna konfiguracja połączenia: %v\n", err)
+		os.Exit(1)
+	}
+
+	hostname, _ := os.Hostname()
+	client := &enroll.Client{ServerURL: cfg.ServerURL, HTTP: clients.HTTP}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	pairing, err := client.Start(ctx, enroll.DeviceInfo{Hostname: hostname, Version: version.Version, OS: runtime.GOOS})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Nie udało się rozpocząć parowania: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Kod parowania: %s\n", pairing.UserCode)
+	if pairing.VerificationURL != "" {
+		fmt.Printf("Zatwierdź stanowisko %q w Bizanti: %s\n", hostname, pairing.VerificationURL)
+	}
+	fmt.Println("Czekam na zatwierdzenie... (Ctrl+C przerywa)")
+
+	credentials, err := client.Wait(ctx, pairing)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Parowanie nieudane: %v\n", err)
+		os.Exit(1)
+	}
+
+	cfg.AgentToken = credentials.AgentToken
+	cfg.TenantID = credentials.TenantID
+	if credentials.ServerURL != "" {
+		cfg.ServerURL = credentials.ServerURL
+	}
+	if credentials.WebSocketURL != "" {
+		cfg.WebSocketURL = credentials.WebSocketURL
+	}
+
+	if err := config.Save(cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "Błąd zapisu konfiguracji: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Stanowisko sparowane. Konfiguracja zapisana: %s\n", config.Path())
+}
+
 func runHeadless() {
 	cfg, err := config.LoadOrCreateDefault()
 	if err != nil {
@@ -3,6 +3,7 @@ module github.com/NowakAdmin/BizantiAgent
 go 1.23
 
 require (
+	github.com/eclipse/paho.mqtt.golang v1.5.0
 	github.com/getlantern/systray v1.2.2
 	github.com/gorilla/websocket v1.5.3
 	go.bug.st/serial v1.6.4
@@ -19,4 +20,6 @@ require (
 	github.com/getlantern/ops v0.0.0-20190325191751-d70cb0d6f85f // indirect
 	github.com/go-stack/stack v1.8.0 // indirect
 	github.com/oxtoacart/bpool v0.0.0-20190530202638-03653db5a59c // indirect
+	golang.org/x/net v0.27.0 // indirect
+	golang.org/x/sync v0.7.0 // indirect
 )
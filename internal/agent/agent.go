@@ -1,12 +1,10 @@
 package agent
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
 	"strings"
@@ -16,8 +14,13 @@ import (
 
 	"github.com/gorilla/websocket"
 
+	"github.com/NowakAdmin/BizantiAgent/internal/api"
 	"github.com/NowakAdmin/BizantiAgent/internal/config"
 	"github.com/NowakAdmin/BizantiAgent/internal/devices"
+	"github.com/NowakAdmin/BizantiAgent/internal/mqtt"
+	"github.com/NowakAdmin/BizantiAgent/internal/spool"
+	"github.com/NowakAdmin/BizantiAgent/internal/transport"
+	"github.com/NowakAdmin/BizantiAgent/internal/webhook"
 )
 
 type IncomingMessage struct {
@@ -25,6 +28,30 @@ type IncomingMessage struct {
 	JobID   string          `json:"job_id,omitempty"`
 	Command string          `json:"command,omitempty"`
 	Payload json.RawMessage `json:"payload,omitempty"`
+
+	// Optional execution limit: absolute RFC 3339 deadline and/or TTL in
+	// seconds from receipt. Expired jobs are reported with status "expired".
+	Deadline   string `json:"deadline,omitempty"`
+	TTLSeconds int    `json:"ttl,omitempty"`
+
+	// Dedupe=false makes the agent execute the command even if the same
+	// job_id already finished recently. Defaults to true.
+	Dedupe *bool `json:"dedupe,omitempty"`
+
+	// ResumeToken is issued by the server in "auth_ok" and presented in the
+	// next auth so the server can match the new session to the old one.
+	ResumeToken string `json:"resume_token,omitempty"`
+
+	// Timestamp (RFC 3339), Nonce and Signature (base64 ed25519) are checked
+	// when command_signing.public_key is configured.
+	Timestamp string `json:"timestamp,omitempty"`
+	Nonce     string `json:"nonce,omitempty"`
+	Signature string `json:"signature,omitempty"`
+
+	// ProtocolVersion and Features are declared by the server in "auth_ok".
+	// Servers predating negotiation send neither.
+	ProtocolVersion int      `json:"protocol_version,omitempty"`
+	Features        []string `json:"features,omitempty"`
 }
 
 type OutgoingMessage struct {
@@ -35,11 +62,32 @@ type OutgoingMessage struct {
 	Timestamp string         `json:"timestamp,omitempty"`
 	Data      map[string]any `json:"data,omitempty"`
 	Error     string         `json:"error,omitempty"`
-}
 
-type pullCommandsResponse struct {
-	Success bool              `json:"success"`
-	Data    []IncomingMessage `json:"data"`
+	// Cached marks a result replayed for a duplicate job_id without
+	// executing the command again.
+	Cached bool `json:"cached,omitempty"`
+
+	// Phase and Seq describe a command_progress step.
+	Phase string `json:"phase,omitempty"`
+	Seq   int    `json:"seq,omitempty"`
+
+	// EventID and Event identify an unsolicited device event.
+	EventID string `json:"event_id,omitempty"`
+	Event   string `json:"event,omitempty"`
+
+	// Capabilities is sent with auth and heartbeat messages.
+	Capabilities *Capabilities `json:"capabilities,omitempty"`
+
+	// ResumeToken and InFlight are sent with auth after a reconnect: jobs
+	// still queued or running locally, and results not yet acknowledged.
+	ResumeToken string   `json:"resume_token,omitempty"`
+	InFlight    []string `json:"in_flight,omitempty"`
+	Unacked     []string `json:"unacked,omitempty"`
+
+	// ProtocolVersion and Features announce what the agent implements. They
+	// are sent with auth and heartbeat messages; older servers ignore them.
+	ProtocolVersion int      `json:"protocol_version,omitempty"`
+	Features        []string `json:"features,omitempty"`
 }
 
 type Agent struct {
@@ -51,25 +99,102 @@ type Agent struct {
 	cancel  context.CancelFunc
 	wg      sync.WaitGroup
 
-	// Retry tracking
-	consecutiveFailures int
-	pausedUntil         time.Time
-	connected           bool
-	serverAgentID       string
-	mu                  sync.Mutex
+	// Reconnection backoff and circuit breaker.
+	breaker *breaker
+
+	connected     bool
+	serverAgentID string
+	mu            sync.Mutex
+
+	// Serializes credential updates, so the config on disk and in memory
+	// end up with the same token. Never held together with mu while saving.
+	credentialsMu sync.Mutex
 
 	// Persistent Dibal TCP server managers (keyed by "bindHost:rxPort").
 	// Dibal scales (with Lantronix ETS-1) hold ONE permanent TCP connection
 	// to the PC; a per-job listener would always time out.
 	dibalMu       sync.Mutex
 	dibalManagers map[string]*devices.DibalManager
+
+	// Durable queue of command results awaiting server acknowledgement.
+	outbox *spool.Queue
+
+	// Bounded record of recently finished jobs used to answer duplicates.
+	recentJobs *spool.Queue
+
+	// Runs commands off the read loop with per-device serialization.
+	jobs *scheduler
+
+	// Verifies command signatures; nil when signing is not configured.
+	verifier *commandVerifier
+	// Local command and network allowlist; nil when not configured.
+	policy *commandPolicy
+
+	// Cancel functions of queued and running jobs, keyed by job_id.
+	activeMu   sync.Mutex
+	activeJobs map[string]context.CancelCauseFunc
+
+	// Progress of HTTP-polled jobs waiting to be posted. Set when the server
+	// has no HTTP progress endpoint.
+	progress            *progressQueue
+	progressUnsupported atomic.Bool
+
+	// Set when the server has no batch result endpoint. resultsReady wakes
+	// the HTTP loop to flush finished results from the outbox.
+	batchUnsupported atomic.Bool
+	resultsReady     chan struct{}
+
+	// Live weight stream for the local API and MQTT, nil when disabled.
+	weights *weightStream
+
+	// MQTT broker client, nil when disabled.
+	mqtt *mqtt.Client
+
+	// Delivers job results and events to local webhooks, nil when none are
+	// configured.
+	webhooks *webhook.Dispatcher
+
+	// Protocol declared by the server, nil until it sends a version.
+	// Guarded by mu.
+	protocol *serverProtocol
+
+	// Durable buffer of device events; eventsReady wakes the transport.
+	// eventsUnsupported is set when the server has no HTTP events endpoint.
+	events            *spool.Queue
+	eventsReady       chan struct{}
+	eventsUnsupported atomic.Bool
+
+	// Printers whose last job failed, keyed by device lock key. Guarded by mu.
+	printerDown map[string]bool
+
+	// HTTP client and WebSocket dialer carrying the TLS and proxy settings.
+	// Set by loop before any connection is made. Guarded by mu.
+	clients *transport.Clients
+
+	// Last TLS pin mismatch, shown in the status until a connection succeeds.
+	// Guarded by mu.
+	tlsFailure string
+
+	// Set while the server rejects the token; pairingCode is the pending
+	// re-enrollment code, if any. Guarded by mu.
+	unauthorized bool
+	pairingCode  string
+
+	// Current WebSocket session, if any, and the token to resume it.
+	// Guarded by mu.
+	session     *wsSession
+	resumeToken string
 }
 
 func New(cfg *config.Config, logger *log.Logger) *Agent {
 	return &Agent{
-		cfg:    cfg,
-		logger: logger,
-		done:   make(chan struct{}),
+		cfg:          cfg,
+		logger:       logger,
+		done:         make(chan struct{}),
+		eventsReady:  make(chan struct{}, 1),
+		resultsReady: make(chan struct{}, 1),
+		progress:     newProgressQueue(),
+		breaker:      newBreaker(cfg.Reconnect),
 	}
 }
 
@@ -81,6 +206,49 @@ func (a *Agent) Start(parent context.Context) error {
 	ctx, cancel := context.WithCancel(parent)
 	a.cancel = cancel
 
+	a.openOutbox()
+	a.openRecentJobs()
+	a.openEvents()
+	a.jobs = newScheduler(a.cfg.MaxConcurrentJobs)
+
+	a.verifier = newCommandVerifier(a.cfg.CommandSigning)
+	if a.verifier != nil {
+		if a.verifier.configErr != nil {
+			a.logger.Printf("Podpisy komend: %v — wszystkie komendy będą odrzucane", a.verifier.configErr)
+		} else {
+			a.logger.Printf("Podpisy komend: włączone, niepodpisane komendy będą odrzucane")
+		}
+	}
+
+	for _, secretErr := range a.cfg.SecretErrors() {
+		a.logger.Printf("Konfiguracja: %v — wartość pominięta, zapisany sekret pozostaje bez zmian", secretErr)
+	}
+
+	a.policy = newCommandPolicy(a.cfg.Policy)
+	if a.policy != nil && a.policy.configErr != nil {
+		a.logger.Printf("Polityka: %v — wszystkie komendy będą odrzucane", a.policy.configErr)
+	}
+
+	if a.cfg.Metrics.Enabled {
+		a.startMetrics(ctx)
+	}
+
+	if a.cfg.MQTT.Enabled {
+		a.startMQTT(ctx)
+	}
+
+	if len(a.cfg.Webhooks) > 0 {
+		a.startWebhooks(ctx)
+	}
+
+	if a.cfg.LocalAPI.Enabled || a.cfg.MQTT.Enabled {
+		a.startWeightStream(ctx)
+	}
+	if a.cfg.LocalAPI.Enabled {
+		a.startLocalAPI(ctx)
+	}
+	a.startMQTTWeights(ctx)
+
 	// Pre-start persistent Dibal listeners from local config so Lantronix
 	// devices can connect immediately after agent startup.
 	for _, server := range a.cfg.DibalServers {
@@ -97,6 +265,18 @@ func (a *Agent) Start(parent context.Context) error {
 		}
 	}
 
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+		a.watchSerialPorts(ctx)
+	}()
+
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+		a.runProgress(ctx)
+	}()
+
 	a.wg.Add(1)
 	go func() {
 		defer a.wg.Done()
@@ -117,6 +297,9 @@ func (a *Agent) Stop() {
 	}
 
 	a.wg.Wait()
+	if a.jobs != nil {
+		a.jobs.wait()
+	}
 	a.running.Store(false)
 	a.setConnected(false)
 
@@ -170,6 +353,8 @@ func (a *Agent) getOrCreateDibalManager(bindHost string, rxPort, txPort int, add
 		TXPort:   txPort,
 		Addr:     addr,
 		Logger:   a.logger,
+
+		OnConnectionChange: a.dibalConnectionHook(bindHost, rxPort, txPort),
 	})
 
 	a.dibalManagers[key] = mgr
@@ -177,28 +362,37 @@ func (a *Agent) getOrCreateDibalManager(bindHost string, rxPort, txPort int, add
 	return mgr
 }
 
-// recordFailure increments failure counter and sets pause if threshold reached
-func (a *Agent) recordFailure() {
+// recordFailure feeds a connection failure to the breaker and returns the
+// delay before the next attempt. Opening the breaker is reported as an
+// agent_paused event.
+func (a *Agent) recordFailure(err error) time.Duration {
 	a.mu.Lock()
-	defer a.mu.Unlock()
-
-	a.consecutiveFailures++
 	a.connected = false
-
-	// After 3 failures, pause for 5 minutes
-	if a.consecutiveFailures >= 3 {
-		a.pausedUntil = time.Now().Add(5 * time.Minute)
-		a.logger.Printf("Zbyt wiele błędów (%d). Pauza na 5 minut.", a.consecutiveFailures)
+	a.mu.Unlock()
+
+	delay, opened := a.breaker.failure(err)
+	if opened {
+		failurePauses.Inc()
+		status := a.breaker.status()
+		a.logger.Printf("Zbyt wiele błędów (%d, %s). Pauza na %v.", status.Failures, status.LastError, delay.Round(time.Second))
+		a.emitEvent(eventAgentPaused, map[string]any{
+			"failures":     status.Failures,
+			"reason":       string(classifyFailure(err)),
+			"paused_until": status.NextAttempt.UTC().Format(time.RFC3339),
+		})
 	}
+
+	return delay
 }
 
-// recordSuccess resets failure counter
+// recordSuccess closes the breaker.
 func (a *Agent) recordSuccess() {
-	a.mu.Lock()
-	defer a.mu.Unlock()
+	a.breaker.success()
+}
 
-	a.consecutiveFailures = 0
-	a.pausedUntil = time.Time{}
+// Breaker returns the state of the reconnection circuit breaker.
+func (a *Agent) Breaker() BreakerStatus {
+	return a.breaker.status()
 }
 
 func (a *Agent) setConnected(connected bool) {
@@ -206,37 +400,70 @@ func (a *Agent) setConnected(connected bool) {
 	defer a.mu.Unlock()
 
 	a.connected = connected
+	if connected {
+		a.tlsFailure = ""
+	}
 }
 
-func (a *Agent) setServerAgentID(id string) {
+// noteTLSFailure remembers a pin mismatch so the tray shows it instead of a
+// generic reconnect message.
+func (a *Agent) noteTLSFailure(err error) {
+	var pinErr *transport.PinMismatchError
+	if !errors.As(err, &pinErr) {
+		return
+	}
+
+	a.logger.Printf("UWAGA: %v", pinErr)
+
 	a.mu.Lock()
 	defer a.mu.Unlock()
 
-	a.serverAgentID = strings.TrimSpace(id)
+	a.tlsFailure = "Błąd TLS: certyfikat serwera niezgodny z pinem"
 }
 
-func (a *Agent) getServerAgentID() string {
+func (a *Agent) setClients(clients *transport.Clients) {
 	a.mu.Lock()
 	defer a.mu.Unlock()
 
-	return a.serverAgentID
+	a.clients = clients
 }
 
-// isPaused checks if we're currently paused from retrying
-func (a *Agent) isPaused() bool {
+func (a *Agent) getClients() *transport.Clients {
 	a.mu.Lock()
 	defer a.mu.Unlock()
 
-	if a.pausedUntil.IsZero() {
-		return false
+	if a.clients == nil {
+		return transport.Default()
 	}
 
-	if time.Now().After(a.pausedUntil) {
-		a.pausedUntil = time.Time{}
-		return false
-	}
+	return a.clients
+}
+
+func (a *Agent) httpClient() *http.Client {
+	return a.getClients().HTTP
+}
+
+func (a *Agent) wsDialer() *websocket.Dialer {
+	return a.getClients().Dialer
+}
 
-	return true
+// ProxyStatus describes the proxy server connections go through.
+func (a *Agent) ProxyStatus() string {
+	return a.getClients().Proxy
+}
+
+func (a *Agent) setServerAgentID(id string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.serverAgentID = strings.TrimSpace(id)
+}
+
+func (a *Agent) getServerAgentID() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.serverAgentID
 }
 
 // GetStatus returns human-readable connection status for display
@@ -245,14 +472,29 @@ func (a *Agent) GetStatus() string {
 	defer a.mu.Unlock()
 
 	if a.running.Load() {
-		if !a.pausedUntil.IsZero() && time.Now().Before(a.pausedUntil) {
-			return fmt.Sprintf("Pauza (próba za %d s)", int(a.pausedUntil.Sub(time.Now()).Seconds()))
+		if a.unauthorized {
+			if a.pairingCode != "" {
+				return "Unauthorized — kod parowania: " + a.pairingCode
+			}
+			return "Unauthorized"
+		}
+		breakerStatus := a.breaker.status()
+		if breakerStatus.State == BreakerOpen {
+			if remaining := time.Until(breakerStatus.NextAttempt); remaining > 0 {
+				return fmt.Sprintf("Pauza (próba za %d s) — %s", int(remaining.Seconds()), breakerStatus.LastError)
+			}
 		}
 		if a.connected {
 			return "Połączono"
 		}
-		if a.consecutiveFailures > 0 {
-			return fmt.Sprintf("Łączenie... (próba %d)", a.consecutiveFailures+1)
+		if a.tlsFailure != "" {
+			return a.tlsFailure
+		}
+		if breakerStatus.State == BreakerHalfOpen {
+			return "Łączenie po pauzie..."
+		}
+		if breakerStatus.Failures > 0 {
+			return fmt.Sprintf("Łączenie... (próba %d, następna o %s)", breakerStatus.Failures+1, breakerStatus.NextAttempt.Format("15:04:05"))
 		}
 		return "Łączenie..."
 	}
@@ -261,45 +503,63 @@ func (a *Agent) GetStatus() string {
 }
 
 func (a *Agent) loop(ctx context.Context) {
-	if strings.TrimSpace(a.cfg.AgentToken) == "" {
+	if strings.TrimSpace(a.endpoint().Token) == "" {
 		a.logger.Printf("Brak tokena agenta. Użyj: bizanti-agent configure --token=...")
 		<-ctx.Done()
 		return
 	}
 
-	if strings.TrimSpace(a.cfg.ServerURL) == "" && strings.TrimSpace(a.cfg.WebSocketURL) == "" {
+	if settings := a.endpoint(); strings.TrimSpace(settings.ServerURL) == "" && strings.TrimSpace(settings.WebSocketURL) == "" {
 		a.logger.Printf("Brak ServerURL i WebSocketURL. Użyj: bizanti-agent configure ...")
 		<-ctx.Done()
 		return
 	}
 
-	backoff := 1 * time.Second
+	clients, err := transport.New(a.cfg)
+	if err != nil {
+		a.logger.Printf("Błędna konfiguracja połączenia: %v. Popraw sekcje tls/proxy w config.json.", err)
+		<-ctx.Done()
+		return
+	}
+	a.setClients(clients)
+	a.logger.Printf("Proxy: %s", clients.Proxy)
+
+	// sessions counts WebSocket attempts; every one after the first is a
+	// reconnect.
+	sessions := 0
+
 	for {
-		select {
-		case <-ctx.Done():
+		if ctx.Err() != nil {
 			return
-		default:
 		}
 
-		// Jeśli jesteśmy w pauzie, czekaj
-		if a.isPaused() {
-			a.logger.Printf("Agent w pauzie. Czekam zanim spróbuję ponownie...")
+		if wait := a.breaker.wait(); wait > 0 {
+			a.logger.Printf("Agent w pauzie. Kolejna próba za %v.", wait.Round(time.Second))
 			select {
 			case <-ctx.Done():
 				return
-			case <-time.After(30 * time.Second):
-				continue
+			case <-time.After(wait):
 			}
+			continue
 		}
 
-		var err error
-		websocketURL := strings.TrimSpace(a.cfg.WebSocketURL)
+		var delay time.Duration
+		websocketURL := strings.TrimSpace(a.endpoint().WebSocketURL)
 
 		if websocketURL != "" {
+			if sessions > 0 {
+				websocketReconnects.Inc()
+			}
+			sessions++
+
 			err = a.runSession(ctx)
+			if a.handleAuthFailure(ctx, err) {
+				continue
+			}
 			if err != nil && !errors.Is(err, context.Canceled) {
 				a.logger.Printf("Sesja WebSocket zakończona: %v", err)
-				a.recordFailure()
+				delay = a.recordFailure(err)
+				a.noteTLSFailure(err)
 			} else if err == nil {
 				a.recordSuccess()
 			}
@@ -309,11 +569,32 @@ func (a *Agent) loop(ctx context.Context) {
 			}
 
 			a.logger.Printf("Przechodzę na fallback HTTP polling.")
-			_ = a.runHTTPPolling(ctx, 45*time.Second)
+			httpFallbacks.Inc()
+			pollErr := a.runHTTPPolling(ctx, 45*time.Second)
+			if a.handleAuthFailure(ctx, pollErr) {
+				continue
+			}
+			if pollErr == nil {
+				// The server is reachable over HTTP even if WebSocket is
+				// not, so WebSocket failures alone do not pause the agent.
+				a.recordSuccess()
+			} else {
+				a.noteTLSFailure(pollErr)
+				if _, limited := rateLimitDelay(pollErr); limited {
+					err = pollErr
+				}
+			}
 		} else {
 			err = a.runHTTPPolling(ctx, 0)
-			if err != nil && !errors.Is(err, context.Canceled) {
-				a.recordFailure()
+			if a.handleAuthFailure(ctx, err) {
+				continue
+			}
+			// A 429 means the server is up but busy; it does not count
+			// towards the breaker.
+			_, limited := rateLimitDelay(err)
+			if err != nil && !limited && !errors.Is(err, context.Canceled) {
+				delay = a.recordFailure(err)
+				a.noteTLSFailure(err)
 			} else if err == nil {
 				a.recordSuccess()
 			}
@@ -323,261 +604,179 @@ func (a *Agent) loop(ctx context.Context) {
 			a.logger.Printf("Pętla agenta zakończona błędem: %v", err)
 		}
 
-		select {
-		case <-ctx.Done():
-			return
-		case <-time.After(backoff):
+		if delay == 0 {
+			delay = a.breaker.retryDelay()
 		}
-
-		if backoff < 20*time.Second {
-			backoff *= 2
+		if retryAfter, limited := rateLimitDelay(err); limited && retryAfter > delay {
+			delay = retryAfter
 		}
-	}
-}
-
-func (a *Agent) runHTTPPolling(ctx context.Context, maxDuration time.Duration) error {
-	if strings.TrimSpace(a.cfg.ServerURL) == "" {
-		return fmt.Errorf("brak server_url do fallback HTTP")
-	}
-
-	heartbeatEvery := time.Duration(a.cfg.HeartbeatSeconds) * time.Second
-	if a.cfg.HeartbeatSeconds <= 0 {
-		heartbeatEvery = 30 * time.Second
-	}
-
-	pollTicker := time.NewTicker(2 * time.Second)
-	heartbeatTicker := time.NewTicker(heartbeatEvery)
-	defer pollTicker.Stop()
-	defer heartbeatTicker.Stop()
 
-	if err := a.heartbeat(ctx); err != nil {
-		a.setConnected(false)
-		a.logger.Printf("HTTP heartbeat error: %v", err)
-	}
-
-	var timeout <-chan time.Time
-	if maxDuration > 0 {
-		timer := time.NewTimer(maxDuration)
-		defer timer.Stop()
-		timeout = timer.C
-	}
-
-	for {
 		select {
 		case <-ctx.Done():
-			return context.Canceled
-		case <-timeout:
-			return nil
-		case <-heartbeatTicker.C:
-			if err := a.heartbeat(ctx); err != nil {
-				a.setConnected(false)
-				a.logger.Printf("HTTP heartbeat error: %v", err)
-			}
-		case <-pollTicker.C:
-			commands, err := a.pullCommands(ctx)
-			if err != nil {
-				a.setConnected(false)
-				return err
-			}
-
-			for _, message := range commands {
-				commandName := strings.ToLower(strings.TrimSpace(message.Command))
-				result, execErr := a.executeCommand(commandName, message.Payload)
-				if reportErr := a.reportCommandResult(ctx, message.JobID, result, execErr); reportErr != nil {
-					a.logger.Printf("Błąd raportowania wyniku job %s: %v", message.JobID, reportErr)
-				}
-			}
+			return
+		case <-time.After(delay):
 		}
 	}
 }
 
 func (a *Agent) heartbeat(ctx context.Context) error {
-	request, err := a.newAPIRequest(ctx, http.MethodPost, "/api/bizanticore/agent/heartbeat", nil)
-	if err != nil {
-		return err
+	body := map[string]any{
+		"status":           "online",
+		"capabilities":     a.capabilities(),
+		"protocol_version": ProtocolVersion,
+		"features":         agentFeatures,
 	}
 
-	response, err := http.DefaultClient.Do(request)
+	var payload struct {
+		AgentID         any      `json:"agent_id"`
+		ProtocolVersion int      `json:"protocol_version"`
+		Features        []string `json:"features"`
+	}
+	err := a.api().Do(ctx, api.Call{
+		Op:         "heartbeat",
+		Method:     http.MethodPost,
+		Path:       "/api/bizanticore/agent/heartbeat",
+		Body:       body,
+		Idempotent: true,
+	}, &payload)
 	if err != nil {
 		return err
 	}
-	defer func() {
-		_ = response.Body.Close()
-	}()
-
-	if response.StatusCode >= 300 {
-		body, _ := io.ReadAll(response.Body)
-		return fmt.Errorf("heartbeat status %d: %s", response.StatusCode, summarizeResponseBody(body))
-	}
 
-	var payload struct {
-		AgentID any `json:"agent_id"`
-	}
-
-	if err = json.NewDecoder(response.Body).Decode(&payload); err == nil {
-		if payload.AgentID != nil {
-			a.setServerAgentID(fmt.Sprintf("%v", payload.AgentID))
-		}
+	if payload.AgentID != nil {
+		a.setServerAgentID(fmt.Sprintf("%v", payload.AgentID))
 	}
+	a.negotiateProtocol(payload.ProtocolVersion, payload.Features)
 
 	a.setConnected(true)
 
 	return nil
 }
 
-func (a *Agent) pullCommands(ctx context.Context) ([]IncomingMessage, error) {
-	request, err := a.newAPIRequest(ctx, http.MethodGet, "/api/bizanticore/agent/commands/next?limit=5", nil)
-	if err != nil {
-		return nil, err
-	}
-
-	response, err := http.DefaultClient.Do(request)
-	if err != nil {
-		return nil, err
-	}
-	defer func() {
-		_ = response.Body.Close()
-	}()
-
-	if response.StatusCode >= 300 {
-		body, _ := io.ReadAll(response.Body)
-		return nil, fmt.Errorf("pull commands status %d: %s", response.StatusCode, summarizeResponseBody(body))
-	}
-
-	var parsed pullCommandsResponse
-	if err = json.NewDecoder(response.Body).Decode(&parsed); err != nil {
-		return nil, err
-	}
-
-	if !parsed.Success {
-		return nil, fmt.Errorf("pull commands returned success=false")
-	}
-
-	a.setConnected(true)
-
-	return parsed.Data, nil
-}
-
-func (a *Agent) reportCommandResult(ctx context.Context, jobID string, result map[string]any, execErr error) error {
-	if strings.TrimSpace(jobID) == "" {
-		return fmt.Errorf("brak job_id")
+// commandResult builds the command_result message for a finished job and
+// logs its outcome. The same message is stored in the outbox and delivered
+// over either WebSocket or HTTP.
+func (a *Agent) commandResult(jobID string, result map[string]any, execErr error) OutgoingMessage {
+	out := OutgoingMessage{
+		Type:      "command_result",
+		AgentID:   a.getServerAgentID(),
+		JobID:     jobID,
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
 	}
 
-	payload := map[string]any{}
 	if execErr != nil {
-		payload["status"] = "failed"
-		payload["error"] = execErr.Error()
+		out.Status = resultStatus(execErr)
+		out.Error = execErr.Error()
+		a.logger.Printf("Job %s %s: %v", jobID, out.Status, execErr)
 	} else {
-		payload["status"] = "completed"
-		payload["result"] = result
-	}
-
-	body, err := json.Marshal(payload)
-	if err != nil {
-		return err
-	}
-
-	request, err := a.newAPIRequest(ctx, http.MethodPost, "/api/bizanticore/agent/commands/"+jobID+"/result", bytes.NewReader(body))
-	if err != nil {
-		return err
-	}
-	request.Header.Set("Content-Type", "application/json")
-
-	response, err := http.DefaultClient.Do(request)
-	if err != nil {
-		return err
+		out.Status = "completed"
+		out.Data = result
+		a.logger.Printf("Job %s completed", jobID)
 	}
-	defer func() {
-		_ = response.Body.Close()
-	}()
 
-	if response.StatusCode >= 300 {
-		responseBody, _ := io.ReadAll(response.Body)
-		return fmt.Errorf("report result status %d: %s", response.StatusCode, strings.TrimSpace(string(responseBody)))
-	}
+	return out
+}
 
-	if execErr != nil {
-		a.logger.Printf("Job %s failed: %v", jobID, execErr)
+// resultPayload is the body of the HTTP result endpoint for one job.
+func resultPayload(out OutgoingMessage) map[string]any {
+	payload := map[string]any{"status": out.Status}
+	if out.Error != "" {
+		payload["error"] = out.Error
 	} else {
-		a.logger.Printf("Job %s completed", jobID)
+		payload["result"] = out.Data
 	}
 
-	return nil
+	return payload
 }
 
-func summarizeResponseBody(body []byte) string {
-	text := strings.TrimSpace(string(body))
-	if text == "" {
-		return "(pusta odpowiedź)"
+// reportCommandResult posts one result. The server keys results by job_id,
+// so the call is safe to retry.
+func (a *Agent) reportCommandResult(ctx context.Context, out OutgoingMessage) error {
+	jobID := out.JobID
+	if strings.TrimSpace(jobID) == "" {
+		return fmt.Errorf("brak job_id")
 	}
 
-	lower := strings.ToLower(text)
-	if strings.Contains(lower, "<!doctype") || strings.Contains(lower, "<html") {
-		return "(odpowiedź HTML pominięta)"
-	}
+	return a.api().Do(ctx, api.Call{
+		Op:         "report result",
+		Method:     http.MethodPost,
+		Path:       "/api/bizanticore/agent/commands/" + jobID + "/result",
+		Body:       resultPayload(out),
+		Idempotent: true,
+	}, nil)
+}
 
-	const maxLen = 200
-	if len(text) > maxLen {
-		return text[:maxLen] + "..."
+// rateLimitDelay reports whether err is an HTTP 429 and the delay the server
+// asked for.
+func rateLimitDelay(err error) (time.Duration, bool) {
+	var rateErr *api.RateLimitError
+	if !errors.As(err, &rateErr) {
+		return 0, false
 	}
 
-	return text
+	return rateErr.RetryAfter, true
 }
 
-func (a *Agent) newAPIRequest(ctx context.Context, method string, path string, body io.Reader) (*http.Request, error) {
-	base := strings.TrimRight(strings.TrimSpace(a.cfg.ServerURL), "/")
-	if base == "" {
-		return nil, fmt.Errorf("server_url is empty")
-	}
-
-	pathPart := path
-	if !strings.HasPrefix(pathPart, "/") {
-		pathPart = "/" + pathPart
-	}
+// api returns a client for the current server URL and token.
+func (a *Agent) api() *api.Client {
+	settings := a.endpoint()
 
-	request, err := http.NewRequestWithContext(ctx, method, base+pathPart, body)
-	if err != nil {
-		return nil, err
+	return &api.Client{
+		HTTP:      a.httpClient(),
+		ServerURL: settings.ServerURL,
+		Token:     settings.Token,
+		TenantID:  settings.TenantID,
 	}
-
-	request.Header.Set("Authorization", "Bearer "+a.cfg.AgentToken)
-
-	return request, nil
 }
 
 func (a *Agent) runSession(ctx context.Context) error {
+	settings := a.endpoint()
 	headers := http.Header{}
-	headers.Set("Authorization", "Bearer "+a.cfg.AgentToken)
-	if strings.TrimSpace(a.cfg.TenantID) != "" {
-		headers.Set("X-Tenant-ID", a.cfg.TenantID)
+	headers.Set("Authorization", "Bearer "+settings.Token)
+	if strings.TrimSpace(settings.TenantID) != "" {
+		headers.Set("X-Tenant-ID", settings.TenantID)
 	}
 
-	conn, response, err := websocket.DefaultDialer.DialContext(ctx, a.cfg.WebSocketURL, headers)
+	conn, response, err := a.wsDialer().DialContext(ctx, settings.WebSocketURL, headers)
 	if err != nil {
 		if response != nil {
+			if authErr := authStatusError("websocket", response.StatusCode); authErr != nil {
+				return authErr
+			}
+			if response.StatusCode >= 500 {
+				return &api.ServerError{Op: "websocket", StatusCode: response.StatusCode, Body: err.Error()}
+			}
 			return fmt.Errorf("błąd połączenia websocket (http %d): %w", response.StatusCode, err)
 		}
 
 		return err
 	}
+	session := newWSSession(conn,
+		time.Duration(a.cfg.WSPingSeconds)*time.Second,
+		time.Duration(a.cfg.WSDeadPeerSeconds)*time.Second)
 	defer func() {
+		a.setSession(nil)
+		session.close(nil)
 		a.setConnected(false)
 		_ = conn.Close()
 	}()
 
-	a.logger.Printf("Połączono z Bizanti WebSocket: %s", a.cfg.WebSocketURL)
+	a.logger.Printf("Połączono z Bizanti WebSocket: %s", settings.WebSocketURL)
 
-	if err = conn.WriteJSON(OutgoingMessage{
-		Type:      "auth",
-		AgentID:   a.getServerAgentID(),
-		Status:    "online",
-		Timestamp: time.Now().UTC().Format(time.RFC3339),
-	}); err != nil {
+	if err = session.send(a.authMessage()); err != nil {
 		return err
 	}
 
+	a.setSession(session)
 	a.setConnected(true)
 
+	if err = a.replayOutboxWS(session); err != nil {
+		return err
+	}
+	if err = a.flushEventsWS(session); err != nil {
+		return err
+	}
+
 	heartbeatEvery := time.Duration(a.cfg.HeartbeatSeconds) * time.Second
 	if a.cfg.HeartbeatSeconds <= 0 {
 		heartbeatEvery = 30 * time.Second
@@ -586,334 +785,248 @@ func (a *Agent) runSession(ctx context.Context) error {
 	heartbeatTicker := time.NewTicker(heartbeatEvery)
 	defer heartbeatTicker.Stop()
 
+	// Ends the HTTP fallback for unacked results with the session.
+	sessionCtx, cancelSession := context.WithCancel(ctx)
+	defer cancelSession()
+	var fallbackRunning atomic.Bool
+
 	readErrors := make(chan error, 1)
 	readMessages := make(chan IncomingMessage, 8)
 
 	go func() {
 		for {
 			var message IncomingMessage
-			if readErr := conn.ReadJSON(&message); readErr != nil {
+			if readErr := session.readJSON(&message); readErr != nil {
 				readErrors <- readErr
 				return
 			}
 
-			readMessages <- message
+			select {
+			case readMessages <- message:
+			case <-session.done:
+				return
+			}
 		}
 	}()
 
 	for {
 		select {
 		case <-ctx.Done():
-			_ = conn.WriteJSON(OutgoingMessage{Type: "status", Status: "offline"})
+			session.close(&OutgoingMessage{Type: "status", Status: "offline"})
 			return context.Canceled
 		case err = <-readErrors:
 			return err
+		case err = <-session.errors():
+			return err
 		case message := <-readMessages:
-			a.handleIncoming(conn, message)
+			a.handleIncoming(ctx, session, message)
+		case <-a.eventsReady:
+			if err = a.flushEventsWS(session); err != nil {
+				return err
+			}
+		case <-a.resultsReady:
+			// A job polled over HTTP before the reconnect has finished.
+			if err = a.replayOutboxWS(session); err != nil {
+				return err
+			}
 		case <-heartbeatTicker.C:
-			_ = conn.WriteJSON(OutgoingMessage{
-				Type:      "heartbeat",
-				AgentID:   a.getServerAgentID(),
-				Timestamp: time.Now().UTC().Format(time.RFC3339),
-				Status:    "online",
+			_ = session.send(OutgoingMessage{
+				Type:            "heartbeat",
+				AgentID:         a.getServerAgentID(),
+				Timestamp:       time.Now().UTC().Format(time.RFC3339),
+				Status:          "online",
+				Capabilities:    a.capabilities(),
+				ProtocolVersion: ProtocolVersion,
+				Features:        agentFeatures,
 			})
+			// HTTP round-trips must not hold up reading frames and pings.
+			if fallbackRunning.CompareAndSwap(false, true) {
+				a.wg.Add(1)
+				go func() {
+					defer a.wg.Done()
+					defer fallbackRunning.Store(false)
+					if fallbackErr := a.deliverUnackedHTTP(sessionCtx, session, resultAckTimeout); fallbackErr != nil && sessionCtx.Err() == nil {
+						a.logger.Printf("Błąd raportowania niepotwierdzonych wyników (zostają w outbox): %v", fallbackErr)
+					}
+				}()
+			}
 		}
 	}
 }
 
-func (a *Agent) handleIncoming(conn *websocket.Conn, message IncomingMessage) {
-	messageType := strings.ToLower(strings.TrimSpace(message.Type))
-	commandName := strings.ToLower(strings.TrimSpace(message.Command))
-
-	switch {
-	case messageType == "ping" || commandName == "ping":
-		_ = conn.WriteJSON(OutgoingMessage{
-			Type:      "pong",
-			AgentID:   a.getServerAgentID(),
-			Timestamp: time.Now().UTC().Format(time.RFC3339),
-			JobID:     message.JobID,
-		})
-		return
-
-	case messageType == "command":
-		result, err := a.executeCommand(commandName, message.Payload)
-		out := OutgoingMessage{
-			Type:      "command_result",
-			AgentID:   a.getServerAgentID(),
-			JobID:     message.JobID,
-			Timestamp: time.Now().UTC().Format(time.RFC3339),
-		}
-
-		if err != nil {
-			out.Status = "failed"
-			out.Error = err.Error()
-			a.logger.Printf("Job %s failed: %v", message.JobID, err)
-		} else {
-			out.Status = "completed"
-			out.Data = result
-			a.logger.Printf("Job %s completed", message.JobID)
-		}
-
-		_ = conn.WriteJSON(out)
-		return
-	}
+// authMessage opens a WebSocket session. Resume details are left out once
+// the server has said it does not support them.
+func (a *Agent) authMessage() OutgoingMessage {
+	auth := OutgoingMessage{
+		Type:            "auth",
+		AgentID:         a.getServerAgentID(),
+		Status:          "online",
+		Timestamp:       time.Now().UTC().Format(time.RFC3339),
+		Capabilities:    a.capabilities(),
+		ProtocolVersion: ProtocolVersion,
+		Features:        agentFeatures,
+	}
+	if a.serverSupports(featureResume) {
+		auth.ResumeToken = a.getResumeToken()
+		auth.InFlight = a.activeJobIDs()
+		auth.Unacked = a.unackedJobIDs()
+	}
+
+	return auth
 }
 
-func (a *Agent) executeCommand(command string, rawPayload json.RawMessage) (map[string]any, error) {
-	switch command {
-	case "weigh_and_print":
-		var payload devices.WeighAndPrintPayload
-		if err := json.Unmarshal(rawPayload, &payload); err != nil {
-			return nil, err
-		}
-
-		weight := payload.WeightKg
-		var rawResponse string
-		if weight == nil {
-			value, response, err := a.readWeightWithIntermecFallback(payload.Scale, payload.Printer)
-			if err != nil {
-				return nil, err
-			}
-			weight = &value
-			rawResponse = response
-		}
-
-		replace := map[string]string{}
-		for key, value := range payload.Context {
-			replace[key] = value
-		}
-		replace["weight"] = fmt.Sprintf("%.3f", *weight)
-		replace["weight_kg"] = fmt.Sprintf("%.3f kg", *weight)
-
-		rendered := devices.RenderTemplate(payload.Template, replace)
-		if err := devices.SendToPrinter(payload.Printer, rendered); err != nil {
-			return nil, err
-		}
-
-		return map[string]any{
-			"weight":       *weight,
-			"raw_response": rawResponse,
-			"printer":      payload.Printer.Model,
-		}, nil
-
-	case "print_label":
-		var payload devices.WeighAndPrintPayload
-		if err := json.Unmarshal(rawPayload, &payload); err != nil {
-			return nil, err
-		}
-
-		replace := map[string]string{}
-		for key, value := range payload.Context {
-			replace[key] = value
-		}
-		if payload.WeightKg != nil {
-			replace["weight"] = fmt.Sprintf("%.3f", *payload.WeightKg)
-			replace["weight_kg"] = fmt.Sprintf("%.3f kg", *payload.WeightKg)
-		}
-
-		rendered := devices.RenderTemplate(payload.Template, replace)
-
-		// For Dibal direct transport, use the persistent DibalManager instead
-		// of the per-job listener inside devices.SendToPrinter.
-		printerTransport := strings.ToLower(strings.TrimSpace(payload.Printer.Transport))
-		if printerTransport == "dibal_direct" || printerTransport == "dibal" ||
-			printerTransport == "dibal_tcp_server" || printerTransport == "dibal_server" {
-			if strings.Contains(rendered, "^XA") || strings.Contains(rendered, "^XZ") {
-				return nil, fmt.Errorf("szablon ZPL nie jest obsługiwany przez Dibal; użyj linii rejestrów Dibal (np. X1;...)")
-			}
-
-			rxPort := payload.Printer.DibalRXPort
-			if rxPort <= 0 {
-				rxPort = 3000
-			}
-			txPort := 0 // TX port not needed for print-only; manager defaults to 3001
-			if payload.Scale.TXPort > 0 {
-				txPort = payload.Scale.TXPort
-			}
-			mgr := a.getOrCreateDibalManager(payload.Printer.DibalBindHost, rxPort, txPort, payload.Printer.DibalAddr)
-
-			writeTimeout := 8 * time.Second
-			if payload.Printer.WriteTimeoutS > 0 {
-				writeTimeout = time.Duration(payload.Printer.WriteTimeoutS) * time.Second
-			}
-
-			if !mgr.WaitForRXConnected(writeTimeout) {
-				return nil, fmt.Errorf("waga Dibal nie jest połączona na porcie RX %d — sprawdź konfigurację Lantronix (Remote IP = IP tego komputera)", rxPort)
-			}
-
-			if err := devices.SendDibalContentPersistent(mgr, rendered, writeTimeout); err != nil {
-				return nil, err
-			}
-			return map[string]any{"printer": payload.Printer.Model}, nil
-		}
-
-		if err := devices.SendToPrinter(payload.Printer, rendered); err != nil {
-			return nil, err
-		}
-
-		return map[string]any{
-			"printer": payload.Printer.Model,
-		}, nil
-
-	case "read_weight":
-		var payload devices.WeighAndPrintPayload
-		if err := json.Unmarshal(rawPayload, &payload); err != nil {
-			return nil, err
-		}
-
-		weight, response, err := a.readWeightWithIntermecFallback(payload.Scale, payload.Printer)
-		if err != nil {
-			return nil, err
-		}
+func (a *Agent) setSession(session *wsSession) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
 
-		return map[string]any{
-			"weight":       weight,
-			"raw_response": response,
-		}, nil
-
-	case "program_dibal_plu":
-		// Programs a PLU record directly into a Dibal K-series scale via TCP.
-		// Does NOT require Windows Spooler or any Windows scale driver.
-		// Uses the persistent DibalManager — scale connects once via Lantronix.
-		var payload devices.DibalProgramPayload
-		if err := json.Unmarshal(rawPayload, &payload); err != nil {
-			return nil, err
-		}
+	a.session = session
+}
 
-		rxPort := payload.Scale.RXPort
-		if rxPort <= 0 {
-			rxPort = 3000
-		}
-		txPort := payload.Scale.TXPort
-		if txPort <= 0 {
-			txPort = 3001
-		}
-		timeout := 5 * time.Second
-		if payload.Scale.ReadTimeoutMs > 0 {
-			timeout = time.Duration(payload.Scale.ReadTimeoutMs) * time.Millisecond
-		}
+func (a *Agent) getResumeToken() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
 
-		mgr := a.getOrCreateDibalManager(payload.Scale.BindHost, rxPort, txPort, payload.Scale.DibalAddr)
-		if !mgr.WaitForRXConnected(timeout) {
-			return nil, fmt.Errorf("waga Dibal nie jest połączona na porcie RX %d — sprawdź konfigurację Lantronix (Remote IP = IP tego komputera)", rxPort)
-		}
+	return a.resumeToken
+}
 
-		a.logger.Printf("program_dibal_plu: PLU=%s '%s'", payload.PLU.Code, payload.PLU.Name)
+func (a *Agent) setResumeToken(token string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
 
-		if err := devices.SendDibalPLUPersistent(mgr, payload.PLU, timeout); err != nil {
-			return nil, fmt.Errorf("błąd programowania PLU Dibal: %w", err)
-		}
+	a.resumeToken = token
+}
 
-		a.logger.Printf("program_dibal_plu: PLU %s zaprogramowany pomyślnie", payload.PLU.Code)
+// deliverWS sends a job message over whichever WebSocket session is current
+// when the job finishes, not the one it arrived on, so a job that outlives a
+// reconnect reports into the resumed session. Without a session the result
+// waits in the outbox for the next replay.
+func (a *Agent) deliverWS(out OutgoingMessage) {
+	a.mu.Lock()
+	session := a.session
+	a.mu.Unlock()
 
-		return map[string]any{
-			"plu_code": payload.PLU.Code,
-			"plu_name": payload.PLU.Name,
-		}, nil
+	var written func()
+	if out.Type == "command_result" && !a.serverSupports(featureAck) {
+		// The server will never ack; a written result counts as delivered.
+		written = func() { a.ackResult(out.JobID) }
+	}
 
+	sendErr := errSessionClosed
+	switch {
+	case session == nil:
+	case out.Type == "command_result" && !out.Cached:
+		_, sendErr = session.sendResult(out, written)
 	default:
-		return nil, fmt.Errorf("nieobsługiwana komenda: %s", command)
+		sendErr = session.queue(outgoing{message: out, written: written})
+	}
+	if sendErr != nil && out.Type == "command_result" {
+		a.logger.Printf("Błąd wysyłania wyniku job %s (zostaje w outbox): %v", out.JobID, sendErr)
 	}
 }
 
-func (a *Agent) readWeightWithIntermecFallback(scale devices.ScaleConfig, printer devices.PrinterConfig) (float64, string, error) {
-	transport := strings.ToLower(strings.TrimSpace(scale.Transport))
-	if transport == "tcp_server" || transport == "server_tcp" || transport == "dibal_tcp_server" || transport == "dibal_server" {
-		bindHost := strings.TrimSpace(scale.BindHost)
-		if bindHost == "" {
-			bindHost = "0.0.0.0"
-		}
-
-		txPort := scale.TXPort
-		if txPort <= 0 {
-			if scale.TCPPort > 0 {
-				txPort = scale.TCPPort
-			} else {
-				txPort = 3001
-			}
-		}
-
-		rxPort := scale.RXPort
-		if rxPort <= 0 {
-			rxPort = 3000
-		}
+func (a *Agent) handleIncoming(ctx context.Context, session *wsSession, message IncomingMessage) {
+	messageType := strings.ToLower(strings.TrimSpace(message.Type))
+	commandName := strings.ToLower(strings.TrimSpace(message.Command))
 
-		a.logger.Printf("Tryb Dibal TCP server: nasłuch TX=%s:%d RX=%s:%d request=%t", bindHost, txPort, bindHost, rxPort, strings.TrimSpace(scale.RequestCommand) != "")
+	switch {
+	case messageType == "ping" || commandName == "ping":
+		_ = session.send(OutgoingMessage{
+			Type:      "pong",
+			AgentID:   a.getServerAgentID(),
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			JobID:     message.JobID,
+		})
+		return
 
-		mgr := a.getOrCreateDibalManager(bindHost, rxPort, txPort, scale.DibalAddr)
-		timeout := 5 * time.Second
-		if scale.ReadTimeoutMs > 0 {
-			timeout = time.Duration(scale.ReadTimeoutMs) * time.Millisecond
-		}
-		if !mgr.WaitForTXConnected(timeout) {
-			return 0, "", fmt.Errorf("waga Dibal nie jest połączona na porcie TX %d", txPort)
-		}
+	case messageType == "cancel":
+		a.handleCancel(message)
+		return
 
-		weight, response, err := devices.ReadWeightPersistent(mgr, scale)
-		if err == nil {
-			a.logger.Printf("Dibal TCP server: odebrano odczyt wagi: %s", response)
-			return weight, response, nil
+	case messageType == "auth_ok":
+		a.negotiateProtocol(message.ProtocolVersion, message.Features)
+		if token := strings.TrimSpace(message.ResumeToken); token != "" {
+			a.setResumeToken(token)
 		}
+		return
 
-		a.logger.Printf("Dibal TCP server: błąd odczytu: %v", err)
-		return 0, "", err
-	}
-
-	weight, response, err := devices.ReadWeight(scale)
-	if err == nil {
-		if transport == "tcp_server" || transport == "server_tcp" || transport == "dibal_tcp_server" || transport == "dibal_server" {
-			a.logger.Printf("Dibal TCP server: odebrano odczyt wagi: %s", response)
-		}
+	case messageType == "ack":
+		// Server confirmed it stored the result; drop it from the outbox.
+		a.ackResult(message.JobID)
+		return
 
-		return weight, response, nil
+	case messageType == "command":
+		a.dispatchCommand(ctx, message, a.deliverWS)
+		return
 	}
+}
 
-	if transport == "tcp_server" || transport == "server_tcp" || transport == "dibal_tcp_server" || transport == "dibal_server" {
-		a.logger.Printf("Dibal TCP server: błąd odczytu: %v", err)
-	}
+// dispatchCommand runs a command on the job scheduler so slow device I/O never
+// blocks the read loop or the heartbeat. The finished result is stored in the
+// outbox and then handed to deliver from the worker goroutine.
+func (a *Agent) dispatchCommand(ctx context.Context, message IncomingMessage, deliver func(OutgoingMessage)) {
+	commandName := strings.ToLower(strings.TrimSpace(message.Command))
 
-	if !shouldTryIntermecBridge(scale, printer) {
-		return 0, "", err
+	// Rejected commands are reported but not remembered, so a forged copy
+	// cannot take over the dedupe entry of a genuine job_id.
+	if a.verifier != nil {
+		if err := a.verifier.verify(message); err != nil {
+			a.logger.Printf("Job %s (%s): %v", message.JobID, commandName, err)
+			out := a.commandResult(message.JobID, nil, err)
+			a.storeResult(out)
+			deliver(out)
+			return
+		}
 	}
 
-	fallbackScale := scale
-	fallbackScale.Transport = "tcp"
-	if strings.TrimSpace(fallbackScale.TCPHost) == "" {
-		fallbackScale.TCPHost = strings.TrimSpace(printer.Host)
-	}
-	if fallbackScale.TCPPort <= 0 {
-		if printer.Port > 0 {
-			fallbackScale.TCPPort = printer.Port
-		} else {
-			fallbackScale.TCPPort = 9100
+	if wantsDedupe(message) {
+		if cached, ok := a.recentResult(message.JobID); ok {
+			a.logger.Printf("Job %s był już wykonany — odsyłam zapisany wynik (%s)", message.JobID, cached.Status)
+			cached.AgentID = a.getServerAgentID()
+			cached.Cached = true
+			a.storeResult(cached)
+			deliver(cached)
+			return
 		}
 	}
 
-	fallbackWeight, fallbackResponse, fallbackErr := devices.ReadWeight(fallbackScale)
-	if fallbackErr != nil {
-		return 0, "", fmt.Errorf("%w; fallback przez Intermec PM43 (%s:%d) nie powiódł się: %v", err, fallbackScale.TCPHost, fallbackScale.TCPPort, fallbackErr)
+	jobCtx, done, ok := a.startJob(ctx, message)
+	if !ok {
+		a.logger.Printf("Job %s jest już w trakcie wykonywania — duplikat pominięty", message.JobID)
+		return
 	}
+	jobCtx = withProgress(jobCtx, a.progressReporter(message.JobID, deliver))
+	started := time.Now()
 
-	a.logger.Printf("Odczyt wagi przez fallback Intermec PM43 (%s:%d)", fallbackScale.TCPHost, fallbackScale.TCPPort)
-
-	return fallbackWeight, fallbackResponse, nil
-}
-
-func shouldTryIntermecBridge(scale devices.ScaleConfig, printer devices.PrinterConfig) bool {
-	transport := strings.ToLower(strings.TrimSpace(scale.Transport))
-	if transport != "serial" && transport != "rs232" && transport != "com" {
-		return false
+	finish := func(result map[string]any, err error) {
+		defer done()
+		out := a.commandResult(message.JobID, result, jobError(jobCtx, err))
+		observeJob(commandName, out.Status, started)
+		a.rememberResult(out)
+		a.storeResult(out)
+		a.publishMQTTResult(out)
+		a.notifyWebhooks(webhook.TypeResult, commandName, out)
+		deliver(out)
 	}
 
-	model := strings.ToLower(strings.TrimSpace(printer.Model))
-	if model != "" && !strings.Contains(model, "intermec") && !strings.Contains(model, "pm43") {
-		return false
+	if jobCtx.Err() != nil {
+		finish(nil, jobCtx.Err())
+		return
 	}
 
-	printerTransport := strings.ToLower(strings.TrimSpace(printer.Transport))
-	if printerTransport != "" && printerTransport != "raw_tcp" && printerTransport != "tcp" && printerTransport != "network" && printerTransport != "jetdirect" {
-		return false
+	if err := a.enforcePolicy(message.JobID, commandName, message.Payload); err != nil {
+		finish(nil, err)
+		return
 	}
 
-	return strings.TrimSpace(printer.Host) != ""
+	err := a.jobs.submit(jobCtx, commandDeviceKeys(commandName, message.Payload),
+		func(runCtx context.Context) {
+			finish(a.executeCommand(runCtx, commandName, message.Payload))
+		},
+		func(abortErr error) {
+			finish(nil, abortErr)
+		},
+	)
+	if err != nil {
+		finish(nil, err)
+	}
 }
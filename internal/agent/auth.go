@@ -0,0 +1,199 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/NowakAdmin/BizantiAgent/internal/api"
+	"github.com/NowakAdmin/BizantiAgent/internal/config"
+	"github.com/NowakAdmin/BizantiAgent/internal/enroll"
+	"github.com/NowakAdmin/BizantiAgent/internal/version"
+)
+
+// credentialsCheckInterval is how often config.json is re-read while the
+// agent waits for a new token after a 401/403.
+const credentialsCheckInterval = 10 * time.Second
+
+// AuthError reports that the server rejected the agent token (HTTP 401/403).
+// Unlike network errors it is not retried: the agent waits for a new token.
+type AuthError = api.AuthError
+
+// authStatusError returns an *AuthError for 401 and 403 responses outside
+// the API client, i.e. the WebSocket handshake.
+func authStatusError(op string, statusCode int) error {
+	if statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden {
+		return &AuthError{Op: op, StatusCode: statusCode}
+	}
+
+	return nil
+}
+
+// endpointSettings are the connection settings that can change at runtime
+// through token rotation or re-enrollment.
+type endpointSettings struct {
+	ServerURL    string
+	WebSocketURL string
+	Token        string
+	TenantID     string
+}
+
+func (a *Agent) endpoint() endpointSettings {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return endpointSettings{
+		ServerURL:    a.cfg.ServerURL,
+		WebSocketURL: a.cfg.WebSocketURL,
+		Token:        a.cfg.AgentToken,
+		TenantID:     a.cfg.TenantID,
+	}
+}
+
+// applyCredentials persists new credentials with config.Save and only then
+// switches the running agent to them, so a failed write never leaves the
+// agent using a token that will be lost on restart. Empty fields other than
+// the token keep their current value.
+func (a *Agent) applyCredentials(credentials enroll.Credentials) error {
+	token := strings.TrimSpace(credentials.AgentToken)
+	if token == "" {
+		return errors.New("pusty token agenta")
+	}
+
+	a.credentialsMu.Lock()
+	defer a.credentialsMu.Unlock()
+
+	// The save does disk I/O (and DPAPI on Windows), so it runs on a copy
+	// without holding mu.
+	a.mu.Lock()
+	updated := *a.cfg
+	a.mu.Unlock()
+
+	updated.AgentToken = token
+	if tenant := strings.TrimSpace(credentials.TenantID); tenant != "" {
+		updated.TenantID = tenant
+	}
+	if serverURL := strings.TrimSpace(credentials.ServerURL); serverURL != "" {
+		updated.ServerURL = serverURL
+	}
+	if wsURL := strings.TrimSpace(credentials.WebSocketURL); wsURL != "" {
+		updated.WebSocketURL = wsURL
+	}
+
+	if err := config.Save(&updated); err != nil {
+		return fmt.Errorf("nie udało się zapisać konfiguracji: %w", err)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.cfg.AgentToken = updated.AgentToken
+	a.cfg.TenantID = updated.TenantID
+	a.cfg.ServerURL = updated.ServerURL
+	a.cfg.WebSocketURL = updated.WebSocketURL
+
+	return nil
+}
+
+// handleAuthFailure reports whether err is an auth failure. If so it blocks,
+// without hammering the server, until new credentials are available (token
+// rotated on disk or re-enrollment approved) or ctx ends.
+func (a *Agent) handleAuthFailure(ctx context.Context, err error) bool {
+	var authErr *AuthError
+	if !errors.As(err, &authErr) {
+		return false
+	}
+
+	a.logger.Printf("Token agenta odrzucony (%v). Wstrzymuję łączenie do czasu nowego tokena (bizanti-agent configure --token / enroll).", authErr)
+	a.setUnauthorized(true, "")
+	defer a.setUnauthorized(false, "")
+
+	a.awaitCredentials(ctx)
+	a.recordSuccess()
+
+	return true
+}
+
+func (a *Agent) awaitCredentials(ctx context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	enrolled := make(chan enroll.Credentials, 1)
+	if a.cfg.ReenrollOnUnauthorized {
+		go a.reenroll(ctx, enrolled)
+	}
+
+	rejected := a.endpoint().Token
+	ticker := time.NewTicker(credentialsCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case credentials := <-enrolled:
+			if err := a.applyCredentials(credentials); err != nil {
+				a.logger.Printf("Ponowna rejestracja: %v", err)
+				continue
+			}
+			a.logger.Printf("Ponowna rejestracja zakończona — nowy token zapisany")
+			return
+		case <-ticker.C:
+			onDisk, err := config.Load()
+			if err != nil || strings.TrimSpace(onDisk.AgentToken) == "" || onDisk.AgentToken == rejected {
+				continue
+			}
+			if err = a.applyCredentials(enroll.Credentials{AgentToken: onDisk.AgentToken, TenantID: onDisk.TenantID}); err != nil {
+				a.logger.Printf("Nie udało się przyjąć nowego tokena: %v", err)
+				continue
+			}
+			a.logger.Printf("Wykryto nowy token agenta w konfiguracji — wznawiam łączenie")
+			return
+		}
+	}
+}
+
+// reenroll runs device-code enrollment until it succeeds or ctx ends. The
+// pairing code is shown in the status so it can be read off the tray.
+func (a *Agent) reenroll(ctx context.Context, enrolled chan<- enroll.Credentials) {
+	hostname, _ := os.Hostname()
+	client := &enroll.Client{ServerURL: a.endpoint().ServerURL, HTTP: a.httpClient()}
+
+	for ctx.Err() == nil {
+		pairing, err := client.Start(ctx, enroll.DeviceInfo{Hostname: hostname, Version: version.Version, OS: runtime.GOOS})
+		if err == nil {
+			a.logger.Printf("Ponowna rejestracja: kod parowania %s %s", pairing.UserCode, pairing.VerificationURL)
+			a.setUnauthorized(true, pairing.UserCode)
+
+			var credentials *enroll.Credentials
+			if credentials, err = client.Wait(ctx, pairing); err == nil {
+				enrolled <- *credentials
+				return
+			}
+		}
+
+		if ctx.Err() != nil {
+			return
+		}
+		a.logger.Printf("Ponowna rejestracja nieudana: %v — ponawiam za minutę", err)
+		a.setUnauthorized(true, "")
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Minute):
+		}
+	}
+}
+
+func (a *Agent) setUnauthorized(unauthorized bool, pairingCode string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.unauthorized = unauthorized
+	a.pairingCode = pairingCode
+}
@@ -0,0 +1,129 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/NowakAdmin/BizantiAgent/internal/config"
+)
+
+func TestRotateTokenPersistsBeforeSwitching(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+
+	a := newTestAgent(t)
+	a.cfg = &config.Config{ServerURL: "https://example.test", AgentToken: "old", TenantID: "7"}
+
+	out := dispatchAndWait(t, a, IncomingMessage{
+		Type:    "command",
+		JobID:   "1",
+		Command: "rotate_token",
+		Payload: json.RawMessage(`{"agent_token":"new"}`),
+	})
+	if out.Status != "completed" {
+		t.Fatalf("rotate_token failed: %+v", out)
+	}
+	if got := a.endpoint(); got.Token != "new" || got.TenantID != "7" {
+		t.Fatalf("running agent not switched: %+v", got)
+	}
+
+	saved, err := config.Load()
+	if err != nil {
+		t.Fatalf("load saved config: %v", err)
+	}
+	if saved.AgentToken != "new" || saved.ServerURL != "https://example.test" {
+		t.Fatalf("token not persisted: %+v", saved)
+	}
+}
+
+func TestRotateTokenKeepsOldTokenWhenSaveFails(t *testing.T) {
+	// A regular file in place of the config directory makes Save fail.
+	blocker := filepath.Join(t.TempDir(), "blocker")
+	if err := os.WriteFile(blocker, nil, 0o600); err != nil {
+		t.Fatalf("write blocker: %v", err)
+	}
+	t.Setenv("XDG_CONFIG_HOME", blocker)
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+
+	a := newTestAgent(t)
+	a.cfg = &config.Config{AgentToken: "old"}
+
+	out := dispatchAndWait(t, a, IncomingMessage{
+		Type:    "command",
+		JobID:   "1",
+		Command: "rotate_token",
+		Payload: json.RawMessage(`{"agent_token":"new"}`),
+	})
+	if out.Status != "failed" {
+		t.Fatalf("expected failure, got %+v", out)
+	}
+	if strings.Contains(out.Error, "new") {
+		t.Fatalf("error leaks the token: %q", out.Error)
+	}
+	if got := a.endpoint().Token; got != "old" {
+		t.Fatalf("token switched despite failed save: %q", got)
+	}
+}
+
+func TestAuthStatusError(t *testing.T) {
+	for _, code := range []int{http.StatusUnauthorized, http.StatusForbidden} {
+		var authErr *AuthError
+		if err := authStatusError("op", code); !errors.As(err, &authErr) || authErr.StatusCode != code {
+			t.Fatalf("status %d: expected AuthError, got %v", code, err)
+		}
+	}
+	if err := authStatusError("op", http.StatusInternalServerError); err != nil {
+		t.Fatalf("500 must be retried as a normal error, got %v", err)
+	}
+}
+
+func TestPullCommandsReportsUnauthorized(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	a := newTestAgent(t)
+	a.cfg = &config.Config{ServerURL: server.URL, AgentToken: "revoked"}
+
+	_, _, err := a.pullCommands(context.Background(), 0)
+
+	var authErr *AuthError
+	if !errors.As(err, &authErr) {
+		t.Fatalf("expected AuthError, got %v", err)
+	}
+}
+
+func TestUnauthorizedStatus(t *testing.T) {
+	a := newTestAgent(t)
+	a.cfg = &config.Config{AgentToken: "revoked"}
+	a.running.Store(true)
+
+	a.setUnauthorized(true, "")
+	if got := a.GetStatus(); got != "Unauthorized" {
+		t.Fatalf("unexpected status %q", got)
+	}
+	a.setUnauthorized(true, "ABCD-1234")
+	if got := a.GetStatus(); !strings.Contains(got, "ABCD-1234") {
+		t.Fatalf("pairing code missing from status %q", got)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if a.handleAuthFailure(ctx, errors.New("timeout")) {
+		t.Fatalf("network error treated as auth failure")
+	}
+	if !a.handleAuthFailure(ctx, &AuthError{Op: "heartbeat", StatusCode: http.StatusUnauthorized}) {
+		t.Fatalf("auth failure not recognised")
+	}
+	if strings.HasPrefix(a.GetStatus(), "Unauthorized") {
+		t.Fatalf("status not cleared after credentials wait ended")
+	}
+}
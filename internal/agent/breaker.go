@@ -0,0 +1,216 @@
+package agent
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/NowakAdmin/BizantiAgent/internal/api"
+	"github.com/NowakAdmin/BizantiAgent/internal/config"
+	"github.com/NowakAdmin/BizantiAgent/internal/transport"
+)
+
+// Circuit breaker states.
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half-open"
+)
+
+// failureClass groups connection errors that call for different handling.
+type failureClass string
+
+const (
+	// failureNetwork: refused, reset or timed-out connections, dead peers.
+	failureNetwork failureClass = "network"
+	// failureDNS: the server name does not resolve. Usually the local
+	// network or resolver is down, so the agent backs off to the maximum
+	// delay at once instead of hammering the resolver.
+	failureDNS failureClass = "dns"
+	// failureTLS: certificate or pin mismatch. Retrying cannot help until
+	// the configuration changes, so the breaker opens immediately.
+	failureTLS failureClass = "tls"
+	// failureServer: the server answered 5xx. Counts towards the breaker
+	// with the normal backoff.
+	failureServer failureClass = "server"
+)
+
+func classifyFailure(err error) failureClass {
+	var dnsErr *net.DNSError
+	var pinErr *transport.PinMismatchError
+	var verifyErr *tls.CertificateVerificationError
+	var authorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var serverErr *api.ServerError
+
+	switch {
+	case errors.As(err, &dnsErr):
+		return failureDNS
+	case errors.As(err, &pinErr), errors.As(err, &verifyErr), errors.As(err, &authorityErr), errors.As(err, &hostnameErr):
+		return failureTLS
+	case errors.As(err, &serverErr):
+		return failureServer
+	default:
+		return failureNetwork
+	}
+}
+
+// BreakerStatus is a snapshot of the reconnection circuit breaker.
+type BreakerStatus struct {
+	State       string
+	Failures    int
+	LastError   string
+	NextAttempt time.Time
+}
+
+func (s BreakerStatus) String() string {
+	switch s.State {
+	case BreakerOpen:
+		return fmt.Sprintf("otwarty — %s, próba o %s", s.LastError, s.NextAttempt.Format("15:04:05"))
+	case BreakerHalfOpen:
+		return "półotwarty — próba połączenia"
+	}
+	if s.Failures > 0 {
+		return fmt.Sprintf("zamknięty — %d błędów, ostatni: %s", s.Failures, s.LastError)
+	}
+
+	return "zamknięty"
+}
+
+// breaker spaces out reconnection attempts. While closed, failed attempts
+// are retried with jittered exponential backoff. FailureThreshold failures
+// in a row open it for a pause; the first attempt after the pause runs
+// half-open, and if it fails too the pause doubles.
+type breaker struct {
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	jitter         float64
+	threshold      int
+	pause          time.Duration
+	maxPause       time.Duration
+
+	now func() time.Time
+
+	mu           sync.Mutex
+	state        string
+	failures     int
+	lastError    string
+	backoff      time.Duration
+	currentPause time.Duration
+	nextAttempt  time.Time
+}
+
+func newBreaker(cfg config.ReconnectConfig) *breaker {
+	cfg = cfg.WithDefaults()
+
+	return &breaker{
+		initialBackoff: time.Duration(cfg.InitialBackoffSeconds) * time.Second,
+		maxBackoff:     time.Duration(cfg.MaxBackoffSeconds) * time.Second,
+		jitter:         float64(cfg.JitterPercent) / 100,
+		threshold:      cfg.FailureThreshold,
+		pause:          time.Duration(cfg.PauseSeconds) * time.Second,
+		maxPause:       time.Duration(cfg.MaxPauseSeconds) * time.Second,
+		now:            time.Now,
+		state:          BreakerClosed,
+	}
+}
+
+// spread applies ±jitter to d.
+func (b *breaker) spread(d time.Duration) time.Duration {
+	if b.jitter <= 0 || d <= 0 {
+		return d
+	}
+
+	factor := 1 - b.jitter + 2*b.jitter*rand.Float64()
+	return time.Duration(float64(d) * factor)
+}
+
+// failure records a failed attempt and returns how long to wait before the
+// next one, and whether this failure opened the breaker.
+func (b *breaker) failure(err error) (time.Duration, bool) {
+	class := classifyFailure(err)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	b.lastError = fmt.Sprintf("%s: %v", class, err)
+
+	if b.state == BreakerHalfOpen || b.failures >= b.threshold || class == failureTLS {
+		switch {
+		case b.state == BreakerHalfOpen:
+			b.currentPause = min(2*b.currentPause, b.maxPause)
+		case b.currentPause == 0:
+			b.currentPause = b.pause
+		}
+		b.state = BreakerOpen
+		delay := b.spread(b.currentPause)
+		b.nextAttempt = b.now().Add(delay)
+		return delay, true
+	}
+
+	switch {
+	case class == failureDNS:
+		b.backoff = b.maxBackoff
+	case b.backoff == 0:
+		b.backoff = b.initialBackoff
+	default:
+		b.backoff = min(2*b.backoff, b.maxBackoff)
+	}
+	delay := b.spread(b.backoff)
+	b.nextAttempt = b.now().Add(delay)
+
+	return delay, false
+}
+
+// success closes the breaker and resets the backoff.
+func (b *breaker) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.state = BreakerClosed
+	b.failures = 0
+	b.backoff = 0
+	b.currentPause = 0
+	b.nextAttempt = time.Time{}
+}
+
+// retryDelay is the delay before reconnecting after a session that ended
+// without an error.
+func (b *breaker) retryDelay() time.Duration {
+	return b.spread(b.initialBackoff)
+}
+
+// wait returns how long the breaker still blocks attempts. Once an open
+// breaker's pause has elapsed it moves to half-open and returns zero.
+func (b *breaker) wait() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state != BreakerOpen {
+		return 0
+	}
+	if remaining := b.nextAttempt.Sub(b.now()); remaining > 0 {
+		return remaining
+	}
+	b.state = BreakerHalfOpen
+
+	return 0
+}
+
+func (b *breaker) status() BreakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return BreakerStatus{
+		State:       b.state,
+		Failures:    b.failures,
+		LastError:   b.lastError,
+		NextAttempt: b.nextAttempt,
+	}
+}
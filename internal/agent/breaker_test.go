@@ -0,0 +1,104 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/NowakAdmin/BizantiAgent/internal/api"
+	"github.com/NowakAdmin/BizantiAgent/internal/config"
+	"github.com/NowakAdmin/BizantiAgent/internal/transport"
+)
+
+func newTestBreaker(now *time.Time) *breaker {
+	b := newBreaker(config.ReconnectConfig{
+		InitialBackoffSeconds: 1,
+		MaxBackoffSeconds:     8,
+		JitterPercent:         1,
+		FailureThreshold:      3,
+		PauseSeconds:          60,
+		MaxPauseSeconds:       100,
+	})
+	b.jitter = 0
+	b.now = func() time.Time { return *now }
+
+	return b
+}
+
+func TestBreakerOpensAfterThresholdAndHalfOpens(t *testing.T) {
+	now := time.Now()
+	b := newTestBreaker(&now)
+	refused := errors.New("connection refused")
+
+	for i, want := range []time.Duration{time.Second, 2 * time.Second} {
+		delay, opened := b.failure(refused)
+		if opened || delay != want {
+			t.Fatalf("failure %d: delay %v opened %t", i+1, delay, opened)
+		}
+	}
+
+	delay, opened := b.failure(&api.ServerError{Op: "heartbeat", StatusCode: 503})
+	if !opened || delay != time.Minute || b.status().State != BreakerOpen {
+		t.Fatalf("breaker should open for the pause: delay %v status %+v", delay, b.status())
+	}
+	if b.wait() != time.Minute {
+		t.Fatalf("open breaker must block attempts")
+	}
+
+	now = now.Add(time.Minute)
+	if b.wait() != 0 || b.status().State != BreakerHalfOpen {
+		t.Fatalf("expected half-open after the pause, got %+v", b.status())
+	}
+
+	// A failed probe reopens with a doubled pause, capped at the maximum.
+	if delay, _ = b.failure(refused); delay != 100*time.Second {
+		t.Fatalf("expected capped doubled pause, got %v", delay)
+	}
+
+	b.success()
+	if status := b.status(); status.State != BreakerClosed || status.Failures != 0 {
+		t.Fatalf("success should close the breaker: %+v", status)
+	}
+}
+
+func TestBreakerClassifiesFailures(t *testing.T) {
+	now := time.Now()
+
+	dns := fmt.Errorf("dial: %w", &net.DNSError{Err: "no such host", Name: "bizanti.pl"})
+	if delay, opened := newTestBreaker(&now).failure(dns); opened || delay != 8*time.Second {
+		t.Fatalf("DNS failure should jump to the maximum backoff, got %v opened %t", delay, opened)
+	}
+
+	if _, opened := newTestBreaker(&now).failure(&transport.PinMismatchError{Host: "bizanti.pl"}); !opened {
+		t.Fatalf("TLS pin mismatch should open the breaker at once")
+	}
+
+	cases := map[failureClass]error{
+		failureServer:  &api.ServerError{StatusCode: 502},
+		failureNetwork: errDeadPeer,
+		failureDNS:     dns,
+	}
+	for want, err := range cases {
+		if got := classifyFailure(err); got != want {
+			t.Fatalf("classifyFailure(%v) = %s, want %s", err, got, want)
+		}
+	}
+}
+
+func TestBreakerJitterSpreadsDelays(t *testing.T) {
+	b := newBreaker(config.ReconnectConfig{InitialBackoffSeconds: 10, JitterPercent: 50})
+
+	seen := map[time.Duration]bool{}
+	for i := 0; i < 20; i++ {
+		delay := b.retryDelay()
+		if delay < 5*time.Second || delay > 15*time.Second {
+			t.Fatalf("delay %v outside ±50%%", delay)
+		}
+		seen[delay] = true
+	}
+	if len(seen) < 2 {
+		t.Fatalf("jitter produced identical delays")
+	}
+}
@@ -0,0 +1,130 @@
+package agent
+
+import (
+	"fmt"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/NowakAdmin/BizantiAgent/internal/devices"
+	"github.com/NowakAdmin/BizantiAgent/internal/version"
+)
+
+// Capabilities describes what this station can do. It is sent with auth and
+// every heartbeat so the server only offers operations the PC supports.
+type Capabilities struct {
+	Version      string              `json:"version"`
+	OS           string              `json:"os"`
+	Arch         string              `json:"arch"`
+	Commands     []string            `json:"commands"`
+	Transports   TransportSupport    `json:"transports"`
+	DibalServers []DibalServerStatus `json:"dibal_servers"`
+	SerialPorts  []string            `json:"serial_ports"`
+}
+
+// TransportSupport lists the transports accepted in command payloads.
+type TransportSupport struct {
+	Scale   []string `json:"scale"`
+	Printer []string `json:"printer"`
+}
+
+// DibalServerStatus is a Dibal listener with its live connection state.
+// Configured reports whether it comes from dibal_servers in the config file;
+// listeners opened on demand by a command are listed too.
+type DibalServerStatus struct {
+	Name       string `json:"name,omitempty"`
+	Configured bool   `json:"configured"`
+	Enabled    bool   `json:"enabled"`
+	Running    bool   `json:"running"`
+	devices.DibalStatus
+}
+
+// capabilities builds the current capabilities document. Serial ports are
+// enumerated on every call so that USB adapters plugged in later show up.
+func (a *Agent) capabilities() *Capabilities {
+	serialPorts, err := devices.ListSerialPorts()
+	if err != nil {
+		a.logger.Printf("Nie można pobrać listy portów COM: %v", err)
+	}
+	if serialPorts == nil {
+		serialPorts = []string{}
+	}
+	sort.Strings(serialPorts)
+
+	return &Capabilities{
+		Version:  version.Version,
+		OS:       runtime.GOOS,
+		Arch:     runtime.GOARCH,
+		Commands: CommandNames(),
+		Transports: TransportSupport{
+			Scale:   devices.ScaleTransports,
+			Printer: devices.PrinterTransports,
+		},
+		DibalServers: a.dibalServerStatuses(),
+		SerialPorts:  serialPorts,
+	}
+}
+
+func (a *Agent) dibalServerStatuses() []DibalServerStatus {
+	a.dibalMu.Lock()
+	managers := make(map[string]*devices.DibalManager, len(a.dibalManagers))
+	for key, mgr := range a.dibalManagers {
+		managers[key] = mgr
+	}
+	a.dibalMu.Unlock()
+
+	statuses := make([]DibalServerStatus, 0, len(a.cfg.DibalServers)+len(managers))
+	for _, server := range a.cfg.DibalServers {
+		// Same defaults as getOrCreateDibalManager, so the entry matches the
+		// manager started for it.
+		bindHost := strings.TrimSpace(server.BindHost)
+		if bindHost == "" {
+			bindHost = "0.0.0.0"
+		}
+		rxPort := server.RXPort
+		if rxPort <= 0 {
+			rxPort = 3000
+		}
+		txPort := server.TXPort
+		if txPort <= 0 {
+			txPort = 3001
+		}
+
+		status := DibalServerStatus{
+			Name:       strings.TrimSpace(server.Name),
+			Configured: true,
+			Enabled:    server.Enabled == nil || *server.Enabled,
+			DibalStatus: devices.DibalStatus{
+				BindHost: bindHost,
+				RXPort:   rxPort,
+				TXPort:   txPort,
+				Addr:     server.Addr,
+			},
+		}
+
+		key := fmt.Sprintf("%s:%d", bindHost, rxPort)
+		if mgr, ok := managers[key]; ok {
+			status.Running = true
+			status.DibalStatus = mgr.Status()
+			delete(managers, key)
+		}
+
+		statuses = append(statuses, status)
+	}
+
+	keys := make([]string, 0, len(managers))
+	for key := range managers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		statuses = append(statuses, DibalServerStatus{
+			Enabled:     true,
+			Running:     true,
+			DibalStatus: managers[key].Status(),
+		})
+	}
+
+	return statuses
+}
@@ -0,0 +1,69 @@
+package agent
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/NowakAdmin/BizantiAgent/internal/config"
+	"github.com/NowakAdmin/BizantiAgent/internal/devices"
+	"github.com/NowakAdmin/BizantiAgent/internal/version"
+)
+
+func TestCapabilitiesDescribeStation(t *testing.T) {
+	disabled := false
+	a := newTestAgent(t)
+	a.cfg = &config.Config{
+		DibalServers: []config.DibalServerConfig{
+			{Name: "Dział mięsny", BindHost: "0.0.0.0", RXPort: 3000, TXPort: 3001},
+			{Name: "Rezerwa", RXPort: 4000, TXPort: 4001, Enabled: &disabled},
+		},
+	}
+
+	caps := a.capabilities()
+	if caps.Version != version.Version {
+		t.Fatalf("unexpected version: %q", caps.Version)
+	}
+	if !strings.Contains(strings.Join(caps.Commands, ","), "weigh_and_print") {
+		t.Fatalf("commands missing built-ins: %v", caps.Commands)
+	}
+	if len(caps.Transports.Scale) == 0 || len(caps.Transports.Printer) == 0 {
+		t.Fatalf("transports missing: %+v", caps.Transports)
+	}
+	if caps.SerialPorts == nil {
+		t.Fatalf("serial_ports must encode as a list, not null")
+	}
+
+	if len(caps.DibalServers) != 2 {
+		t.Fatalf("expected 2 dibal servers, got %+v", caps.DibalServers)
+	}
+	first, second := caps.DibalServers[0], caps.DibalServers[1]
+	if first.Name != "Dział mięsny" || !first.Enabled || first.Running || first.RXConnected {
+		t.Fatalf("unexpected first server: %+v", first)
+	}
+	if second.Enabled || second.BindHost != "0.0.0.0" || second.RXPort != 4000 {
+		t.Fatalf("unexpected second server: %+v", second)
+	}
+
+	encoded, err := json.Marshal(OutgoingMessage{Type: "auth", Capabilities: caps})
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	if !strings.Contains(string(encoded), `"rx_connected":false`) {
+		t.Fatalf("dibal state not flattened into the server entry: %s", encoded)
+	}
+}
+
+func TestCapabilitiesMatchManagerOnDefaultRXPort(t *testing.T) {
+	a := newTestAgent(t)
+	a.cfg = &config.Config{
+		DibalServers: []config.DibalServerConfig{{Name: "Domyślny", BindHost: "127.0.0.1"}},
+	}
+	// The manager started for a server without rx_port listens on 3000.
+	a.dibalManagers = map[string]*devices.DibalManager{"127.0.0.1:3000": {}}
+
+	servers := a.capabilities().DibalServers
+	if len(servers) != 1 || !servers[0].Configured || !servers[0].Running {
+		t.Fatalf("configured server and its manager not merged: %+v", servers)
+	}
+}
@@ -0,0 +1,95 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/NowakAdmin/BizantiAgent/internal/devices"
+)
+
+func init() {
+	RegisterCommand(commandSpec[devices.WeighAndPrintPayload]{
+		name: "print_label",
+		devices: func(payload *devices.WeighAndPrintPayload) []string {
+			return []string{printerDeviceKey(payload.Printer)}
+		},
+		endpoints: func(payload *devices.WeighAndPrintPayload) []endpoint {
+			return printerEndpoints(payload.Printer, payload.Scale.TXPort)
+		},
+		execute: executePrintLabel,
+	})
+}
+
+func isDibalPrinter(printer devices.PrinterConfig) bool {
+	switch strings.ToLower(strings.TrimSpace(printer.Transport)) {
+	case "dibal_direct", "dibal", "dibal_tcp_server", "dibal_server":
+		return true
+	}
+
+	return false
+}
+
+func executePrintLabel(ctx context.Context, a *Agent, payload *devices.WeighAndPrintPayload) (map[string]any, error) {
+	replace := map[string]string{}
+	for key, value := range payload.Context {
+		replace[key] = value
+	}
+	if payload.WeightKg != nil {
+		replace["weight"] = fmt.Sprintf("%.3f", *payload.WeightKg)
+		replace["weight_kg"] = fmt.Sprintf("%.3f kg", *payload.WeightKg)
+	}
+
+	rendered := devices.RenderTemplate(payload.Template, replace)
+
+	// For Dibal direct transport, use the persistent DibalManager instead
+	// of the per-job listener inside devices.SendToPrinter.
+	if isDibalPrinter(payload.Printer) {
+		if strings.Contains(rendered, "^XA") || strings.Contains(rendered, "^XZ") {
+			return nil, fmt.Errorf("szablon ZPL nie jest obsługiwany przez Dibal; użyj linii rejestrów Dibal (np. X1;...)")
+		}
+
+		rxPort := payload.Printer.DibalRXPort
+		if rxPort <= 0 {
+			rxPort = 3000
+		}
+		txPort := 0 // TX port not needed for print-only; manager defaults to 3001
+		if payload.Scale.TXPort > 0 {
+			txPort = payload.Scale.TXPort
+		}
+		mgr := a.getOrCreateDibalManager(payload.Printer.DibalBindHost, rxPort, txPort, payload.Printer.DibalAddr)
+
+		writeTimeout := 8 * time.Second
+		if payload.Printer.WriteTimeoutS > 0 {
+			writeTimeout = time.Duration(payload.Printer.WriteTimeoutS) * time.Second
+		}
+
+		if !mgr.IsRXConnected() {
+			reportProgress(ctx, phaseWaitingForScale, map[string]any{"rx_port": rxPort})
+		}
+		if !mgr.WaitForRXConnected(ctx, writeTimeout) {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			return nil, fmt.Errorf("waga Dibal nie jest połączona na porcie RX %d — sprawdź konfigurację Lantronix (Remote IP = IP tego komputera)", rxPort)
+		}
+
+		reportProgress(ctx, phaseSending, map[string]any{"printer": payload.Printer.Model})
+		if err := devices.SendDibalContentPersistent(ctx, mgr, rendered, writeTimeout); err != nil {
+			return nil, err
+		}
+		return map[string]any{"printer": payload.Printer.Model}, nil
+	}
+
+	reportProgress(ctx, phaseSending, map[string]any{"printer": payload.Printer.Model})
+	err := devices.SendToPrinter(ctx, payload.Printer, rendered)
+	a.notePrinterResult(ctx, payload.Printer, err)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]any{
+		"printer": payload.Printer.Model,
+	}, nil
+}
@@ -0,0 +1,64 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/NowakAdmin/BizantiAgent/internal/devices"
+)
+
+// program_dibal_plu programs a PLU record directly into a Dibal K-series scale
+// via TCP. Does NOT require Windows Spooler or any Windows scale driver.
+// Uses the persistent DibalManager — scale connects once via Lantronix.
+func init() {
+	RegisterCommand(commandSpec[devices.DibalProgramPayload]{
+		name: "program_dibal_plu",
+		devices: func(payload *devices.DibalProgramPayload) []string {
+			return []string{dibalDeviceKey(payload.Scale.BindHost, payload.Scale.RXPort)}
+		},
+		endpoints: func(payload *devices.DibalProgramPayload) []endpoint {
+			return dibalListenEndpoints(payload.Scale.BindHost, payload.Scale.RXPort, payload.Scale.TXPort)
+		},
+		execute: executeProgramDibalPLU,
+	})
+}
+
+func executeProgramDibalPLU(ctx context.Context, a *Agent, payload *devices.DibalProgramPayload) (map[string]any, error) {
+	rxPort := payload.Scale.RXPort
+	if rxPort <= 0 {
+		rxPort = 3000
+	}
+	txPort := payload.Scale.TXPort
+	if txPort <= 0 {
+		txPort = 3001
+	}
+	timeout := 5 * time.Second
+	if payload.Scale.ReadTimeoutMs > 0 {
+		timeout = time.Duration(payload.Scale.ReadTimeoutMs) * time.Millisecond
+	}
+
+	mgr := a.getOrCreateDibalManager(payload.Scale.BindHost, rxPort, txPort, payload.Scale.DibalAddr)
+	if !mgr.IsRXConnected() {
+		reportProgress(ctx, phaseWaitingForScale, map[string]any{"rx_port": rxPort})
+	}
+	if !mgr.WaitForRXConnected(ctx, timeout) {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("waga Dibal nie jest połączona na porcie RX %d — sprawdź konfigurację Lantronix (Remote IP = IP tego komputera)", rxPort)
+	}
+
+	a.logger.Printf("program_dibal_plu: PLU=%s '%s'", payload.PLU.Code, payload.PLU.Name)
+
+	if err := devices.SendDibalPLUPersistent(ctx, mgr, payload.PLU, timeout); err != nil {
+		return nil, fmt.Errorf("błąd programowania PLU Dibal: %w", err)
+	}
+
+	a.logger.Printf("program_dibal_plu: PLU %s zaprogramowany pomyślnie", payload.PLU.Code)
+
+	return map[string]any{
+		"plu_code": payload.PLU.Code,
+		"plu_name": payload.PLU.Name,
+	}, nil
+}
@@ -0,0 +1,41 @@
+package agent
+
+import (
+	"context"
+	"errors"
+
+	"github.com/NowakAdmin/BizantiAgent/internal/devices"
+)
+
+func init() {
+	RegisterCommand(commandSpec[devices.WeighAndPrintPayload]{
+		name: "read_weight",
+		validate: func(payload *devices.WeighAndPrintPayload) error {
+			if payload.Scale.Transport == "" {
+				return errors.New("read_weight: brak scale.transport")
+			}
+			return nil
+		},
+		devices: func(payload *devices.WeighAndPrintPayload) []string {
+			keys := []string{scaleDeviceKey(payload.Scale)}
+			if shouldTryIntermecBridge(payload.Scale, payload.Printer) {
+				keys = append(keys, printerDeviceKey(payload.Printer))
+			}
+			return keys
+		},
+		endpoints: func(payload *devices.WeighAndPrintPayload) []endpoint {
+			return append(scaleEndpoints(payload.Scale), intermecBridgeEndpoints(payload.Scale, payload.Printer)...)
+		},
+		execute: func(ctx context.Context, a *Agent, payload *devices.WeighAndPrintPayload) (map[string]any, error) {
+			weight, response, err := a.readWeightWithIntermecFallback(ctx, payload.Scale, payload.Printer)
+			if err != nil {
+				return nil, err
+			}
+
+			return map[string]any{
+				"weight":       weight,
+				"raw_response": response,
+			}, nil
+		},
+	})
+}
@@ -0,0 +1,38 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/NowakAdmin/BizantiAgent/internal/enroll"
+)
+
+// rotateTokenPayload carries a replacement agent token issued by the server.
+type rotateTokenPayload struct {
+	AgentToken string `json:"agent_token"`
+	TenantID   string `json:"tenant_id"`
+}
+
+func init() {
+	RegisterCommand(commandSpec[rotateTokenPayload]{
+		name: "rotate_token",
+		validate: func(payload *rotateTokenPayload) error {
+			if strings.TrimSpace(payload.AgentToken) == "" {
+				return errors.New("rotate_token: brak agent_token")
+			}
+			return nil
+		},
+		execute: func(ctx context.Context, a *Agent, payload *rotateTokenPayload) (map[string]any, error) {
+			// The new token is saved before it is used; the current
+			// connection keeps the old one until the next reconnect.
+			err := a.applyCredentials(enroll.Credentials{AgentToken: payload.AgentToken, TenantID: payload.TenantID})
+			if err != nil {
+				return nil, err
+			}
+
+			a.logger.Printf("Token agenta zmieniony na polecenie serwera")
+			return map[string]any{"rotated": true}, nil
+		},
+	})
+}
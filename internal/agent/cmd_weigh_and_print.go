@@ -0,0 +1,79 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/NowakAdmin/BizantiAgent/internal/devices"
+)
+
+func init() {
+	RegisterCommand(commandSpec[devices.WeighAndPrintPayload]{
+		name:     "weigh_and_print",
+		validate: validateWeighAndPrint,
+		devices: func(payload *devices.WeighAndPrintPayload) []string {
+			keys := []string{printerDeviceKey(payload.Printer)}
+			if payload.WeightKg == nil {
+				keys = append(keys, scaleDeviceKey(payload.Scale))
+			}
+			return keys
+		},
+		endpoints: func(payload *devices.WeighAndPrintPayload) []endpoint {
+			targets := printerEndpoints(payload.Printer, 0)
+			if payload.WeightKg == nil {
+				targets = append(targets, scaleEndpoints(payload.Scale)...)
+				targets = append(targets, intermecBridgeEndpoints(payload.Scale, payload.Printer)...)
+			}
+			return targets
+		},
+		execute: executeWeighAndPrint,
+	})
+}
+
+// validateWeighAndPrint only rejects jobs that could never get a weight.
+// Printer settings are left to devices.SendToPrinter: an empty
+// printer_name, for instance, selects the Windows default printer.
+func validateWeighAndPrint(payload *devices.WeighAndPrintPayload) error {
+	if payload.WeightKg == nil && payload.Scale.Transport == "" {
+		return errors.New("weigh_and_print: brak scale.transport i weight_kg")
+	}
+
+	return nil
+}
+
+func executeWeighAndPrint(ctx context.Context, a *Agent, payload *devices.WeighAndPrintPayload) (map[string]any, error) {
+	weight := payload.WeightKg
+	var rawResponse string
+	if weight == nil {
+		value, response, err := a.readWeightWithIntermecFallback(ctx, payload.Scale, payload.Printer)
+		if err != nil {
+			return nil, err
+		}
+		weight = &value
+		rawResponse = response
+		reportProgress(ctx, phaseWeighed, map[string]any{"weight": value, "raw_response": response})
+	}
+
+	reportProgress(ctx, phaseRendering, nil)
+	replace := map[string]string{}
+	for key, value := range payload.Context {
+		replace[key] = value
+	}
+	replace["weight"] = fmt.Sprintf("%.3f", *weight)
+	replace["weight_kg"] = fmt.Sprintf("%.3f kg", *weight)
+
+	rendered := devices.RenderTemplate(payload.Template, replace)
+	reportProgress(ctx, phaseSending, map[string]any{"printer": payload.Printer.Model})
+	err := devices.SendToPrinter(ctx, payload.Printer, rendered)
+	a.notePrinterResult(ctx, payload.Printer, err)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]any{
+		"weight":       *weight,
+		"raw_response": rawResponse,
+		"printer":      payload.Printer.Model,
+	}, nil
+}
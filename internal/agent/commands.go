@@ -0,0 +1,170 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// CommandHandler implements one command the server can send to the agent.
+//
+// Handlers live in their own cmd_*.go files and register themselves from init
+// with RegisterCommand. The agent decodes the payload into the value returned
+// by NewPayload, validates it, locks the devices it declares and only then
+// calls Execute on a scheduler worker.
+type CommandHandler interface {
+	// Name is the command name as sent by the server, lower case.
+	Name() string
+	// NewPayload returns a pointer to a zero payload to decode JSON into.
+	NewPayload() any
+	// Validate rejects payloads that cannot possibly succeed.
+	Validate(payload any) error
+	// Devices lists the device lock keys the command will use.
+	Devices(payload any) []string
+	// Execute runs the command. It must honour ctx cancellation.
+	Execute(ctx context.Context, a *Agent, payload any) (map[string]any, error)
+}
+
+var (
+	commandsMu sync.RWMutex
+	commands   = map[string]CommandHandler{}
+)
+
+// RegisterCommand adds a handler to the registry. Registering the same name
+// twice is a programming error and panics.
+func RegisterCommand(handler CommandHandler) {
+	name := strings.ToLower(strings.TrimSpace(handler.Name()))
+
+	commandsMu.Lock()
+	defer commandsMu.Unlock()
+
+	if _, exists := commands[name]; exists {
+		panic(fmt.Sprintf("agent: komenda %q zarejestrowana dwukrotnie", name))
+	}
+	commands[name] = handler
+}
+
+// CommandNames returns the names of all registered commands, sorted.
+func CommandNames() []string {
+	commandsMu.RLock()
+	defer commandsMu.RUnlock()
+
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func lookupCommand(name string) (CommandHandler, bool) {
+	commandsMu.RLock()
+	defer commandsMu.RUnlock()
+
+	handler, ok := commands[strings.ToLower(strings.TrimSpace(name))]
+	return handler, ok
+}
+
+// decodeCommand finds the handler for a command and decodes and validates its
+// payload.
+func decodeCommand(name string, rawPayload json.RawMessage) (CommandHandler, any, error) {
+	handler, ok := lookupCommand(name)
+	if !ok {
+		return nil, nil, fmt.Errorf("nieobsługiwana komenda: %s", name)
+	}
+
+	payload := handler.NewPayload()
+	if len(rawPayload) > 0 {
+		if err := json.Unmarshal(rawPayload, payload); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if err := handler.Validate(payload); err != nil {
+		return nil, nil, err
+	}
+
+	return handler, payload, nil
+}
+
+func (a *Agent) executeCommand(ctx context.Context, command string, rawPayload json.RawMessage) (map[string]any, error) {
+	handler, payload, err := decodeCommand(command, rawPayload)
+	if err != nil {
+		return nil, err
+	}
+
+	return handler.Execute(ctx, a, payload)
+}
+
+// commandDeviceKeys returns the device lock keys a command will use. Unknown
+// or invalid payloads get no keys; executeCommand reports the error.
+func commandDeviceKeys(command string, rawPayload json.RawMessage) []string {
+	handler, payload, err := decodeCommand(command, rawPayload)
+	if err != nil {
+		return nil
+	}
+
+	return handler.Devices(payload)
+}
+
+// commandSpec adapts typed functions to CommandHandler so each command file
+// only deals with its own payload type. endpoints lists the network
+// addresses the command dials or listens on, for the local policy.
+type commandSpec[P any] struct {
+	name      string
+	validate  func(payload *P) error
+	devices   func(payload *P) []string
+	endpoints func(payload *P) []endpoint
+	execute   func(ctx context.Context, a *Agent, payload *P) (map[string]any, error)
+}
+
+func (c commandSpec[P]) Name() string {
+	return c.name
+}
+
+func (c commandSpec[P]) NewPayload() any {
+	return new(P)
+}
+
+func (c commandSpec[P]) Validate(payload any) error {
+	typed, ok := payload.(*P)
+	if !ok {
+		return fmt.Errorf("komenda %s: nieprawidłowy typ payload %T", c.name, payload)
+	}
+	if c.validate == nil {
+		return nil
+	}
+
+	return c.validate(typed)
+}
+
+func (c commandSpec[P]) Devices(payload any) []string {
+	typed, ok := payload.(*P)
+	if !ok || c.devices == nil {
+		return nil
+	}
+
+	return c.devices(typed)
+}
+
+func (c commandSpec[P]) Endpoints(payload any) []endpoint {
+	typed, ok := payload.(*P)
+	if !ok || c.endpoints == nil {
+		return nil
+	}
+
+	return c.endpoints(typed)
+}
+
+func (c commandSpec[P]) Execute(ctx context.Context, a *Agent, payload any) (map[string]any, error) {
+	typed, ok := payload.(*P)
+	if !ok {
+		return nil, fmt.Errorf("komenda %s: nieprawidłowy typ payload %T", c.name, payload)
+	}
+
+	return c.execute(ctx, a, typed)
+}
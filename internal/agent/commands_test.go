@@ -0,0 +1,73 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+type echoPayload struct {
+	Text string `json:"text"`
+}
+
+// Test commands are registered once: RegisterCommand panics on duplicates,
+// which would break repeated runs with -count.
+func init() {
+	RegisterCommand(commandSpec[echoPayload]{
+		name: "test_echo",
+		devices: func(payload *echoPayload) []string {
+			return []string{"echo:" + payload.Text}
+		},
+		execute: func(ctx context.Context, a *Agent, payload *echoPayload) (map[string]any, error) {
+			return map[string]any{"text": payload.Text}, nil
+		},
+	})
+}
+
+func TestBuiltinCommandsAreRegistered(t *testing.T) {
+	names := strings.Join(CommandNames(), ",")
+	for _, name := range []string{"print_label", "program_dibal_plu", "read_weight", "weigh_and_print"} {
+		if !strings.Contains(names, name) {
+			t.Fatalf("command %s not registered, have %s", name, names)
+		}
+	}
+}
+
+func TestCommandValidationRejectsPayload(t *testing.T) {
+	a := newTestAgent(t)
+
+	_, err := a.executeCommand(context.Background(), "read_weight", json.RawMessage(`{}`))
+	if err == nil || !strings.Contains(err.Error(), "scale.transport") {
+		t.Fatalf("expected scale validation error, got %v", err)
+	}
+
+	_, err = a.executeCommand(context.Background(), "weigh_and_print", json.RawMessage(`{"printer": {"host": "10.0.0.5"}}`))
+	if err == nil || !strings.Contains(err.Error(), "scale.transport") {
+		t.Fatalf("expected scale validation error, got %v", err)
+	}
+}
+
+func TestCommandValidationKeepsPrinterDefaults(t *testing.T) {
+	// The Windows default printer and an empty template were always accepted.
+	for command, payload := range map[string]string{
+		"print_label":     `{"printer": {"transport": "windows_spooler"}}`,
+		"weigh_and_print": `{"weight_kg": 1.5, "template": "^XA^XZ", "printer": {"transport": "windows_spooler"}}`,
+	} {
+		if _, _, err := decodeCommand(command, json.RawMessage(payload)); err != nil {
+			t.Errorf("%s rejected: %v", command, err)
+		}
+	}
+}
+
+func TestRegisteredCommandRunsThroughDispatch(t *testing.T) {
+	if keys := commandDeviceKeys("test_echo", json.RawMessage(`{"text": "a"}`)); len(keys) != 1 || keys[0] != "echo:a" {
+		t.Fatalf("unexpected keys: %v", keys)
+	}
+
+	a := newTestAgent(t)
+	out := dispatchAndWait(t, a, IncomingMessage{Type: "command", JobID: "echo-1", Command: "test_echo", Payload: json.RawMessage(`{"text": "hej"}`)})
+	if out.Status != "completed" || out.Data["text"] != "hej" {
+		t.Fatalf("unexpected result: %+v", out)
+	}
+}
@@ -0,0 +1,299 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/NowakAdmin/BizantiAgent/internal/api"
+	"github.com/NowakAdmin/BizantiAgent/internal/config"
+	"github.com/NowakAdmin/BizantiAgent/internal/devices"
+	"github.com/NowakAdmin/BizantiAgent/internal/spool"
+	"github.com/NowakAdmin/BizantiAgent/internal/webhook"
+)
+
+// Device events emitted by the agent on its own.
+const (
+	eventDibalConnected     = "dibal_connected"
+	eventDibalDisconnected  = "dibal_disconnected"
+	eventSerialPortAdded    = "serial_port_added"
+	eventSerialPortRemoved  = "serial_port_removed"
+	eventPrinterUnreachable = "printer_unreachable"
+	eventPrinterReachable   = "printer_reachable"
+	eventAgentPaused        = "agent_paused"
+	eventPolicyViolation    = "policy_violation"
+)
+
+const (
+	// eventsLimit bounds the offline event buffer; the oldest events go first.
+	eventsLimit = 1000
+	// eventsBatchSize is the number of events sent in one HTTP request.
+	eventsBatchSize = 50
+	// serialWatchInterval is how often the serial port list is compared.
+	serialWatchInterval = 5 * time.Second
+)
+
+var eventSeq atomic.Uint64
+
+// openEvents opens the durable event buffer under config.Dir(). Events are
+// written here first and removed once delivered, so the server receives the
+// full device timeline even for periods when the agent was offline.
+func (a *Agent) openEvents() {
+	if a.events != nil {
+		return
+	}
+
+	queue, err := spool.Open(filepath.Join(config.Dir(), "events"), eventsLimit)
+	if err != nil {
+		a.logger.Printf("Nie można otworzyć bufora zdarzeń: %v (zdarzenia offline zostaną utracone)", err)
+		return
+	}
+
+	a.events = queue
+}
+
+// emitEvent records a device event and wakes the active transport to send it.
+func (a *Agent) emitEvent(name string, data map[string]any) {
+	now := time.Now().UTC()
+	event := OutgoingMessage{
+		Type:      "event",
+		AgentID:   a.getServerAgentID(),
+		EventID:   fmt.Sprintf("%d-%06d", now.UnixNano(), eventSeq.Add(1)%1000000),
+		Event:     name,
+		Timestamp: now.Format(time.RFC3339Nano),
+		Data:      data,
+	}
+
+	a.logger.Printf("Zdarzenie %s: %v", name, data)
+	if a.mqtt != nil {
+		a.mqtt.PublishEvent(event)
+	}
+	a.notifyWebhooks(webhook.TypeEvent, name, event)
+
+	if a.events == nil {
+		return
+	}
+	if err := a.events.Put(event.EventID, event); err != nil {
+		a.logger.Printf("Bufor zdarzeń: nie udało się zapisać %s: %v", name, err)
+		return
+	}
+
+	select {
+	case a.eventsReady <- struct{}{}:
+	default:
+	}
+}
+
+// pendingEvents returns buffered events, oldest first.
+func (a *Agent) pendingEvents() []OutgoingMessage {
+	if a.events == nil {
+		return nil
+	}
+
+	records, err := a.events.List()
+	if err != nil {
+		a.logger.Printf("Bufor zdarzeń: błąd odczytu: %v", err)
+		return nil
+	}
+
+	events := make([]OutgoingMessage, 0, len(records))
+	for _, record := range records {
+		var event OutgoingMessage
+		if decodeErr := record.Decode(&event); decodeErr != nil {
+			_ = a.events.Remove(record.Key)
+			continue
+		}
+		events = append(events, event)
+	}
+
+	return events
+}
+
+// flushEventsWS sends buffered events over the WebSocket session, skipping
+// those it already carries. An event is dropped from the buffer once it has
+// been written to the socket, so events queued when the connection drops are
+// sent again in the next session; the server deduplicates by event_id.
+// Events stay buffered while the server does not declare the events feature.
+func (a *Agent) flushEventsWS(session *wsSession) error {
+	if !a.serverSupports(featureEvents) {
+		return nil
+	}
+
+	for _, event := range a.pendingEvents() {
+		eventID := event.EventID
+		if _, err := session.sendEvent(event, func() { _ = a.events.Remove(eventID) }); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// flushEventsHTTP posts buffered events in batches to the events endpoint.
+// Delivery stops at the first error so ordering is kept for the next attempt.
+// A server without the endpoint (404/405) disables further attempts until the
+// agent restarts; the events stay buffered.
+func (a *Agent) flushEventsHTTP(ctx context.Context) error {
+	if !a.serverSupports(featureEvents) || a.eventsUnsupported.Load() {
+		return nil
+	}
+
+	pending := a.pendingEvents()
+	for len(pending) > 0 {
+		batch := pending
+		if len(batch) > eventsBatchSize {
+			batch = batch[:eventsBatchSize]
+		}
+		pending = pending[len(batch):]
+
+		err := a.postEvents(ctx, batch)
+		if api.HasStatus(err, http.StatusNotFound, http.StatusMethodNotAllowed) {
+			a.eventsUnsupported.Store(true)
+			a.logger.Printf("Serwer nie obsługuje zdarzeń przez HTTP — wyłączam")
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		for _, event := range batch {
+			_ = a.events.Remove(event.EventID)
+		}
+	}
+
+	return nil
+}
+
+// postEvents sends a batch of events. The server drops duplicates by
+// event_id, so the call is safe to retry.
+func (a *Agent) postEvents(ctx context.Context, events []OutgoingMessage) error {
+	return a.api().Do(ctx, api.Call{
+		Op:         "events",
+		Method:     http.MethodPost,
+		Path:       "/api/bizanticore/agent/events",
+		Body:       map[string]any{"events": events},
+		Idempotent: true,
+	}, nil)
+}
+
+// dibalConnectionHook returns the DibalManager callback that turns scale
+// connects and disconnects into events.
+func (a *Agent) dibalConnectionHook(bindHost string, rxPort, txPort int) func(channel string, connected bool, remote string) {
+	return func(channel string, connected bool, remote string) {
+		name := eventDibalDisconnected
+		if connected {
+			name = eventDibalConnected
+		}
+
+		a.emitEvent(name, map[string]any{
+			"bind_host": bindHost,
+			"rx_port":   rxPort,
+			"tx_port":   txPort,
+			"channel":   channel,
+			"remote":    remote,
+		})
+	}
+}
+
+// watchSerialPorts polls the serial port list and emits an event for every
+// port that appears or disappears. The first snapshot is only a baseline.
+func (a *Agent) watchSerialPorts(ctx context.Context) {
+	known, err := serialPortSet()
+	if err != nil {
+		a.logger.Printf("Nie można pobrać listy portów COM: %v", err)
+	}
+
+	ticker := time.NewTicker(serialWatchInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		current, listErr := serialPortSet()
+		if listErr != nil {
+			continue
+		}
+
+		added, removed := diffPortSets(known, current)
+		for _, port := range added {
+			a.emitEvent(eventSerialPortAdded, map[string]any{"port": port})
+		}
+		for _, port := range removed {
+			a.emitEvent(eventSerialPortRemoved, map[string]any{"port": port})
+		}
+		known = current
+	}
+}
+
+func serialPortSet() (map[string]bool, error) {
+	ports, err := devices.ListSerialPorts()
+	if err != nil {
+		return nil, err
+	}
+
+	set := make(map[string]bool, len(ports))
+	for _, port := range ports {
+		set[port] = true
+	}
+
+	return set, nil
+}
+
+func diffPortSets(before, after map[string]bool) (added, removed []string) {
+	for port := range after {
+		if !before[port] {
+			added = append(added, port)
+		}
+	}
+	for port := range before {
+		if !after[port] {
+			removed = append(removed, port)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+
+	return added, removed
+}
+
+// notePrinterResult tracks whether a printer answered the last job and emits
+// an event when it stops or starts answering. Cancelled jobs say nothing about
+// the printer and are ignored.
+func (a *Agent) notePrinterResult(ctx context.Context, printer devices.PrinterConfig, err error) {
+	if ctx.Err() != nil {
+		return
+	}
+
+	key := printerDeviceKey(printer)
+	reachable := err == nil
+
+	a.mu.Lock()
+	if a.printerDown == nil {
+		a.printerDown = make(map[string]bool)
+	}
+	wasDown := a.printerDown[key]
+	a.printerDown[key] = !reachable
+	a.mu.Unlock()
+
+	data := map[string]any{
+		"printer":   key,
+		"model":     printer.Model,
+		"transport": strings.TrimSpace(printer.Transport),
+	}
+
+	switch {
+	case !reachable && !wasDown:
+		data["error"] = err.Error()
+		a.emitEvent(eventPrinterUnreachable, data)
+	case reachable && wasDown:
+		a.emitEvent(eventPrinterReachable, data)
+	}
+}
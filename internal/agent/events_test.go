@@ -0,0 +1,162 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/NowakAdmin/BizantiAgent/internal/config"
+	"github.com/NowakAdmin/BizantiAgent/internal/devices"
+	"github.com/NowakAdmin/BizantiAgent/internal/spool"
+)
+
+func newEventsTestAgent(t *testing.T, serverURL string) *Agent {
+	t.Helper()
+
+	events, err := spool.Open(t.TempDir(), eventsLimit)
+	if err != nil {
+		t.Fatalf("open events: %v", err)
+	}
+
+	a := newTestAgent(t)
+	a.cfg = &config.Config{ServerURL: serverURL, AgentToken: "token"}
+	a.events = events
+	a.eventsReady = make(chan struct{}, 1)
+	return a
+}
+
+func TestEventsBufferedUntilHTTPDelivery(t *testing.T) {
+	online := false
+	var received []OutgoingMessage
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/bizanticore/agent/events" || !online {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var body struct {
+			Events []OutgoingMessage `json:"events"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		received = append(received, body.Events...)
+	}))
+	defer server.Close()
+
+	a := newEventsTestAgent(t, server.URL)
+	a.emitEvent(eventSerialPortAdded, map[string]any{"port": "COM5"})
+	a.emitEvent(eventSerialPortRemoved, map[string]any{"port": "COM5"})
+
+	select {
+	case <-a.eventsReady:
+	default:
+		t.Fatalf("emitEvent did not wake the transport")
+	}
+
+	if err := a.flushEventsHTTP(context.Background()); err == nil {
+		t.Fatalf("expected delivery error while offline")
+	}
+	if a.events.Len() != 2 {
+		t.Fatalf("events must stay buffered while offline, have %d", a.events.Len())
+	}
+
+	online = true
+	if err := a.flushEventsHTTP(context.Background()); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	if a.events.Len() != 0 {
+		t.Fatalf("delivered events must leave the buffer, have %d", a.events.Len())
+	}
+	if len(received) != 2 || received[0].Event != eventSerialPortAdded || received[1].Event != eventSerialPortRemoved {
+		t.Fatalf("unexpected events: %+v", received)
+	}
+	if received[0].Type != "event" || received[0].EventID == "" || received[0].EventID == received[1].EventID {
+		t.Fatalf("events need type and unique ids: %+v", received)
+	}
+}
+
+func TestPrinterReachabilityEventsOnTransitions(t *testing.T) {
+	a := newEventsTestAgent(t, "http://127.0.0.1")
+	printer := devices.PrinterConfig{Model: "pm43c", Host: "192.168.1.120"}
+	ctx := context.Background()
+
+	a.notePrinterResult(ctx, printer, nil)
+	a.notePrinterResult(ctx, printer, errors.New("connection refused"))
+	a.notePrinterResult(ctx, printer, errors.New("connection refused"))
+	a.notePrinterResult(ctx, printer, nil)
+
+	events := a.pendingEvents()
+	if len(events) != 2 || events[0].Event != eventPrinterUnreachable || events[1].Event != eventPrinterReachable {
+		t.Fatalf("expected unreachable then reachable, got %+v", events)
+	}
+	if events[0].Data["printer"] != "printer:192.168.1.120:9100" {
+		t.Fatalf("unexpected printer key: %v", events[0].Data)
+	}
+}
+
+func TestDiffPortSets(t *testing.T) {
+	added, removed := diffPortSets(
+		map[string]bool{"COM1": true, "COM3": true},
+		map[string]bool{"COM1": true, "COM4": true},
+	)
+	if len(added) != 1 || added[0] != "COM4" || len(removed) != 1 || removed[0] != "COM3" {
+		t.Fatalf("unexpected diff: added=%v removed=%v", added, removed)
+	}
+}
+
+func TestEventsLeaveBufferOnlyOnceWrittenToSocket(t *testing.T) {
+	a := newEventsTestAgent(t, "http://127.0.0.1")
+	a.negotiateProtocol(2, []string{featureEvents})
+	a.emitEvent(eventSerialPortAdded, map[string]any{"port": "COM5"})
+	a.emitEvent(eventSerialPortRemoved, map[string]any{"port": "COM5"})
+
+	closed, _ := newRecordingSession(t)
+	closed.close(nil)
+	if err := a.flushEventsWS(closed); !errors.Is(err, errSessionClosed) || a.events.Len() != 2 {
+		t.Fatalf("events dropped without a write: %v, %d buffered", err, a.events.Len())
+	}
+
+	session, received := newRecordingSession(t)
+	for range 2 {
+		if err := a.flushEventsWS(session); err != nil {
+			t.Fatalf("flush: %v", err)
+		}
+	}
+	if got := receiveMessages(t, received, 2); got[0].Event != eventSerialPortAdded {
+		t.Fatalf("unexpected events: %+v", got)
+	}
+	waitUntil(t, "written events to leave the buffer", func() bool { return a.events.Len() == 0 })
+
+	select {
+	case extra := <-received:
+		t.Fatalf("event sent twice: %+v", extra)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestEventsStopWhenServerLacksEndpoint(t *testing.T) {
+	var calls int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	// A server predating negotiation: every feature but ack is tried.
+	a := newEventsTestAgent(t, server.URL)
+	a.emitEvent(eventSerialPortAdded, map[string]any{"port": "COM5"})
+
+	for range 3 {
+		if err := a.flushEventsHTTP(context.Background()); err != nil {
+			t.Fatalf("flush: %v", err)
+		}
+	}
+	if calls != 1 {
+		t.Fatalf("expected one request before giving up, got %d", calls)
+	}
+	if a.events.Len() != 1 {
+		t.Fatalf("undelivered event dropped")
+	}
+}
@@ -0,0 +1,148 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	errJobCancelled = errors.New("zadanie anulowane przez serwer")
+	errJobExpired   = errors.New("upłynął termin wykonania zadania")
+)
+
+// jobDeadline returns the absolute deadline requested for a command, either as
+// an RFC 3339 "deadline" or as a "ttl" in seconds counted from receipt. When
+// both are given the earlier one wins.
+func jobDeadline(message IncomingMessage, received time.Time) (time.Time, bool) {
+	var deadline time.Time
+
+	if raw := strings.TrimSpace(message.Deadline); raw != "" {
+		if parsed, err := time.Parse(time.RFC3339, raw); err == nil {
+			deadline = parsed
+		}
+	}
+
+	if message.TTLSeconds > 0 {
+		ttlDeadline := received.Add(time.Duration(message.TTLSeconds) * time.Second)
+		if deadline.IsZero() || ttlDeadline.Before(deadline) {
+			deadline = ttlDeadline
+		}
+	}
+
+	return deadline, !deadline.IsZero()
+}
+
+// startJob derives the context a job runs under and registers it so a later
+// "cancel" message can stop it. The returned func must be called once the job
+// has reported its result. ok is false when a job with the same job_id is
+// already queued or running; the duplicate must not be started.
+func (a *Agent) startJob(parent context.Context, message IncomingMessage) (ctx context.Context, done func(), ok bool) {
+	ctx = parent
+	stopDeadline := func() {}
+	if deadline, hasDeadline := jobDeadline(message, time.Now()); hasDeadline {
+		ctx, stopDeadline = context.WithDeadlineCause(parent, deadline, errJobExpired)
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+
+	jobID := strings.TrimSpace(message.JobID)
+	if jobID != "" {
+		a.activeMu.Lock()
+		if _, active := a.activeJobs[jobID]; active {
+			a.activeMu.Unlock()
+			cancel(nil)
+			stopDeadline()
+			return nil, nil, false
+		}
+		if a.activeJobs == nil {
+			a.activeJobs = make(map[string]context.CancelCauseFunc)
+		}
+		a.activeJobs[jobID] = cancel
+		a.activeMu.Unlock()
+	}
+
+	return ctx, func() {
+		if jobID != "" {
+			a.activeMu.Lock()
+			delete(a.activeJobs, jobID)
+			a.activeMu.Unlock()
+		}
+		cancel(nil)
+		stopDeadline()
+	}, true
+}
+
+// handleCancel cancels the job named by a server "cancel" message. When
+// command signing is configured the message must be signed like a command,
+// so a forged cancel cannot abort a print.
+func (a *Agent) handleCancel(message IncomingMessage) {
+	if a.verifier != nil {
+		if err := a.verifier.verify(message); err != nil {
+			a.logger.Printf("Anulowanie job %s odrzucone: %v", message.JobID, err)
+			return
+		}
+	}
+
+	a.cancelJob(message.JobID)
+}
+
+// cancelJob stops a queued or running job. It reports whether the job was known.
+func (a *Agent) cancelJob(jobID string) bool {
+	a.activeMu.Lock()
+	cancel, ok := a.activeJobs[strings.TrimSpace(jobID)]
+	a.activeMu.Unlock()
+
+	if !ok {
+		a.logger.Printf("Anulowanie job %s: zadanie nie jest aktywne", jobID)
+		return false
+	}
+
+	a.logger.Printf("Anulowanie job %s na żądanie serwera", jobID)
+	cancel(errJobCancelled)
+	return true
+}
+
+// activeJobIDs lists the jobs currently queued or running, sorted.
+func (a *Agent) activeJobIDs() []string {
+	a.activeMu.Lock()
+	defer a.activeMu.Unlock()
+
+	ids := make([]string, 0, len(a.activeJobs))
+	for id := range a.activeJobs {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	return ids
+}
+
+// jobError replaces the I/O error a cancelled or expired job ended with by the
+// reason it was stopped, so the server sees "cancelled"/"expired".
+func jobError(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	if cause := context.Cause(ctx); errors.Is(cause, errJobCancelled) || errors.Is(cause, errJobExpired) {
+		return cause
+	}
+
+	return err
+}
+
+// resultStatus maps a job error to the status reported to the server.
+func resultStatus(err error) string {
+	switch {
+	case err == nil:
+		return "completed"
+	case errors.Is(err, errJobCancelled):
+		return "cancelled"
+	case errors.Is(err, errJobExpired):
+		return "expired"
+	default:
+		return "failed"
+	}
+}
@@ -0,0 +1,49 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestJobDeadlinePrefersEarlierOfDeadlineAndTTL(t *testing.T) {
+	received := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	deadline, ok := jobDeadline(IncomingMessage{TTLSeconds: 30}, received)
+	if !ok || !deadline.Equal(received.Add(30*time.Second)) {
+		t.Fatalf("unexpected ttl deadline: %v %v", deadline, ok)
+	}
+
+	deadline, ok = jobDeadline(IncomingMessage{Deadline: "2026-03-01T12:00:10Z", TTLSeconds: 30}, received)
+	if !ok || !deadline.Equal(received.Add(10*time.Second)) {
+		t.Fatalf("expected absolute deadline to win, got %v", deadline)
+	}
+
+	if _, ok = jobDeadline(IncomingMessage{Deadline: "jutro"}, received); ok {
+		t.Fatal("invalid deadline should be ignored")
+	}
+}
+
+func TestJobErrorReportsCancelAndExpiry(t *testing.T) {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	cancel(errJobCancelled)
+
+	err := jobError(ctx, context.Canceled)
+	if resultStatus(err) != "cancelled" {
+		t.Fatalf("expected cancelled, got %q (%v)", resultStatus(err), err)
+	}
+
+	expiredCtx, stop := context.WithDeadlineCause(context.Background(), time.Now().Add(-time.Second), errJobExpired)
+	defer stop()
+
+	err = jobError(expiredCtx, context.DeadlineExceeded)
+	if resultStatus(err) != "expired" {
+		t.Fatalf("expected expired, got %q (%v)", resultStatus(err), err)
+	}
+
+	plain := errors.New("brak papieru")
+	if jobError(context.Background(), plain) != plain || resultStatus(plain) != "failed" {
+		t.Fatal("unrelated errors must be reported as failed")
+	}
+}
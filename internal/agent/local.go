@@ -0,0 +1,165 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/NowakAdmin/BizantiAgent/internal/localapi"
+	"github.com/NowakAdmin/BizantiAgent/internal/webhook"
+)
+
+// localCommandTimeout bounds a command from the local API or MQTT, including
+// the time it waits for a busy device.
+const localCommandTimeout = 2 * time.Minute
+
+// startLocalAPI serves the local REST API until ctx ends. It does not depend
+// on the server connection.
+func (a *Agent) startLocalAPI(ctx context.Context) {
+	server, err := localapi.New(a.cfg.LocalAPI, &localBackend{agent: a}, a.logger)
+	if err != nil {
+		a.logger.Printf("Lokalne API wyłączone: %v", err)
+		return
+	}
+
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+		if runErr := server.Run(ctx); runErr != nil {
+			a.logger.Printf("Lokalne API: %v", runErr)
+		}
+	}()
+}
+
+// startWeightStream starts reading the configured scale continuously.
+func (a *Agent) startWeightStream(ctx context.Context) {
+	if !a.cfg.LocalAPI.WeightStream.Enabled {
+		return
+	}
+
+	stream, err := a.newWeightStream(a.cfg.LocalAPI.WeightStream)
+	if err != nil {
+		a.logger.Printf("Strumień wagi wyłączony: %v", err)
+		return
+	}
+	a.weights = stream
+
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+		stream.run(ctx)
+	}()
+}
+
+// localBackend runs local API commands on the agent's job scheduler, so they
+// share device locks with server commands.
+type localBackend struct {
+	agent *Agent
+	seq   atomic.Uint64
+}
+
+func (b *localBackend) RunCommand(ctx context.Context, command string, payload json.RawMessage) (map[string]any, error) {
+	return b.agent.runLocalCommand(ctx, fmt.Sprintf("local-%d", b.seq.Add(1)), "lokalne API", command, payload)
+}
+
+// runLocalCommand runs a command that did not come from the server, with the
+// same validation, policy and device locks. Errors are *localapi.Error; source
+// names the origin in the log.
+func (a *Agent) runLocalCommand(ctx context.Context, jobID, source, command string, payload json.RawMessage) (map[string]any, error) {
+	command = strings.ToLower(strings.TrimSpace(command))
+
+	if _, _, err := decodeCommand(command, payload); err != nil {
+		return nil, &localapi.Error{StatusCode: http.StatusBadRequest, Err: err}
+	}
+	if err := a.enforcePolicy(jobID, command, payload); err != nil {
+		return nil, &localapi.Error{StatusCode: http.StatusForbidden, Err: err}
+	}
+
+	started := time.Now()
+	ctx, cancel := context.WithTimeoutCause(ctx, localCommandTimeout, errJobExpired)
+	defer cancel()
+
+	type outcome struct {
+		result map[string]any
+		err    error
+	}
+	done := make(chan outcome, 1)
+
+	err := a.jobs.submit(ctx, commandDeviceKeys(command, payload),
+		func(runCtx context.Context) {
+			result, execErr := a.executeCommand(runCtx, command, payload)
+			done <- outcome{result: result, err: jobError(runCtx, execErr)}
+		},
+		func(abortErr error) {
+			done <- outcome{err: jobError(ctx, abortErr)}
+		},
+	)
+	if err != nil {
+		return nil, &localapi.Error{StatusCode: http.StatusServiceUnavailable, Err: err}
+	}
+
+	res := <-done
+	observeJob(command, resultStatus(res.err), started)
+	a.notifyWebhooks(webhook.TypeResult, command, a.localResult(jobID, res.result, res.err))
+	switch {
+	case res.err == nil:
+		a.logger.Printf("Job %s (%s, %s) completed", jobID, command, source)
+		return res.result, nil
+	case errors.Is(res.err, errJobExpired):
+		return nil, &localapi.Error{StatusCode: http.StatusGatewayTimeout, Err: res.err}
+	default:
+		a.logger.Printf("Job %s (%s, %s) failed: %v", jobID, command, source, res.err)
+		return nil, &localapi.Error{StatusCode: http.StatusBadGateway, Err: res.err}
+	}
+}
+
+// localResult is the command_result message for a command from the local API
+// or MQTT.
+func (a *Agent) localResult(jobID string, result map[string]any, err error) OutgoingMessage {
+	out := OutgoingMessage{
+		Type:      "command_result",
+		AgentID:   a.getServerAgentID(),
+		JobID:     jobID,
+		Status:    resultStatus(err),
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+	}
+	if err != nil {
+		out.Error = err.Error()
+	} else {
+		out.Data = result
+	}
+
+	return out
+}
+
+// SubscribeWeight implements localapi.WeightSource. Without a running stream
+// the channel is closed at once and the client is disconnected.
+func (b *localBackend) SubscribeWeight() (<-chan localapi.WeightUpdate, func()) {
+	if b.agent.weights == nil {
+		closed := make(chan localapi.WeightUpdate)
+		close(closed)
+		return closed, func() {}
+	}
+
+	return b.agent.weights.subscribe()
+}
+
+func (b *localBackend) Status() map[string]any {
+	a := b.agent
+
+	a.mu.Lock()
+	connected := a.connected
+	a.mu.Unlock()
+
+	return map[string]any{
+		"status":       a.GetStatus(),
+		"connected":    connected,
+		"breaker":      a.Breaker().State,
+		"capabilities": a.capabilities(),
+	}
+}
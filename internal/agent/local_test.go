@@ -0,0 +1,91 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/NowakAdmin/BizantiAgent/internal/config"
+	"github.com/NowakAdmin/BizantiAgent/internal/localapi"
+)
+
+type localTestPayload struct {
+	Device string `json:"device"`
+}
+
+// localTestRelease lets one test_local job finish.
+var localTestRelease = make(chan struct{})
+
+func init() {
+	RegisterCommand(commandSpec[localTestPayload]{
+		name: "test_local",
+		devices: func(payload *localTestPayload) []string {
+			return []string{payload.Device}
+		},
+		execute: func(ctx context.Context, a *Agent, payload *localTestPayload) (map[string]any, error) {
+			select {
+			case <-localTestRelease:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+			return map[string]any{"device": payload.Device}, nil
+		},
+	})
+}
+
+func TestLocalBackendRunsCommandsOnScheduler(t *testing.T) {
+	a := newTestAgent(t)
+	backend := &localBackend{agent: a}
+	payload := json.RawMessage(`{"device": "serial:COM3"}`)
+
+	// A job from the server holds the device; the local command has to wait.
+	fromServer := make(chan OutgoingMessage, 1)
+	a.dispatchCommand(context.Background(), IncomingMessage{Type: "command", JobID: "s-1", Command: "test_local", Payload: payload},
+		func(out OutgoingMessage) { fromServer <- out })
+	time.Sleep(20 * time.Millisecond)
+
+	localDone := make(chan map[string]any)
+	go func() {
+		result, err := backend.RunCommand(context.Background(), "test_local", payload)
+		if err != nil {
+			t.Errorf("local command: %v", err)
+		}
+		localDone <- result
+	}()
+
+	localTestRelease <- struct{}{}
+	if out := <-fromServer; out.Status != "completed" {
+		t.Fatalf("server job: %+v", out)
+	}
+	localTestRelease <- struct{}{}
+	if result := <-localDone; result["device"] != "serial:COM3" {
+		t.Fatalf("unexpected local result: %v", result)
+	}
+}
+
+func TestLocalBackendMapsErrors(t *testing.T) {
+	a := newTestAgent(t)
+	a.cfg = &config.Config{}
+	backend := &localBackend{agent: a}
+
+	_, err := backend.RunCommand(context.Background(), "read_weight", json.RawMessage(`{}`))
+	var apiErr *localapi.Error
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadRequest {
+		t.Fatalf("invalid payload: expected 400, got %v", err)
+	}
+
+	a.policy = newCommandPolicy(config.PolicyConfig{AllowedCommands: []string{"print_label"}})
+	payload := json.RawMessage(`{"scale": {"transport": "tcp", "host": "127.0.0.1", "port": 1}}`)
+	_, err = backend.RunCommand(context.Background(), "read_weight", payload)
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusForbidden {
+		t.Fatalf("policy violation: expected 403, got %v", err)
+	}
+
+	status := backend.Status()
+	if status["status"] != "Offline" || status["connected"] != false {
+		t.Fatalf("unexpected status: %v", status)
+	}
+}
@@ -0,0 +1,50 @@
+package agent
+
+import (
+	"context"
+	"time"
+
+	"github.com/NowakAdmin/BizantiAgent/internal/metrics"
+	"github.com/NowakAdmin/BizantiAgent/internal/version"
+)
+
+var (
+	jobsTotal = metrics.NewCounter("bizanti_agent_jobs_total",
+		"Finished jobs by command and result status.", "command", "status")
+	jobDuration = metrics.NewHistogram("bizanti_agent_job_duration_seconds",
+		"Time from receiving a job to its result, by command.", nil, "command")
+	websocketReconnects = metrics.NewCounter("bizanti_agent_websocket_reconnects_total",
+		"WebSocket connection attempts after the first one.")
+	httpFallbacks = metrics.NewCounter("bizanti_agent_http_fallback_activations_total",
+		"Switches from WebSocket to HTTP polling.")
+	failurePauses = metrics.NewCounter("bizanti_agent_failure_pauses_total",
+		"Times the reconnection circuit breaker opened and paused the agent.")
+	agentInfo = metrics.NewGauge("bizanti_agent_info",
+		"Always 1; the version label carries the agent version.", "version")
+)
+
+func init() {
+	agentInfo.Set(1, version.Version)
+}
+
+// observeJob records a finished job. Commands the agent does not know are
+// counted as "unknown" so a misbehaving server cannot create series at will.
+func observeJob(command, status string, started time.Time) {
+	if _, ok := lookupCommand(command); !ok {
+		command = "unknown"
+	}
+
+	jobsTotal.Inc(command, status)
+	jobDuration.Observe(time.Since(started).Seconds(), command)
+}
+
+// startMetrics serves /metrics until ctx ends.
+func (a *Agent) startMetrics(ctx context.Context) {
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+		if err := metrics.Serve(ctx, a.cfg.Metrics.Listen, a.logger); err != nil {
+			a.logger.Printf("Metryki: %v", err)
+		}
+	}()
+}
@@ -0,0 +1,24 @@
+package agent
+
+import (
+	"testing"
+	"time"
+)
+
+func TestObserveJobLabelsUnknownCommands(t *testing.T) {
+	before := jobsTotal.Value("unknown", "failed")
+	beforeKnown := jobDuration.Count("read_weight")
+
+	observeJob("drop_tables", "failed", time.Now())
+	observeJob("read_weight", "completed", time.Now())
+
+	if got := jobsTotal.Value("unknown", "failed"); got != before+1 {
+		t.Fatalf("unknown jobs = %v, want %v", got, before+1)
+	}
+	if jobsTotal.Value("drop_tables", "failed") != 0 {
+		t.Fatalf("unknown command got its own series")
+	}
+	if got := jobDuration.Count("read_weight"); got != beforeKnown+1 {
+		t.Fatalf("read_weight observations = %d", got)
+	}
+}
@@ -0,0 +1,111 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"sync/atomic"
+
+	"github.com/NowakAdmin/BizantiAgent/internal/mqtt"
+)
+
+var mqttJobSeq atomic.Uint64
+
+// mqttCommands are the commands accepted on the command topic. Token rotation
+// and PLU programming stay reserved for the server.
+var mqttCommands = []string{"read_weight", "print_label", "weigh_and_print"}
+
+// startMQTT connects to the configured broker. It runs before anything that
+// emits events, so a.mqtt never changes while jobs and devices are active.
+func (a *Agent) startMQTT(ctx context.Context) {
+	client, err := mqtt.New(a.cfg.MQTT, a.handleMQTTCommand, a.logger)
+	if err != nil {
+		a.logger.Printf("MQTT wyłączone: %v", err)
+		return
+	}
+	a.mqtt = client
+
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+		if runErr := client.Run(ctx); runErr != nil {
+			a.logger.Printf("MQTT: %v", runErr)
+		}
+	}()
+}
+
+// startMQTTWeights forwards readings of the weight stream to the weight
+// topic.
+func (a *Agent) startMQTTWeights(ctx context.Context) {
+	if a.mqtt == nil || a.weights == nil {
+		return
+	}
+
+	updates, unsubscribe := a.weights.subscribe()
+
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+		defer unsubscribe()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case update := <-updates:
+				a.mqtt.PublishWeight(update)
+			}
+		}
+	}()
+}
+
+// publishMQTTResult publishes the result of a server job when MQTT is
+// enabled.
+func (a *Agent) publishMQTTResult(out OutgoingMessage) {
+	if a.mqtt != nil {
+		a.mqtt.PublishResult(out)
+	}
+}
+
+// handleMQTTCommand runs a message from the command topic, shaped like a
+// server command: {"job_id": ..., "command": ..., "payload": {...}}. The
+// job_id is optional and echoed in the result. Only mqttCommands are run, and
+// when command signing is configured they must be signed like server
+// commands.
+func (a *Agent) handleMQTTCommand(ctx context.Context, data []byte) any {
+	var message IncomingMessage
+	if err := json.Unmarshal(data, &message); err != nil {
+		a.logger.Printf("MQTT: nieprawidłowa komenda: %v", err)
+		return a.localResult("", nil, fmt.Errorf("nieprawidłowy JSON: %w", err))
+	}
+
+	jobID := strings.TrimSpace(message.JobID)
+	if jobID == "" {
+		jobID = fmt.Sprintf("mqtt-%d", mqttJobSeq.Add(1))
+	}
+
+	command := strings.ToLower(strings.TrimSpace(message.Command))
+	if !slices.Contains(mqttCommands, command) {
+		a.logger.Printf("MQTT: komenda %q niedozwolona", message.Command)
+		return a.localResult(jobID, nil, fmt.Errorf("komenda niedozwolona przez MQTT: %s", message.Command))
+	}
+
+	// With signing enabled, the broker is no more trusted than the server
+	// connection: commands must carry the server's signature.
+	if a.verifier != nil {
+		if err := a.verifier.verify(message); err != nil {
+			a.logger.Printf("MQTT: job %s (%s): %v", jobID, command, err)
+			return a.localResult(jobID, nil, err)
+		}
+	}
+
+	result, err := a.runLocalCommand(ctx, jobID, "MQTT", message.Command, message.Payload)
+	if err != nil && ctx.Err() != nil {
+		// The agent is stopping and disconnects from the broker.
+		return nil
+	}
+
+	return a.localResult(jobID, result, err)
+}
@@ -0,0 +1,99 @@
+package agent
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/NowakAdmin/BizantiAgent/internal/config"
+)
+
+func init() {
+	RegisterCommand(commandSpec[localTestPayload]{
+		name: "test_mqtt",
+		execute: func(ctx context.Context, a *Agent, payload *localTestPayload) (map[string]any, error) {
+			return map[string]any{"device": payload.Device}, nil
+		},
+	})
+}
+
+// allowMQTTCommand adds a command to the MQTT allowlist for one test.
+func allowMQTTCommand(t *testing.T, command string) {
+	t.Helper()
+
+	saved := mqttCommands
+	mqttCommands = append(slices.Clone(mqttCommands), command)
+	t.Cleanup(func() { mqttCommands = saved })
+}
+
+func mqttCommand(t *testing.T, a *Agent, message IncomingMessage) OutgoingMessage {
+	t.Helper()
+
+	data, err := json.Marshal(message)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	out, ok := a.handleMQTTCommand(context.Background(), data).(OutgoingMessage)
+	if !ok {
+		t.Fatalf("no result for %+v", message)
+	}
+
+	return out
+}
+
+func TestMQTTCommandRunsThroughLocalPipeline(t *testing.T) {
+	allowMQTTCommand(t, "test_mqtt")
+	a := newTestAgent(t)
+
+	out := mqttCommand(t, a, IncomingMessage{JobID: "line-7", Command: "test_mqtt", Payload: json.RawMessage(`{"device": "scale"}`)})
+	if out.Type != "command_result" || out.JobID != "line-7" || out.Status != "completed" || out.Data["device"] != "scale" {
+		t.Fatalf("result = %+v", out)
+	}
+
+	out = a.handleMQTTCommand(context.Background(), []byte(`not json`)).(OutgoingMessage)
+	if out.Status != "failed" {
+		t.Fatalf("invalid JSON: %+v", out)
+	}
+}
+
+func TestMQTTRejectsCommandsOutsideAllowlist(t *testing.T) {
+	a := newTestAgent(t)
+
+	for _, command := range []string{"rotate_token", "program_dibal_plu", "format_disk"} {
+		out := mqttCommand(t, a, IncomingMessage{Command: command, Payload: json.RawMessage(`{}`)})
+		if out.Status != "failed" || out.JobID == "" {
+			t.Fatalf("%s: %+v", command, out)
+		}
+	}
+}
+
+func TestMQTTRequiresSignatureWhenSigningIsConfigured(t *testing.T) {
+	allowMQTTCommand(t, "test_mqtt")
+	publicKey, privateKey := newSigningKey(t)
+	a := newTestAgent(t)
+	a.verifier = newCommandVerifier(config.CommandSigningConfig{PublicKey: publicKey, MaxAgeSeconds: 60})
+
+	message := IncomingMessage{
+		JobID:     "line-8",
+		Command:   "test_mqtt",
+		Payload:   json.RawMessage(`{"device": "scale"}`),
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+		Nonce:     "mqtt-n1",
+	}
+	if out := mqttCommand(t, a, message); out.Status != "failed" {
+		t.Fatalf("unsigned command ran: %+v", out)
+	}
+
+	data, err := signedCommandData(message)
+	if err != nil {
+		t.Fatalf("signed data: %v", err)
+	}
+	message.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(privateKey, data))
+	if out := mqttCommand(t, a, message); out.Status != "completed" {
+		t.Fatalf("signed command: %+v", out)
+	}
+}
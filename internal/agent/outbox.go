@@ -0,0 +1,216 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"time"
+
+	"github.com/NowakAdmin/BizantiAgent/internal/config"
+	"github.com/NowakAdmin/BizantiAgent/internal/spool"
+)
+
+// outboxMaxAge bounds how long an unacknowledged result is replayed before it
+// is dropped. A week covers a long weekend with the site offline.
+const outboxMaxAge = 7 * 24 * time.Hour
+
+// resultAckTimeout is how long a result sent over WebSocket may wait for the
+// server's ack before it is delivered over HTTP instead.
+const resultAckTimeout = 30 * time.Second
+
+// openOutbox opens the durable result outbox under config.Dir(). Every finished
+// job is written here before delivery is attempted and removed only once the
+// server acknowledges it, so a dropped WebSocket or a failed HTTP POST never
+// loses the outcome of a label that was already printed.
+func (a *Agent) openOutbox() {
+	if a.outbox != nil {
+		return
+	}
+
+	queue, err := spool.Open(filepath.Join(config.Dir(), "outbox"), 0)
+	if err != nil {
+		a.logger.Printf("Nie można otworzyć outbox wyników: %v (wyniki nie będą buforowane)", err)
+		return
+	}
+
+	a.outbox = queue
+	if pending := queue.Len(); pending > 0 {
+		a.logger.Printf("Outbox: %d niepotwierdzonych wyników do ponownego wysłania", pending)
+	}
+}
+
+// storeResult persists a command result until the server acknowledges it.
+// The result replaces any earlier one of the same job_id, also on the
+// current WebSocket session, so a re-run job reports its new outcome.
+func (a *Agent) storeResult(out OutgoingMessage) {
+	if a.outbox == nil || out.JobID == "" {
+		return
+	}
+
+	if err := a.outbox.Put(out.JobID, out); err != nil {
+		a.logger.Printf("Outbox: nie udało się zapisać wyniku job %s: %v", out.JobID, err)
+	}
+
+	a.mu.Lock()
+	session := a.session
+	a.mu.Unlock()
+	if session != nil {
+		session.forgetResult(out.JobID)
+	}
+}
+
+// ackResult removes a delivered result from the outbox.
+func (a *Agent) ackResult(jobID string) {
+	if a.outbox == nil || jobID == "" {
+		return
+	}
+
+	if err := a.outbox.Remove(jobID); err != nil {
+		a.logger.Printf("Outbox: nie udało się usunąć wyniku job %s: %v", jobID, err)
+	}
+}
+
+// pendingResults returns results still waiting for acknowledgement, oldest
+// first. Results older than outboxMaxAge are dropped.
+func (a *Agent) pendingResults() []OutgoingMessage {
+	if a.outbox == nil {
+		return nil
+	}
+
+	records, err := a.outbox.List()
+	if err != nil {
+		a.logger.Printf("Outbox: błąd odczytu: %v", err)
+		return nil
+	}
+
+	results := make([]OutgoingMessage, 0, len(records))
+	for _, record := range records {
+		if time.Since(record.CreatedAt) > outboxMaxAge {
+			a.logger.Printf("Outbox: wynik job %s starszy niż %v — usuwam bez potwierdzenia", record.Key, outboxMaxAge)
+			_ = a.outbox.Remove(record.Key)
+			continue
+		}
+
+		var out OutgoingMessage
+		if decodeErr := record.Decode(&out); decodeErr != nil {
+			a.logger.Printf("Outbox: uszkodzony wynik job %s: %v", record.Key, decodeErr)
+			_ = a.outbox.Remove(record.Key)
+			continue
+		}
+
+		results = append(results, out)
+	}
+
+	return results
+}
+
+// unackedJobIDs lists the job IDs of results still waiting in the outbox.
+func (a *Agent) unackedJobIDs() []string {
+	pending := a.pendingResults()
+	ids := make([]string, 0, len(pending))
+	for _, out := range pending {
+		ids = append(ids, out.JobID)
+	}
+
+	return ids
+}
+
+// flushOutboxHTTP replays pending results over HTTP, in batches when the
+// server supports it. A 2xx response is the acknowledgement. Delivery stops at
+// the first error so the remaining results keep their order for the next cycle.
+func (a *Agent) flushOutboxHTTP(ctx context.Context) error {
+	pending := a.pendingResults()
+
+	useBatch := a.serverSupports(featureBatchResults)
+	for useBatch && !a.batchUnsupported.Load() && len(pending) > 0 {
+		batch := pending
+		if len(batch) > resultsBatchSize {
+			batch = batch[:resultsBatchSize]
+		}
+
+		err := a.reportCommandResults(ctx, batch)
+		if errors.Is(err, errBatchUnsupported) {
+			a.logger.Printf("Serwer nie obsługuje zbiorczych wyników — wysyłam pojedynczo")
+			a.batchUnsupported.Store(true)
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		for _, out := range batch {
+			a.ackResult(out.JobID)
+		}
+		pending = pending[len(batch):]
+	}
+
+	for _, out := range pending {
+		if err := a.reportCommandResult(ctx, out); err != nil {
+			return err
+		}
+
+		a.ackResult(out.JobID)
+	}
+
+	return nil
+}
+
+// replayOutboxWS sends pending results over the WebSocket session, skipping
+// those it already carries. It runs when a session starts and whenever an
+// HTTP-polled job finishes while the session is up. Results stay in the
+// outbox until the server answers with an "ack" message, or until written if
+// the server declared no ack support.
+func (a *Agent) replayOutboxWS(session *wsSession) error {
+	pending := a.pendingResults()
+	if len(pending) == 0 {
+		return nil
+	}
+
+	acked := a.serverSupports(featureAck)
+	sent := 0
+	for _, out := range pending {
+		var written func()
+		if !acked {
+			jobID := out.JobID
+			written = func() { a.ackResult(jobID) }
+		}
+
+		queued, err := session.sendResult(out, written)
+		if err != nil {
+			return err
+		}
+		if queued {
+			sent++
+		}
+	}
+	if sent > 0 {
+		a.logger.Printf("Outbox: ponowne wysłanie %d wyników przez WebSocket", sent)
+	}
+
+	return nil
+}
+
+// deliverUnackedHTTP reports results that were sent over session but not
+// acked within timeout over HTTP, where a 2xx response is the
+// acknowledgement. It covers servers that declare ack but lose some results,
+// which would otherwise wait in the outbox until the next reconnect.
+func (a *Agent) deliverUnackedHTTP(ctx context.Context, session *wsSession, timeout time.Duration) error {
+	if !a.serverSupports(featureAck) {
+		return nil
+	}
+
+	cutoff := time.Now().Add(-timeout)
+	for _, out := range a.pendingResults() {
+		if !session.sentBefore(out.JobID, cutoff) {
+			continue
+		}
+
+		if err := a.reportCommandResult(ctx, out); err != nil {
+			return err
+		}
+		a.logger.Printf("Outbox: brak ack dla job %s przez %v — wynik dostarczony przez HTTP", out.JobID, timeout)
+		a.ackResult(out.JobID)
+	}
+
+	return nil
+}
@@ -0,0 +1,285 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/NowakAdmin/BizantiAgent/internal/config"
+	"github.com/NowakAdmin/BizantiAgent/internal/devices"
+)
+
+// endpoint is a network address a command makes the agent dial or listen on.
+type endpoint struct {
+	Listen bool
+	Host   string
+	Port   int
+}
+
+func (e endpoint) String() string {
+	verb := "połączenie z"
+	if e.Listen {
+		verb = "nasłuch na"
+	}
+
+	return verb + " " + net.JoinHostPort(e.Host, strconv.Itoa(e.Port))
+}
+
+// PolicyError reports a command refused by the local policy.
+type PolicyError struct {
+	Reason string
+}
+
+func (e *PolicyError) Error() string {
+	return "zablokowane przez lokalną politykę: " + e.Reason
+}
+
+type portRange struct {
+	from, to int
+}
+
+// commandPolicy is the parsed form of config.PolicyConfig.
+type commandPolicy struct {
+	commands map[string]bool
+
+	dialHosts []string
+	dialPorts []portRange
+	bindHosts []string
+	bindPorts []portRange
+
+	// configErr is set when the policy cannot be parsed. Every command is
+	// rejected then, rather than running unrestricted.
+	configErr error
+}
+
+// newCommandPolicy returns nil when no restriction is configured.
+func newCommandPolicy(cfg config.PolicyConfig) *commandPolicy {
+	if len(cfg.AllowedCommands) == 0 && len(cfg.DialHosts) == 0 && len(cfg.DialPorts) == 0 &&
+		len(cfg.BindAddresses) == 0 && len(cfg.BindPorts) == 0 {
+		return nil
+	}
+
+	p := &commandPolicy{}
+	if len(cfg.AllowedCommands) > 0 {
+		p.commands = map[string]bool{}
+		for _, name := range cfg.AllowedCommands {
+			p.commands[strings.ToLower(strings.TrimSpace(name))] = true
+		}
+	}
+
+	var errs []error
+	var err error
+	if p.dialHosts, err = parseHostList("policy.dial_hosts", cfg.DialHosts); err != nil {
+		errs = append(errs, err)
+	}
+	if p.bindHosts, err = parseHostList("policy.bind_addresses", cfg.BindAddresses); err != nil {
+		errs = append(errs, err)
+	}
+	if p.dialPorts, err = parsePortList("policy.dial_ports", cfg.DialPorts); err != nil {
+		errs = append(errs, err)
+	}
+	if p.bindPorts, err = parsePortList("policy.bind_ports", cfg.BindPorts); err != nil {
+		errs = append(errs, err)
+	}
+	p.configErr = errors.Join(errs...)
+
+	return p
+}
+
+func parseHostList(field string, entries []string) ([]string, error) {
+	hosts := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "" {
+			continue
+		}
+		if strings.Contains(entry, "/") {
+			if _, _, err := net.ParseCIDR(entry); err != nil {
+				return nil, fmt.Errorf("%s: %w", field, err)
+			}
+		}
+		hosts = append(hosts, entry)
+	}
+
+	return hosts, nil
+}
+
+func parsePortList(field string, entries []string) ([]portRange, error) {
+	ranges := make([]portRange, 0, len(entries))
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		from, to, isRange := strings.Cut(entry, "-")
+		if !isRange {
+			to = from
+		}
+		first, errFrom := strconv.Atoi(strings.TrimSpace(from))
+		last, errTo := strconv.Atoi(strings.TrimSpace(to))
+		if errFrom != nil || errTo != nil || first < 1 || last > 65535 || first > last {
+			return nil, fmt.Errorf("%s: nieprawidłowy port %q", field, entry)
+		}
+		ranges = append(ranges, portRange{from: first, to: last})
+	}
+
+	return ranges, nil
+}
+
+// hostAllowed matches host against exact names, IPs and CIDR ranges. An
+// empty list allows every host.
+func hostAllowed(host string, allowed []string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+
+	host = strings.ToLower(strings.Trim(strings.TrimSpace(host), "[]"))
+	ip := net.ParseIP(host)
+	for _, entry := range allowed {
+		if strings.Contains(entry, "/") {
+			if _, network, err := net.ParseCIDR(entry); err == nil && ip != nil && network.Contains(ip) {
+				return true
+			}
+			continue
+		}
+		if entryIP := net.ParseIP(entry); entryIP != nil && ip != nil {
+			if entryIP.Equal(ip) {
+				return true
+			}
+			continue
+		}
+		if host == entry {
+			return true
+		}
+	}
+
+	return false
+}
+
+func portAllowed(port int, allowed []portRange) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+
+	for _, r := range allowed {
+		if port >= r.from && port <= r.to {
+			return true
+		}
+	}
+
+	return false
+}
+
+// check returns a *PolicyError if the command or any of its endpoints is
+// not allowed.
+func (p *commandPolicy) check(command string, endpoints []endpoint) error {
+	if p.configErr != nil {
+		return &PolicyError{Reason: fmt.Sprintf("błędna sekcja policy w konfiguracji: %v", p.configErr)}
+	}
+	if p.commands != nil && !p.commands[command] {
+		return &PolicyError{Reason: fmt.Sprintf("komenda %s nie jest dozwolona", command)}
+	}
+
+	for _, target := range endpoints {
+		hosts, ports := p.dialHosts, p.dialPorts
+		if target.Listen {
+			hosts, ports = p.bindHosts, p.bindPorts
+		}
+		if !hostAllowed(target.Host, hosts) || !portAllowed(target.Port, ports) {
+			return &PolicyError{Reason: target.String() + " nie jest dozwolone"}
+		}
+	}
+
+	return nil
+}
+
+// enforcePolicy checks a command against the local policy before it is
+// scheduled. Violations are logged and reported to the server as events.
+// Payloads that fail to decode pass through; executeCommand reports them.
+func (a *Agent) enforcePolicy(jobID, command string, rawPayload json.RawMessage) error {
+	if a.policy == nil {
+		return nil
+	}
+
+	var targets []endpoint
+	if handler, payload, err := decodeCommand(command, rawPayload); err == nil {
+		if lister, ok := handler.(interface{ Endpoints(payload any) []endpoint }); ok {
+			targets = lister.Endpoints(payload)
+		}
+	}
+
+	err := a.policy.check(command, targets)
+	if err != nil {
+		a.logger.Printf("Job %s (%s): %v", jobID, command, err)
+		a.emitEvent(eventPolicyViolation, map[string]any{
+			"job_id":  jobID,
+			"command": command,
+			"reason":  err.Error(),
+		})
+	}
+
+	return err
+}
+
+// dibalListenEndpoints lists the ports a Dibal listener binds, with the same
+// defaults as getOrCreateDibalManager.
+func dibalListenEndpoints(bindHost string, rxPort, txPort int) []endpoint {
+	bindHost = strings.TrimSpace(bindHost)
+	if bindHost == "" {
+		bindHost = "0.0.0.0"
+	}
+	if rxPort <= 0 {
+		rxPort = 3000
+	}
+	if txPort <= 0 {
+		txPort = 3001
+	}
+
+	return []endpoint{
+		{Listen: true, Host: bindHost, Port: rxPort},
+		{Listen: true, Host: bindHost, Port: txPort},
+	}
+}
+
+// scaleEndpoints lists the network endpoints used to read a scale.
+func scaleEndpoints(scale devices.ScaleConfig) []endpoint {
+	switch strings.ToLower(strings.TrimSpace(scale.Transport)) {
+	case "tcp", "ethernet":
+		return []endpoint{{Host: strings.TrimSpace(scale.TCPHost), Port: scale.TCPPort}}
+	case "tcp_server", "server_tcp", "dibal_tcp_server", "dibal_server":
+		txPort := scale.TXPort
+		if txPort <= 0 {
+			txPort = scale.TCPPort
+		}
+		return dibalListenEndpoints(scale.BindHost, scale.RXPort, txPort)
+	}
+
+	return nil
+}
+
+// printerEndpoints lists the network endpoints used to print. txPort is the
+// Dibal TX port to use if the printer is a Dibal scale.
+func printerEndpoints(printer devices.PrinterConfig, txPort int) []endpoint {
+	switch strings.ToLower(strings.TrimSpace(printer.Transport)) {
+	case "windows", "windows_spooler", "spooler", "windows_printer":
+		return nil
+	case "dibal_direct", "dibal", "dibal_tcp_server", "dibal_server":
+		return dibalListenEndpoints(printer.DibalBindHost, printer.DibalRXPort, txPort)
+	}
+
+	host := strings.TrimSpace(printer.Host)
+	if host == "" {
+		return nil
+	}
+
+	port := printer.Port
+	if port <= 0 {
+		port = 9100
+	}
+
+	return []endpoint{{Host: host, Port: port}}
+}
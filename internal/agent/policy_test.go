@@ -0,0 +1,103 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/NowakAdmin/BizantiAgent/internal/config"
+)
+
+func TestPolicyCheck(t *testing.T) {
+	policy := newCommandPolicy(config.PolicyConfig{
+		AllowedCommands: []string{"print_label", "read_weight"},
+		DialHosts:       []string{"192.168.10.0/24", "drukarka.local"},
+		DialPorts:       []string{"9100-9101"},
+		BindAddresses:   []string{"0.0.0.0"},
+		BindPorts:       []string{"3000", "3001"},
+	})
+	if policy == nil || policy.configErr != nil {
+		t.Fatalf("policy not configured: %+v", policy)
+	}
+
+	allowed := [][]endpoint{
+		{{Host: "192.168.10.5", Port: 9100}},
+		{{Host: "DRUKARKA.local", Port: 9101}},
+		dibalListenEndpoints("", 0, 0),
+	}
+	for _, targets := range allowed {
+		if err := policy.check("print_label", targets); err != nil {
+			t.Fatalf("%v rejected: %v", targets, err)
+		}
+	}
+
+	denied := map[string][]endpoint{
+		"host outside CIDR":   {{Host: "10.0.0.1", Port: 9100}},
+		"port outside range":  {{Host: "192.168.10.5", Port: 22}},
+		"unlisted host name":  {{Host: "evil.example", Port: 9100}},
+		"bind to other iface": {{Listen: true, Host: "192.168.10.1", Port: 3000}},
+		"bind to other port":  {{Listen: true, Host: "0.0.0.0", Port: 8080}},
+	}
+	for name, targets := range denied {
+		var policyErr *PolicyError
+		if err := policy.check("print_label", targets); !errors.As(err, &policyErr) {
+			t.Fatalf("%s: expected PolicyError, got %v", name, err)
+		}
+	}
+
+	if err := policy.check("program_dibal_plu", nil); err == nil {
+		t.Fatalf("command outside allowed_commands accepted")
+	}
+}
+
+func TestPolicyConfigErrorRejectsEverything(t *testing.T) {
+	if newCommandPolicy(config.PolicyConfig{}) != nil {
+		t.Fatalf("empty policy must not restrict anything")
+	}
+
+	policy := newCommandPolicy(config.PolicyConfig{DialPorts: []string{"9100-80"}})
+	if policy == nil || policy.check("read_weight", nil) == nil {
+		t.Fatalf("invalid port range must reject commands")
+	}
+}
+
+func TestPolicyViolationReportedAsEvent(t *testing.T) {
+	a := newEventsTestAgent(t, "")
+	a.policy = newCommandPolicy(config.PolicyConfig{DialHosts: []string{"192.168.10.0/24"}})
+
+	out := dispatchAndWait(t, a, IncomingMessage{
+		Type:    "command",
+		JobID:   "7",
+		Command: "print_label",
+		Payload: json.RawMessage(`{"template":"^XA^XZ","printer":{"host":"10.0.0.1","port":9100}}`),
+	})
+	if out.Status != "failed" || !strings.Contains(out.Error, "10.0.0.1:9100") {
+		t.Fatalf("expected policy rejection, got %+v", out)
+	}
+
+	events := a.pendingEvents()
+	if len(events) != 1 || events[0].Event != eventPolicyViolation || events[0].Data["job_id"] != "7" {
+		t.Fatalf("expected one policy_violation event, got %+v", events)
+	}
+}
+
+func TestPolicyCoversIntermecFallbackAddress(t *testing.T) {
+	a := newEventsTestAgent(t, "")
+	a.policy = newCommandPolicy(config.PolicyConfig{DialHosts: []string{"192.168.10.0/24"}})
+
+	// A serial scale with an Intermec printer falls back to scale.tcp_host,
+	// which the policy must check as well as the printer.
+	for _, command := range []string{"read_weight", "weigh_and_print"} {
+		out := dispatchAndWait(t, a, IncomingMessage{
+			Type:    "command",
+			JobID:   "fallback-" + command,
+			Command: command,
+			Payload: json.RawMessage(`{"scale":{"transport":"serial","port":"COM9","tcp_host":"10.0.0.9","tcp_port":22},
+				"printer":{"model":"Intermec PM43","host":"192.168.10.5"}}`),
+		})
+		if out.Status != "failed" || !strings.Contains(out.Error, "10.0.0.9:22") {
+			t.Fatalf("%s: expected policy rejection of the fallback, got %+v", command, out)
+		}
+	}
+}
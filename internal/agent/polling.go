@@ -0,0 +1,235 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/NowakAdmin/BizantiAgent/internal/api"
+)
+
+const (
+	// longPollWait is how long the server may hold /commands/next open.
+	longPollWait = 25 * time.Second
+	// minPollInterval and maxPollInterval bound the adaptive polling
+	// interval used when the server answers immediately.
+	minPollInterval = 1 * time.Second
+	maxPollInterval = 15 * time.Second
+	// resultsBatchSize is the number of results sent in one batch request.
+	resultsBatchSize = 50
+)
+
+var errBatchUnsupported = errors.New("serwer nie obsługuje zbiorczego raportowania wyników")
+
+type pullCommandsResponse struct {
+	Success bool              `json:"success"`
+	Data    []IncomingMessage `json:"data"`
+
+	// LongPoll is set by servers that honoured the wait parameter and held
+	// the request until a command arrived or the wait elapsed.
+	LongPoll bool `json:"long_poll"`
+}
+
+type pollResult struct {
+	commands []IncomingMessage
+	longPoll bool
+	err      error
+}
+
+// nextPollInterval adapts the polling interval for servers without long-poll:
+// back to the minimum as soon as there is work, doubling while idle.
+func nextPollInterval(current time.Duration, gotCommands bool) time.Duration {
+	if gotCommands || current < minPollInterval {
+		return minPollInterval
+	}
+
+	next := current * 2
+	if next > maxPollInterval {
+		next = maxPollInterval
+	}
+
+	return next
+}
+
+func (a *Agent) runHTTPPolling(ctx context.Context, maxDuration time.Duration) error {
+	if strings.TrimSpace(a.endpoint().ServerURL) == "" {
+		return fmt.Errorf("brak server_url do fallback HTTP")
+	}
+
+	heartbeatEvery := time.Duration(a.cfg.HeartbeatSeconds) * time.Second
+	if a.cfg.HeartbeatSeconds <= 0 {
+		heartbeatEvery = 30 * time.Second
+	}
+
+	heartbeatTicker := time.NewTicker(heartbeatEvery)
+	defer heartbeatTicker.Stop()
+
+	if err := a.heartbeat(ctx); err != nil {
+		a.setConnected(false)
+		a.logger.Printf("HTTP heartbeat error: %v", err)
+	}
+
+	var timeout <-chan time.Time
+	if maxDuration > 0 {
+		timer := time.NewTimer(maxDuration)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	// The pull runs on its own goroutine so that a request held open by a
+	// long-polling server does not stall heartbeats and result delivery.
+	pollCtx, cancelPoll := context.WithCancel(ctx)
+	polled := make(chan pollResult, 1)
+	pollTimer := time.NewTimer(0)
+	defer pollTimer.Stop()
+	inFlight := false
+	interval := minPollInterval
+
+	defer func() {
+		cancelPoll()
+		if inFlight {
+			result := <-polled
+			if ctx.Err() != nil {
+				// Dispatched now they would only fail with the cancelled
+				// ctx and that failure would be cached; without a result
+				// the server redelivers them.
+				if len(result.commands) > 0 {
+					a.logger.Printf("Zamykanie: %d komend z HTTP polling pozostawione do ponownego dostarczenia", len(result.commands))
+				}
+				return
+			}
+			// Commands the server already handed out must not be dropped.
+			a.dispatchPolled(ctx, result.commands)
+		}
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return context.Canceled
+		case <-timeout:
+			return nil
+		case <-heartbeatTicker.C:
+			if err := a.heartbeat(ctx); err != nil {
+				a.setConnected(false)
+				a.logger.Printf("HTTP heartbeat error: %v", err)
+			}
+		case <-a.eventsReady:
+			if flushErr := a.flushEventsHTTP(ctx); flushErr != nil {
+				a.logger.Printf("Błąd wysyłania zdarzeń (zostają w buforze): %v", flushErr)
+			}
+		case <-a.resultsReady:
+			if flushErr := a.flushOutboxHTTP(ctx); flushErr != nil {
+				a.logger.Printf("Błąd raportowania wyników (zostają w outbox): %v", flushErr)
+			}
+		case <-pollTimer.C:
+			if flushErr := a.flushOutboxHTTP(ctx); flushErr != nil {
+				a.logger.Printf("Outbox: błąd ponownego wysłania wyników: %v", flushErr)
+			}
+			if flushErr := a.flushEventsHTTP(ctx); flushErr != nil {
+				a.logger.Printf("Błąd wysyłania zdarzeń (zostają w buforze): %v", flushErr)
+			}
+
+			wait := longPollWait
+			if !a.serverSupports(featureLongPoll) {
+				wait = 0
+			}
+
+			inFlight = true
+			go func() {
+				commands, longPoll, err := a.pullCommands(pollCtx, wait)
+				polled <- pollResult{commands: commands, longPoll: longPoll, err: err}
+			}()
+		case result := <-polled:
+			inFlight = false
+			if result.err != nil {
+				a.setConnected(false)
+				return result.err
+			}
+
+			a.dispatchPolled(ctx, result.commands)
+
+			if result.longPoll {
+				interval = 0
+			} else {
+				interval = nextPollInterval(interval, len(result.commands) > 0)
+			}
+			pollTimer.Reset(interval)
+		}
+	}
+}
+
+// dispatchPolled runs commands received over HTTP. Results are only stored
+// in the outbox here; the polling loop flushes them in batches.
+func (a *Agent) dispatchPolled(ctx context.Context, commands []IncomingMessage) {
+	for _, message := range commands {
+		if strings.EqualFold(strings.TrimSpace(message.Type), "cancel") {
+			a.handleCancel(message)
+			continue
+		}
+
+		a.dispatchCommand(ctx, message, func(out OutgoingMessage) {
+			if out.Type == "command_progress" {
+				a.progress.put(out)
+				return
+			}
+
+			a.progress.drop(out.JobID)
+			select {
+			case a.resultsReady <- struct{}{}:
+			default:
+			}
+		})
+	}
+}
+
+// pullCommands asks for the next commands. It is not retried: the server
+// may already have handed the commands out, and the polling loop asks again.
+func (a *Agent) pullCommands(ctx context.Context, wait time.Duration) ([]IncomingMessage, bool, error) {
+	var parsed pullCommandsResponse
+	err := a.api().Do(ctx, api.Call{
+		Op:      "pull commands",
+		Method:  http.MethodGet,
+		Path:    fmt.Sprintf("/api/bizanticore/agent/commands/next?limit=5&wait=%d", int(wait.Seconds())),
+		Timeout: wait + api.DefaultTimeout,
+	}, &parsed)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if !parsed.Success {
+		return nil, false, fmt.Errorf("pull commands returned success=false")
+	}
+
+	a.setConnected(true)
+
+	return parsed.Data, parsed.LongPoll, nil
+}
+
+// reportCommandResults posts several results in one request. A 2xx response
+// acknowledges all of them. Servers without the endpoint answer 404/405,
+// reported as errBatchUnsupported.
+func (a *Agent) reportCommandResults(ctx context.Context, results []OutgoingMessage) error {
+	entries := make([]map[string]any, 0, len(results))
+	for _, out := range results {
+		entry := resultPayload(out)
+		entry["job_id"] = out.JobID
+		entries = append(entries, entry)
+	}
+
+	err := a.api().Do(ctx, api.Call{
+		Op:         "report results",
+		Method:     http.MethodPost,
+		Path:       "/api/bizanticore/agent/commands/results",
+		Body:       map[string]any{"results": entries},
+		Idempotent: true,
+	}, nil)
+	if api.HasStatus(err, http.StatusNotFound, http.StatusMethodNotAllowed) {
+		return errBatchUnsupported
+	}
+
+	return err
+}
@@ -0,0 +1,210 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/NowakAdmin/BizantiAgent/internal/config"
+	"github.com/NowakAdmin/BizantiAgent/internal/spool"
+)
+
+func TestNextPollInterval(t *testing.T) {
+	if got := nextPollInterval(8*time.Second, true); got != minPollInterval {
+		t.Fatalf("work must reset the interval, got %v", got)
+	}
+	if got := nextPollInterval(2*time.Second, false); got != 4*time.Second {
+		t.Fatalf("idle must double the interval, got %v", got)
+	}
+	if got := nextPollInterval(maxPollInterval, false); got != maxPollInterval {
+		t.Fatalf("interval must stay capped, got %v", got)
+	}
+	if got := nextPollInterval(0, false); got != minPollInterval {
+		t.Fatalf("leaving long-poll must restart at the minimum, got %v", got)
+	}
+}
+
+func newOutboxTestAgent(t *testing.T, serverURL string) *Agent {
+	t.Helper()
+
+	outbox, err := spool.Open(t.TempDir(), 0)
+	if err != nil {
+		t.Fatalf("open outbox: %v", err)
+	}
+
+	a := newTestAgent(t)
+	a.cfg = &config.Config{ServerURL: serverURL, AgentToken: "token"}
+	a.outbox = outbox
+	for _, id := range []string{"1", "2", "3"} {
+		a.storeResult(OutgoingMessage{Type: "command_result", JobID: id, Status: "completed"})
+	}
+
+	return a
+}
+
+func TestFlushOutboxSendsOneBatch(t *testing.T) {
+	var mu sync.Mutex
+	var paths []string
+	var batch []map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		paths = append(paths, r.URL.Path)
+		var body struct {
+			Results []map[string]any `json:"results"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		batch = body.Results
+	}))
+	defer server.Close()
+
+	a := newOutboxTestAgent(t, server.URL)
+	if err := a.flushOutboxHTTP(context.Background()); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+
+	if len(paths) != 1 || paths[0] != "/api/bizanticore/agent/commands/results" {
+		t.Fatalf("expected one batch request, got %v", paths)
+	}
+	if len(batch) != 3 || batch[0]["job_id"] != "1" || batch[0]["status"] != "completed" {
+		t.Fatalf("unexpected batch: %v", batch)
+	}
+	if a.outbox.Len() != 0 {
+		t.Fatalf("batched results must be acknowledged")
+	}
+}
+
+func TestFlushOutboxFallsBackToSingleResults(t *testing.T) {
+	var mu sync.Mutex
+	var single int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if strings.HasSuffix(r.URL.Path, "/commands/results") {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		single++
+	}))
+	defer server.Close()
+
+	a := newOutboxTestAgent(t, server.URL)
+	if err := a.flushOutboxHTTP(context.Background()); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+
+	if !a.batchUnsupported.Load() || single != 3 || a.outbox.Len() != 0 {
+		t.Fatalf("expected per-job fallback: unsupported=%v single=%d left=%d", a.batchUnsupported.Load(), single, a.outbox.Len())
+	}
+}
+
+func TestPullCommandsSendsWaitAndReadsLongPoll(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("wait") != "25" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		_, _ = w.Write([]byte(`{"success": true, "long_poll": true, "data": [{"type": "command", "job_id": "7"}]}`))
+	}))
+	defer server.Close()
+
+	a := newOutboxTestAgent(t, server.URL)
+	commands, longPoll, err := a.pullCommands(context.Background(), longPollWait)
+	if err != nil {
+		t.Fatalf("pull: %v", err)
+	}
+	if !longPoll || len(commands) != 1 || commands[0].JobID != "7" {
+		t.Fatalf("unexpected pull result: long_poll=%v commands=%+v", longPoll, commands)
+	}
+}
+
+func TestReplayOutboxWSSendsEachResultOnce(t *testing.T) {
+	a := newOutboxTestAgent(t, "http://127.0.0.1")
+	a.negotiateProtocol(2, []string{featureAck})
+	session, received := newRecordingSession(t)
+
+	if err := a.replayOutboxWS(session); err != nil {
+		t.Fatalf("replay: %v", err)
+	}
+	receiveMessages(t, received, 3)
+
+	// A job polled over HTTP finishes while the session is up: only its
+	// result is sent.
+	a.storeResult(OutgoingMessage{Type: "command_result", JobID: "4", Status: "completed"})
+	if err := a.replayOutboxWS(session); err != nil {
+		t.Fatalf("replay: %v", err)
+	}
+	if got := receiveMessages(t, received, 1); got[0].JobID != "4" {
+		t.Fatalf("expected result 4, got %+v", got[0])
+	}
+
+	select {
+	case extra := <-received:
+		t.Fatalf("result sent twice: %+v", extra)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestUnackedResultsFallBackToHTTP(t *testing.T) {
+	var mu sync.Mutex
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		paths = append(paths, r.URL.Path)
+	}))
+	defer server.Close()
+
+	a := newOutboxTestAgent(t, server.URL)
+	a.negotiateProtocol(2, []string{featureAck})
+
+	// The server reads the results but never acks them.
+	session, received := newRecordingSession(t)
+	if err := a.replayOutboxWS(session); err != nil {
+		t.Fatalf("replay: %v", err)
+	}
+	receiveMessages(t, received, 3)
+	a.storeResult(OutgoingMessage{Type: "command_result", JobID: "4", Status: "completed"})
+
+	if err := a.deliverUnackedHTTP(context.Background(), session, time.Hour); err != nil || len(paths) != 0 {
+		t.Fatalf("results reported before the ack timeout: %v %v", err, paths)
+	}
+
+	if err := a.deliverUnackedHTTP(context.Background(), session, 0); err != nil {
+		t.Fatalf("fallback: %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(paths) != 3 || paths[0] != "/api/bizanticore/agent/commands/1/result" {
+		t.Fatalf("expected results 1-3 over HTTP, got %v", paths)
+	}
+	// Result 4 was never sent over the session; it waits for the replay.
+	if pending := a.unackedJobIDs(); len(pending) != 1 || pending[0] != "4" {
+		t.Fatalf("unexpected outbox after fallback: %v", pending)
+	}
+}
+
+func TestRerunResultIsSentOnTheSameSession(t *testing.T) {
+	a := newOutboxTestAgent(t, "http://127.0.0.1")
+	a.negotiateProtocol(2, []string{featureAck})
+	session, received := newRecordingSession(t)
+	a.setSession(session)
+
+	if err := a.replayOutboxWS(session); err != nil {
+		t.Fatalf("replay: %v", err)
+	}
+	receiveMessages(t, received, 3)
+
+	// Job 2 runs again with dedupe=false and finishes with a new result.
+	rerun := OutgoingMessage{Type: "command_result", JobID: "2", Status: "failed"}
+	a.storeResult(rerun)
+	a.deliverWS(rerun)
+	if got := receiveMessages(t, received, 1); got[0].JobID != "2" || got[0].Status != "failed" {
+		t.Fatalf("expected the new result of job 2, got %+v", got[0])
+	}
+}
@@ -0,0 +1,192 @@
+package agent
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/NowakAdmin/BizantiAgent/internal/api"
+	"github.com/NowakAdmin/BizantiAgent/internal/devices"
+)
+
+// Progress phases reported in command_progress messages.
+const (
+	phaseWaitingForScale  = "waiting_for_scale"
+	phaseWeighing         = "weighing"
+	phaseWeighed          = "weighed"
+	phaseRendering        = "rendering"
+	phaseSending          = "sending"
+	phaseKeyboardLocked   = "keyboard_locked"
+	phasePLUSent          = "plu_sent"
+	phaseKeyboardUnlocked = "keyboard_unlocked"
+)
+
+type progressKey struct{}
+
+type progressFunc func(phase string, data map[string]any)
+
+// withProgress attaches a progress reporter to a job context. Commands call
+// reportProgress at phase transitions; the reporter turns them into
+// command_progress messages for the transport the job arrived on.
+func withProgress(ctx context.Context, report progressFunc) context.Context {
+	ctx = context.WithValue(ctx, progressKey{}, report)
+
+	// Dibal register sequences report the KB lock/unlock handshake.
+	return devices.WithDibalLineHook(ctx, func(line string) {
+		if phase := dibalLinePhase(line); phase != "" {
+			reportProgress(ctx, phase, nil)
+		}
+	})
+}
+
+// reportProgress emits a progress event if the job has a reporter.
+func reportProgress(ctx context.Context, phase string, data map[string]any) {
+	if report, ok := ctx.Value(progressKey{}).(progressFunc); ok && report != nil {
+		report(phase, data)
+	}
+}
+
+func dibalLinePhase(line string) string {
+	switch {
+	case strings.HasPrefix(line, "KB;00;02;01"):
+		return phaseKeyboardLocked
+	case strings.HasPrefix(line, "KB;00;02;03"):
+		return phaseKeyboardUnlocked
+	case strings.HasPrefix(line, "X1;"):
+		return phasePLUSent
+	}
+
+	return ""
+}
+
+// progressReporter builds the reporter for one job. It is only called from the
+// goroutine running that job, so the sequence counter needs no locking.
+// Progress is not reported at all to a server without command_progress.
+func (a *Agent) progressReporter(jobID string, deliver func(OutgoingMessage)) progressFunc {
+	if !a.serverSupports(featureProgress) {
+		return func(string, map[string]any) {}
+	}
+
+	seq := 0
+	return func(phase string, data map[string]any) {
+		seq++
+		deliver(OutgoingMessage{
+			Type:      "command_progress",
+			AgentID:   a.getServerAgentID(),
+			JobID:     jobID,
+			Status:    "running",
+			Phase:     phase,
+			Seq:       seq,
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			Data:      data,
+		})
+	}
+}
+
+// progressQueue holds progress waiting to be posted over HTTP, so jobs never
+// wait for the server. Only the latest progress of each job is kept: a newer
+// phase replaces one that has not been posted yet.
+type progressQueue struct {
+	mu      sync.Mutex
+	pending map[string]OutgoingMessage
+	order   []string
+	wake    chan struct{}
+}
+
+func newProgressQueue() *progressQueue {
+	return &progressQueue{
+		pending: make(map[string]OutgoingMessage),
+		wake:    make(chan struct{}, 1),
+	}
+}
+
+// put queues out, replacing progress of the same job that is still waiting.
+func (q *progressQueue) put(out OutgoingMessage) {
+	q.mu.Lock()
+	if _, ok := q.pending[out.JobID]; !ok {
+		q.order = append(q.order, out.JobID)
+	}
+	q.pending[out.JobID] = out
+	q.mu.Unlock()
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// drop forgets waiting progress of a finished job.
+func (q *progressQueue) drop(jobID string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	delete(q.pending, jobID)
+}
+
+// take returns the oldest waiting progress.
+func (q *progressQueue) take() (OutgoingMessage, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for len(q.order) > 0 {
+		jobID := q.order[0]
+		q.order = q.order[1:]
+		if out, ok := q.pending[jobID]; ok {
+			delete(q.pending, jobID)
+			return out, true
+		}
+	}
+
+	return OutgoingMessage{}, false
+}
+
+// runProgress posts queued HTTP progress until ctx ends.
+func (a *Agent) runProgress(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-a.progress.wake:
+		}
+
+		for {
+			out, ok := a.progress.take()
+			if !ok || ctx.Err() != nil {
+				break
+			}
+			a.reportCommandProgress(ctx, out)
+		}
+	}
+}
+
+// reportCommandProgress posts a progress event in HTTP polling mode. Progress
+// is best effort: it is not stored in the outbox, and a server without the
+// endpoint (404) disables further attempts until the agent restarts.
+func (a *Agent) reportCommandProgress(ctx context.Context, out OutgoingMessage) {
+	if a.progressUnsupported.Load() || strings.TrimSpace(out.JobID) == "" {
+		return
+	}
+
+	err := a.api().Do(ctx, api.Call{
+		Op:     "progress",
+		Method: http.MethodPost,
+		Path:   "/api/bizanticore/agent/commands/" + out.JobID + "/progress",
+		Body: map[string]any{
+			"phase":     out.Phase,
+			"seq":       out.Seq,
+			"timestamp": out.Timestamp,
+			"data":      out.Data,
+		},
+		Timeout: 5 * time.Second,
+	}, nil)
+	if api.HasStatus(err, http.StatusNotFound) {
+		a.progressUnsupported.Store(true)
+		a.logger.Printf("Serwer nie obsługuje raportowania postępu przez HTTP — wyłączam")
+		return
+	}
+	if err != nil {
+		a.logger.Printf("Błąd raportowania postępu job %s: %v", out.JobID, err)
+	}
+}
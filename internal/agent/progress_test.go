@@ -0,0 +1,74 @@
+package agent
+
+import (
+	"context"
+	"io"
+	"log"
+	"testing"
+)
+
+func TestProgressReporterNumbersPhases(t *testing.T) {
+	a := &Agent{logger: log.New(io.Discard, "", 0)}
+
+	var got []OutgoingMessage
+	ctx := withProgress(context.Background(), a.progressReporter("145", func(out OutgoingMessage) {
+		got = append(got, out)
+	}))
+
+	reportProgress(ctx, phaseWaitingForScale, map[string]any{"rx_port": 3000})
+	reportProgress(ctx, phaseWeighed, map[string]any{"weight": 1.245})
+
+	if len(got) != 2 {
+		t.Fatalf("expected 2 progress messages, got %d", len(got))
+	}
+
+	for i, out := range got {
+		if out.Type != "command_progress" || out.JobID != "145" || out.Seq != i+1 {
+			t.Fatalf("unexpected progress message %d: %+v", i, out)
+		}
+	}
+
+	if got[1].Phase != phaseWeighed || got[1].Data["weight"] != 1.245 {
+		t.Fatalf("weighed phase lost its data: %+v", got[1])
+	}
+
+	// Without a reporter progress is silently ignored.
+	reportProgress(context.Background(), phaseSending, nil)
+}
+
+func TestDibalLinePhase(t *testing.T) {
+	cases := map[string]string{
+		"KB;00;02;01":         phaseKeyboardLocked,
+		"X1;00;M;000001;MĄKA": phasePLUSent,
+		"KB;00;02;03":         phaseKeyboardUnlocked,
+		"T1;01":               "",
+	}
+
+	for line, want := range cases {
+		if got := dibalLinePhase(line); got != want {
+			t.Fatalf("dibalLinePhase(%q) = %q, want %q", line, got, want)
+		}
+	}
+}
+
+func TestProgressQueueKeepsLatestPerJob(t *testing.T) {
+	q := newProgressQueue()
+	q.put(OutgoingMessage{JobID: "1", Seq: 1})
+	q.put(OutgoingMessage{JobID: "2", Seq: 1})
+	q.put(OutgoingMessage{JobID: "1", Seq: 2})
+	q.put(OutgoingMessage{JobID: "3", Seq: 1})
+	q.drop("3")
+
+	var got []OutgoingMessage
+	for {
+		out, ok := q.take()
+		if !ok {
+			break
+		}
+		got = append(got, out)
+	}
+
+	if len(got) != 2 || got[0].JobID != "1" || got[0].Seq != 2 || got[1].JobID != "2" {
+		t.Fatalf("unexpected progress: %+v", got)
+	}
+}
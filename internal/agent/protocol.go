@@ -0,0 +1,127 @@
+package agent
+
+import (
+	"maps"
+	"slices"
+	"strings"
+)
+
+// ProtocolVersion is the agent protocol spoken by this build. Agents up to
+// v0.1.14 sent no version and are treated by the server as version 1.
+const ProtocolVersion = 2
+
+// Protocol features. The agent announces the ones it implements in auth and
+// heartbeat; the server answers with the ones it supports.
+const (
+	// featureAck: the server confirms stored WebSocket results with "ack".
+	featureAck = "ack"
+	// featureBatchResults: POST /commands/results accepts many results.
+	featureBatchResults = "batch_results"
+	// featureProgress: command_progress messages and the HTTP progress
+	// endpoint.
+	featureProgress = "command_progress"
+	// featureEvents: unsolicited device events over WebSocket and HTTP.
+	featureEvents = "events"
+	// featureResume: resume_token, in_flight and unacked in auth.
+	featureResume = "resume"
+	// featureLongPoll: /commands/next may be held open for wait seconds.
+	featureLongPoll = "long_poll"
+	// featureSignedCommands: commands carry timestamp, nonce and signature.
+	featureSignedCommands = "signed_commands"
+	// featureRotateToken: the rotate_token command.
+	featureRotateToken = "rotate_token"
+)
+
+// agentFeatures lists the features this build implements.
+var agentFeatures = []string{
+	featureAck,
+	featureBatchResults,
+	featureProgress,
+	featureEvents,
+	featureResume,
+	featureLongPoll,
+	featureSignedCommands,
+	featureRotateToken,
+}
+
+// serverProtocol is what the server declared in its last auth_ok or
+// heartbeat response.
+type serverProtocol struct {
+	version  int
+	features map[string]bool
+}
+
+// negotiateProtocol records the server's protocol version and features. A
+// response without a version comes from a server that predates negotiation
+// and leaves the agent in compatibility mode, where every feature but ack is
+// tried and falls back on its own (404). Acks cannot be detected as missing,
+// so results count as delivered once written, as before negotiation.
+func (a *Agent) negotiateProtocol(version int, features []string) {
+	if version <= 0 {
+		return
+	}
+
+	negotiated := &serverProtocol{version: version, features: make(map[string]bool, len(features))}
+	for _, feature := range features {
+		if feature = strings.ToLower(strings.TrimSpace(feature)); feature != "" {
+			negotiated.features[feature] = true
+		}
+	}
+
+	a.mu.Lock()
+	previous := a.protocol
+	a.protocol = negotiated
+	a.mu.Unlock()
+
+	if previous != nil && previous.version == version && maps.Equal(previous.features, negotiated.features) {
+		return
+	}
+
+	if version < ProtocolVersion {
+		a.logger.Printf("Serwer używa protokołu v%d (agent v%d) — wyłączam nieobsługiwane funkcje", version, ProtocolVersion)
+	}
+	var missing []string
+	for _, feature := range agentFeatures {
+		if !negotiated.features[feature] {
+			missing = append(missing, feature)
+		}
+	}
+	if len(missing) > 0 {
+		a.logger.Printf("Protokół v%d, serwer nie obsługuje: %s", version, strings.Join(missing, ", "))
+	} else {
+		a.logger.Printf("Protokół v%d, wszystkie funkcje dostępne", version)
+	}
+}
+
+// serverSupports reports whether the agent may use a feature. Until the
+// server declares a protocol version every feature except ack is allowed,
+// which keeps the behaviour of agents released before negotiation.
+func (a *Agent) serverSupports(feature string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.protocol == nil {
+		return feature != featureAck
+	}
+
+	return a.protocol.features[feature]
+}
+
+// ServerProtocol returns the negotiated protocol version and server features,
+// or zero and nil while the server has not declared them.
+func (a *Agent) ServerProtocol() (int, []string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.protocol == nil {
+		return 0, nil
+	}
+
+	features := make([]string, 0, len(a.protocol.features))
+	for feature := range a.protocol.features {
+		features = append(features, feature)
+	}
+	slices.Sort(features)
+
+	return a.protocol.version, features
+}
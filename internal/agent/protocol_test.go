@@ -0,0 +1,154 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/NowakAdmin/BizantiAgent/internal/config"
+)
+
+func TestLegacyServerKeepsAllFeatures(t *testing.T) {
+	a := newOutboxTestAgent(t, "http://127.0.0.1")
+
+	// auth_ok exactly as sent by servers paired with agents up to v0.1.14.
+	var message IncomingMessage
+	if err := json.Unmarshal([]byte(`{"type":"auth_ok","resume_token":"r1"}`), &message); err != nil {
+		t.Fatalf("decode legacy auth_ok: %v", err)
+	}
+	a.handleIncoming(context.Background(), nil, message)
+
+	if version, _ := a.ServerProtocol(); version != 0 {
+		t.Fatalf("legacy server must stay unnegotiated, got v%d", version)
+	}
+	for _, feature := range agentFeatures {
+		if want := feature != featureAck; a.serverSupports(feature) != want {
+			t.Fatalf("legacy server: feature %q supported = %v, want %v", feature, !want, want)
+		}
+	}
+
+	auth := a.authMessage()
+	if auth.ResumeToken != "r1" || len(auth.Unacked) != 3 {
+		t.Fatalf("legacy auth must carry resume details: %+v", auth)
+	}
+
+	session, received := newRecordingSession(t)
+	if err := a.replayOutboxWS(session); err != nil {
+		t.Fatalf("replay: %v", err)
+	}
+	receiveMessages(t, received, 3)
+	// A legacy server may never ack, so written results are delivered.
+	waitUntil(t, "written results to leave the outbox", func() bool { return a.outbox.Len() == 0 })
+}
+
+func TestAuthMessageWireFormat(t *testing.T) {
+	a := newTestAgent(t)
+	a.cfg = &config.Config{}
+
+	raw, err := json.Marshal(a.authMessage())
+	if err != nil {
+		t.Fatalf("marshal auth: %v", err)
+	}
+
+	var fields map[string]any
+	if err = json.Unmarshal(raw, &fields); err != nil {
+		t.Fatalf("decode auth: %v", err)
+	}
+
+	// Field names understood by deployed servers must not change.
+	for _, key := range []string{"type", "status", "timestamp", "capabilities"} {
+		if _, ok := fields[key]; !ok {
+			t.Fatalf("auth lost field %q: %s", key, raw)
+		}
+	}
+	if fields["type"] != "auth" || fields["protocol_version"] != float64(ProtocolVersion) {
+		t.Fatalf("unexpected auth: %s", raw)
+	}
+	if features, _ := fields["features"].([]any); len(features) != len(agentFeatures) {
+		t.Fatalf("auth must announce agent features: %s", raw)
+	}
+}
+
+func TestNegotiatedServerDisablesMissingFeatures(t *testing.T) {
+	var mu sync.Mutex
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		paths = append(paths, r.URL.Path)
+		mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	a := newOutboxTestAgent(t, server.URL)
+	a.setResumeToken("r1")
+	a.handleIncoming(context.Background(), nil, IncomingMessage{Type: "auth_ok", ProtocolVersion: 2, Features: []string{"ack", " Events "}})
+
+	if !a.serverSupports(featureEvents) || a.serverSupports(featureBatchResults) || a.serverSupports(featureProgress) {
+		t.Fatalf("unexpected negotiated features")
+	}
+
+	if err := a.flushOutboxHTTP(context.Background()); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	for _, path := range paths {
+		if path == "/api/bizanticore/agent/commands/results" {
+			t.Fatalf("batch endpoint used without batch_results: %v", paths)
+		}
+	}
+	if len(paths) != 3 {
+		t.Fatalf("expected one request per result, got %v", paths)
+	}
+
+	reported := 0
+	a.progressReporter("7", func(OutgoingMessage) { reported++ })(phaseWeighing, nil)
+	if reported != 0 {
+		t.Fatalf("progress reported to a server without command_progress")
+	}
+
+	if auth := a.authMessage(); auth.ResumeToken != "" || auth.InFlight != nil || auth.Unacked != nil {
+		t.Fatalf("resume details sent to a server without resume: %+v", auth)
+	}
+}
+
+func TestReplayWithoutAckCountsWriteAsDelivery(t *testing.T) {
+	a := newOutboxTestAgent(t, "http://127.0.0.1")
+	a.negotiateProtocol(2, []string{featureResume})
+
+	session, _ := newRecordingSession(t)
+	if err := a.replayOutboxWS(session); err != nil {
+		t.Fatalf("replay: %v", err)
+	}
+	waitUntil(t, "written results to leave the outbox", func() bool { return a.outbox.Len() == 0 })
+}
+
+func TestHeartbeatNegotiatesProtocol(t *testing.T) {
+	var sent map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewDecoder(r.Body).Decode(&sent)
+		_, _ = w.Write([]byte(`{"agent_id": 5, "protocol_version": 3, "features": ["events", "ack", "future_thing"]}`))
+	}))
+	defer server.Close()
+
+	a := newTestAgent(t)
+	a.cfg = &config.Config{ServerURL: server.URL, AgentToken: "token"}
+
+	if err := a.heartbeat(context.Background()); err != nil {
+		t.Fatalf("heartbeat: %v", err)
+	}
+	if sent["protocol_version"] != float64(ProtocolVersion) || sent["status"] != "online" {
+		t.Fatalf("unexpected heartbeat body: %v", sent)
+	}
+
+	version, features := a.ServerProtocol()
+	if version != 3 || !slices.Equal(features, []string{"ack", "events", "future_thing"}) {
+		t.Fatalf("ServerProtocol = v%d %v", version, features)
+	}
+	if a.serverSupports(featureLongPoll) {
+		t.Fatalf("long_poll was not declared by the server")
+	}
+}
@@ -0,0 +1,76 @@
+package agent
+
+import (
+	"path/filepath"
+	"time"
+
+	"github.com/NowakAdmin/BizantiAgent/internal/config"
+	"github.com/NowakAdmin/BizantiAgent/internal/spool"
+)
+
+const (
+	// recentJobsLimit bounds the on-disk record of executed jobs.
+	recentJobsLimit = 500
+	// recentJobsMaxAge is how long a finished job counts as a duplicate.
+	recentJobsMaxAge = 24 * time.Hour
+)
+
+// openRecentJobs opens the persistent record of recently executed jobs. HTTP
+// polling and WebSocket reconnects can deliver the same job_id twice; the
+// second delivery is answered from this cache instead of printing the label
+// or reprogramming the PLU again.
+func (a *Agent) openRecentJobs() {
+	if a.recentJobs != nil {
+		return
+	}
+
+	queue, err := spool.Open(filepath.Join(config.Dir(), "recent_jobs"), recentJobsLimit)
+	if err != nil {
+		a.logger.Printf("Nie można otworzyć rejestru wykonanych zadań: %v (duplikaty nie będą wykrywane)", err)
+		return
+	}
+
+	a.recentJobs = queue
+}
+
+// rememberResult records the final result of a job.
+func (a *Agent) rememberResult(out OutgoingMessage) {
+	if a.recentJobs == nil || out.JobID == "" {
+		return
+	}
+
+	if err := a.recentJobs.Put(out.JobID, out); err != nil {
+		a.logger.Printf("Rejestr zadań: nie udało się zapisać job %s: %v", out.JobID, err)
+	}
+}
+
+// recentResult returns the cached result of an already executed job.
+func (a *Agent) recentResult(jobID string) (OutgoingMessage, bool) {
+	if a.recentJobs == nil || jobID == "" {
+		return OutgoingMessage{}, false
+	}
+
+	record, ok := a.recentJobs.Get(jobID)
+	if !ok {
+		return OutgoingMessage{}, false
+	}
+
+	if time.Since(record.CreatedAt) > recentJobsMaxAge {
+		_ = a.recentJobs.Remove(jobID)
+		return OutgoingMessage{}, false
+	}
+
+	var out OutgoingMessage
+	if err := record.Decode(&out); err != nil {
+		_ = a.recentJobs.Remove(jobID)
+		return OutgoingMessage{}, false
+	}
+
+	return out, true
+}
+
+// wantsDedupe reports whether duplicate detection applies to a command.
+// The server opts out per command with "dedupe": false.
+func wantsDedupe(message IncomingMessage) bool {
+	return message.Dedupe == nil || *message.Dedupe
+}
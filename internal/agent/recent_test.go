@@ -0,0 +1,69 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"testing"
+
+	"github.com/NowakAdmin/BizantiAgent/internal/config"
+	"github.com/NowakAdmin/BizantiAgent/internal/spool"
+)
+
+func newTestAgent(t *testing.T) *Agent {
+	t.Helper()
+
+	recent, err := spool.Open(t.TempDir(), recentJobsLimit)
+	if err != nil {
+		t.Fatalf("open recent jobs: %v", err)
+	}
+
+	return &Agent{
+		logger:     log.New(io.Discard, "", 0),
+		jobs:       newScheduler(1),
+		recentJobs: recent,
+		progress:   newProgressQueue(),
+		breaker:    newBreaker(config.ReconnectConfig{}),
+	}
+}
+
+func dispatchAndWait(t *testing.T, a *Agent, message IncomingMessage) OutgoingMessage {
+	t.Helper()
+
+	results := make(chan OutgoingMessage, 1)
+	a.dispatchCommand(context.Background(), message, func(out OutgoingMessage) {
+		results <- out
+	})
+	a.jobs.wait()
+
+	select {
+	case out := <-results:
+		return out
+	default:
+		t.Fatalf("job %s produced no result", message.JobID)
+		return OutgoingMessage{}
+	}
+}
+
+func TestDuplicateJobReturnsCachedResult(t *testing.T) {
+	a := newTestAgent(t)
+	message := IncomingMessage{Type: "command", JobID: "145", Command: "unknown", Payload: json.RawMessage(`{}`)}
+
+	first := dispatchAndWait(t, a, message)
+	if first.Status != "failed" || first.Cached {
+		t.Fatalf("unexpected first result: %+v", first)
+	}
+
+	second := dispatchAndWait(t, a, message)
+	if !second.Cached || second.Status != first.Status || second.Error != first.Error {
+		t.Fatalf("expected cached copy of the first result, got %+v", second)
+	}
+
+	disabled := false
+	message.Dedupe = &disabled
+	third := dispatchAndWait(t, a, message)
+	if third.Cached {
+		t.Fatalf("dedupe=false must execute the command again, got %+v", third)
+	}
+}
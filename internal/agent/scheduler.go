@@ -0,0 +1,222 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/NowakAdmin/BizantiAgent/internal/devices"
+)
+
+// maxQueuedJobs limits how many jobs may wait for a worker or a device at once.
+// Beyond that new jobs are rejected instead of piling up behind a dead device.
+const maxQueuedJobs = 64
+
+var errQueueFull = errors.New("kolejka zadań agenta jest pełna")
+
+// scheduler runs jobs concurrently on a bounded number of workers while making
+// sure a single device (serial port, printer, Dibal manager) is never driven by
+// two jobs at once.
+type scheduler struct {
+	slots chan struct{}
+
+	mu      sync.Mutex
+	locks   map[string]*deviceLock
+	pending int
+
+	wg sync.WaitGroup
+}
+
+// deviceLock is a reference-counted, context-aware mutex for one device key.
+type deviceLock struct {
+	ch   chan struct{}
+	refs int
+}
+
+func newScheduler(workers int) *scheduler {
+	if workers <= 0 {
+		workers = 1
+	}
+
+	return &scheduler{
+		slots: make(chan struct{}, workers),
+		locks: make(map[string]*deviceLock),
+	}
+}
+
+// submit queues run to execute once all device keys are free and a worker slot
+// is available. If ctx ends while the job is still waiting, abort is called
+// instead of run. submit never blocks the caller, so the WebSocket read loop and
+// the heartbeat keep running while jobs wait.
+func (s *scheduler) submit(ctx context.Context, keys []string, run func(ctx context.Context), abort func(err error)) error {
+	s.mu.Lock()
+	if s.pending >= maxQueuedJobs {
+		s.mu.Unlock()
+		return errQueueFull
+	}
+	s.pending++
+	s.mu.Unlock()
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer func() {
+			s.mu.Lock()
+			s.pending--
+			s.mu.Unlock()
+		}()
+
+		release, err := s.acquire(ctx, keys)
+		if err != nil {
+			abort(err)
+			return
+		}
+		defer release()
+
+		run(ctx)
+	}()
+
+	return nil
+}
+
+// wait blocks until all submitted jobs have returned.
+func (s *scheduler) wait() {
+	s.wg.Wait()
+}
+
+// acquire locks every device key (in sorted order, so two jobs sharing several
+// devices cannot deadlock) and then takes a worker slot.
+func (s *scheduler) acquire(ctx context.Context, keys []string) (func(), error) {
+	keys = uniqueSorted(keys)
+
+	held := make([]*deviceLock, 0, len(keys))
+	releaseAll := func() {
+		for i := len(held) - 1; i >= 0; i-- {
+			<-held[i].ch
+		}
+		s.mu.Lock()
+		for _, key := range keys {
+			s.unref(key)
+		}
+		s.mu.Unlock()
+	}
+
+	s.mu.Lock()
+	refs := make([]*deviceLock, 0, len(keys))
+	for _, key := range keys {
+		lock, ok := s.locks[key]
+		if !ok {
+			lock = &deviceLock{ch: make(chan struct{}, 1)}
+			s.locks[key] = lock
+		}
+		lock.refs++
+		refs = append(refs, lock)
+	}
+	s.mu.Unlock()
+
+	for _, lock := range refs {
+		select {
+		case lock.ch <- struct{}{}:
+			held = append(held, lock)
+		case <-ctx.Done():
+			releaseAll()
+			return nil, ctx.Err()
+		}
+	}
+
+	select {
+	case s.slots <- struct{}{}:
+	case <-ctx.Done():
+		releaseAll()
+		return nil, ctx.Err()
+	}
+
+	return func() {
+		<-s.slots
+		releaseAll()
+	}, nil
+}
+
+func (s *scheduler) unref(key string) {
+	lock, ok := s.locks[key]
+	if !ok {
+		return
+	}
+
+	lock.refs--
+	if lock.refs <= 0 {
+		delete(s.locks, key)
+	}
+}
+
+func uniqueSorted(keys []string) []string {
+	seen := make(map[string]struct{}, len(keys))
+	out := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if key == "" {
+			continue
+		}
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		out = append(out, key)
+	}
+
+	sort.Strings(out)
+	return out
+}
+
+// scaleDeviceKey identifies the physical scale behind a ScaleConfig.
+func scaleDeviceKey(scale devices.ScaleConfig) string {
+	switch strings.ToLower(strings.TrimSpace(scale.Transport)) {
+	case "serial", "rs232", "com":
+		return "serial:" + strings.ToUpper(strings.TrimSpace(scale.SerialPort))
+	case "tcp", "ethernet":
+		return "tcp:" + net.JoinHostPort(strings.TrimSpace(scale.TCPHost), strconv.Itoa(scale.TCPPort))
+	case "tcp_server", "server_tcp", "dibal_tcp_server", "dibal_server":
+		return dibalDeviceKey(scale.BindHost, scale.RXPort)
+	}
+
+	return ""
+}
+
+// printerDeviceKey identifies the printer behind a PrinterConfig.
+func printerDeviceKey(printer devices.PrinterConfig) string {
+	switch strings.ToLower(strings.TrimSpace(printer.Transport)) {
+	case "windows", "windows_spooler", "spooler", "windows_printer":
+		return "spooler:" + strings.ToLower(strings.TrimSpace(printer.PrinterName))
+	case "dibal_direct", "dibal", "dibal_tcp_server", "dibal_server":
+		return dibalDeviceKey(printer.DibalBindHost, printer.DibalRXPort)
+	}
+
+	host := strings.TrimSpace(printer.Host)
+	if host == "" {
+		return ""
+	}
+
+	port := printer.Port
+	if port <= 0 {
+		port = 9100
+	}
+
+	return "printer:" + net.JoinHostPort(host, strconv.Itoa(port))
+}
+
+// dibalDeviceKey matches the DibalManager key ("bindHost:rxPort").
+func dibalDeviceKey(bindHost string, rxPort int) string {
+	bindHost = strings.TrimSpace(bindHost)
+	if bindHost == "" {
+		bindHost = "0.0.0.0"
+	}
+	if rxPort <= 0 {
+		rxPort = 3000
+	}
+
+	return fmt.Sprintf("dibal:%s:%d", bindHost, rxPort)
+}
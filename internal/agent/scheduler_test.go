@@ -0,0 +1,111 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSchedulerSerializesJobsOnTheSameDevice(t *testing.T) {
+	s := newScheduler(4)
+
+	var active, maxActive atomic.Int32
+	run := func(context.Context) {
+		current := active.Add(1)
+		for {
+			seen := maxActive.Load()
+			if current <= seen || maxActive.CompareAndSwap(seen, current) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		active.Add(-1)
+	}
+
+	for i := 0; i < 4; i++ {
+		if err := s.submit(context.Background(), []string{"serial:COM3"}, run, func(error) {}); err != nil {
+			t.Fatalf("submit: %v", err)
+		}
+	}
+	s.wait()
+
+	if maxActive.Load() != 1 {
+		t.Fatalf("expected jobs on one device to run one at a time, got %d in parallel", maxActive.Load())
+	}
+}
+
+func TestSchedulerRunsIndependentDevicesInParallel(t *testing.T) {
+	s := newScheduler(2)
+
+	started := make(chan struct{}, 2)
+	release := make(chan struct{})
+	run := func(context.Context) {
+		started <- struct{}{}
+		<-release
+	}
+
+	_ = s.submit(context.Background(), []string{"serial:COM3"}, run, func(error) {})
+	_ = s.submit(context.Background(), []string{"printer:192.168.1.120:9100"}, run, func(error) {})
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-started:
+		case <-time.After(time.Second):
+			t.Fatal("independent jobs did not start concurrently")
+		}
+	}
+
+	close(release)
+	s.wait()
+}
+
+func TestSchedulerAbortsWaitingJobWhenContextEnds(t *testing.T) {
+	s := newScheduler(1)
+
+	running := make(chan struct{})
+	release := make(chan struct{})
+	_ = s.submit(context.Background(), []string{"dibal:0.0.0.0:3000"}, func(context.Context) {
+		close(running)
+		<-release
+	}, func(error) {})
+	<-running
+
+	ctx, cancel := context.WithCancel(context.Background())
+	aborted := make(chan error, 1)
+	_ = s.submit(ctx, []string{"dibal:0.0.0.0:3000"}, func(context.Context) {
+		t.Error("waiting job must not run after its context ended")
+	}, func(err error) { aborted <- err })
+
+	cancel()
+	select {
+	case err := <-aborted:
+		if err == nil {
+			t.Fatal("expected abort error")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("waiting job was not aborted")
+	}
+
+	close(release)
+	s.wait()
+}
+
+func TestCommandDeviceKeys(t *testing.T) {
+	payload := json.RawMessage(`{
+		"scale": {"transport": "serial", "serial_port": "com3"},
+		"printer": {"model": "pm43c", "host": "192.168.1.120"}
+	}`)
+
+	keys := uniqueSorted(commandDeviceKeys("weigh_and_print", payload))
+	if len(keys) != 2 || keys[0] != "printer:192.168.1.120:9100" || keys[1] != "serial:COM3" {
+		t.Fatalf("unexpected keys: %v", keys)
+	}
+
+	dibal := json.RawMessage(`{"scale": {"transport": "dibal_tcp_server"}, "plu": {"Code": "1"}}`)
+	keys = commandDeviceKeys("program_dibal_plu", dibal)
+	if len(keys) != 1 || keys[0] != "dibal:0.0.0.0:3000" {
+		t.Fatalf("unexpected dibal keys: %v", keys)
+	}
+}
@@ -0,0 +1,222 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+var (
+	errSessionClosed = errors.New("sesja WebSocket zamknięta")
+	errDeadPeer      = errors.New("serwer nie odpowiada (brak ramek WebSocket)")
+)
+
+// wsWriteWait bounds a single frame write. A peer that stops reading blocks
+// the write instead of failing it, so this is the write-side dead-peer check.
+const wsWriteWait = 10 * time.Second
+
+// wsSession owns the write side of one WebSocket connection. gorilla/websocket
+// allows only one concurrent writer, and jobs now finish on worker goroutines,
+// so every outgoing message goes through a single writer goroutine. The same
+// goroutine sends ping frames; any frame from the server (message, ping or
+// pong) extends the read deadline, so a half-open connection surfaces as a
+// read timeout after deadAfter instead of hanging forever.
+type wsSession struct {
+	conn *websocket.Conn
+
+	pingEvery time.Duration
+	deadAfter time.Duration
+
+	out       chan outgoing
+	done      chan struct{}
+	writerErr chan error
+	writerWG  sync.WaitGroup
+	closeOnce sync.Once
+
+	// sent maps the keys of results and events queued on this session to
+	// when they were queued, so a replay does not send them twice and missing
+	// acks can be detected. Guarded by mu.
+	mu   sync.Mutex
+	sent map[string]time.Time
+}
+
+// outgoing is a queued message. written, if set, runs on the writer
+// goroutine once the message is on the socket.
+type outgoing struct {
+	message OutgoingMessage
+	written func()
+}
+
+func newWSSession(conn *websocket.Conn, pingEvery, deadAfter time.Duration) *wsSession {
+	if pingEvery <= 0 {
+		pingEvery = 20 * time.Second
+	}
+	if deadAfter <= pingEvery {
+		deadAfter = 3 * pingEvery
+	}
+
+	s := &wsSession{
+		conn:      conn,
+		pingEvery: pingEvery,
+		deadAfter: deadAfter,
+		out:       make(chan outgoing, 32),
+		done:      make(chan struct{}),
+		writerErr: make(chan error, 1),
+		sent:      make(map[string]time.Time),
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(deadAfter))
+	conn.SetPongHandler(func(string) error {
+		return s.touch()
+	})
+	conn.SetPingHandler(func(data string) error {
+		if err := s.touch(); err != nil {
+			return err
+		}
+		err := conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(wsWriteWait))
+		if errors.Is(err, websocket.ErrCloseSent) {
+			return nil
+		}
+		return err
+	})
+
+	s.writerWG.Add(1)
+	go s.writeLoop()
+
+	return s
+}
+
+// touch records that the server is alive.
+func (s *wsSession) touch() error {
+	return s.conn.SetReadDeadline(time.Now().Add(s.deadAfter))
+}
+
+// readJSON reads the next message. A read timeout means the server sent
+// nothing, not even a pong, within deadAfter and is reported as errDeadPeer.
+func (s *wsSession) readJSON(v any) error {
+	err := s.conn.ReadJSON(v)
+	if err != nil {
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return fmt.Errorf("%w: %v", errDeadPeer, s.deadAfter)
+		}
+		return err
+	}
+
+	return s.touch()
+}
+
+func (s *wsSession) writeLoop() {
+	defer s.writerWG.Done()
+
+	pingTicker := time.NewTicker(s.pingEvery)
+	defer pingTicker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-pingTicker.C:
+			if err := s.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteWait)); err != nil {
+				s.writerErr <- err
+				return
+			}
+		case item := <-s.out:
+			_ = s.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := s.conn.WriteJSON(item.message); err != nil {
+				s.writerErr <- err
+				return
+			}
+			if item.written != nil {
+				item.written()
+			}
+		}
+	}
+}
+
+// send queues a message for the writer goroutine. It fails once the session
+// has been closed; callers relying on delivery keep the message in the outbox.
+func (s *wsSession) send(message OutgoingMessage) error {
+	return s.queue(outgoing{message: message})
+}
+
+func (s *wsSession) queue(item outgoing) error {
+	select {
+	case <-s.done:
+		return errSessionClosed
+	default:
+	}
+
+	select {
+	case <-s.done:
+		return errSessionClosed
+	case s.out <- item:
+		return nil
+	}
+}
+
+// sendResult queues a command result unless this session already carries
+// it; queued reports which. written runs once the result is on the socket.
+func (s *wsSession) sendResult(out OutgoingMessage, written func()) (queued bool, err error) {
+	return s.sendOnce("result "+out.JobID, outgoing{message: out, written: written})
+}
+
+// sendEvent queues a buffered event unless this session already carries it.
+// written runs once the event is on the socket.
+func (s *wsSession) sendEvent(event OutgoingMessage, written func()) (queued bool, err error) {
+	return s.sendOnce("event "+event.EventID, outgoing{message: event, written: written})
+}
+
+func (s *wsSession) sendOnce(key string, item outgoing) (bool, error) {
+	s.mu.Lock()
+	if _, ok := s.sent[key]; ok {
+		s.mu.Unlock()
+		return false, nil
+	}
+	s.sent[key] = time.Now()
+	s.mu.Unlock()
+
+	return true, s.queue(item)
+}
+
+// forgetResult lets a new result of jobID be sent again, e.g. when a job
+// re-run with dedupe=false replaces the earlier result in the outbox.
+func (s *wsSession) forgetResult(jobID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.sent, "result "+jobID)
+}
+
+// sentBefore reports whether the result of jobID was queued on this session
+// before cutoff.
+func (s *wsSession) sentBefore(jobID string, cutoff time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sent, ok := s.sent["result "+jobID]
+	return ok && sent.Before(cutoff)
+}
+
+// errors reports the first write failure.
+func (s *wsSession) errors() <-chan error {
+	return s.writerErr
+}
+
+// close stops the writer goroutine and waits for it to exit. If final is not
+// nil it is written afterwards, still from a single goroutine.
+func (s *wsSession) close(final *OutgoingMessage) {
+	s.closeOnce.Do(func() {
+		close(s.done)
+		s.writerWG.Wait()
+
+		if final != nil {
+			_ = s.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			_ = s.conn.WriteJSON(final)
+		}
+	})
+}
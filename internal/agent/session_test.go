@@ -0,0 +1,154 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// startWSServer runs handler on every upgraded connection and returns a
+// client connection to it.
+func startWSServer(t *testing.T, handler func(conn *websocket.Conn)) *websocket.Conn {
+	t.Helper()
+
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer func() { _ = conn.Close() }()
+		handler(conn)
+	}))
+	t.Cleanup(server.Close)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	return conn
+}
+
+// newRecordingSession returns a session to a server that forwards every
+// message it reads.
+func newRecordingSession(t *testing.T) (*wsSession, <-chan OutgoingMessage) {
+	t.Helper()
+
+	received := make(chan OutgoingMessage, 64)
+	conn := startWSServer(t, func(conn *websocket.Conn) {
+		for {
+			var message OutgoingMessage
+			if err := conn.ReadJSON(&message); err != nil {
+				return
+			}
+			received <- message
+		}
+	})
+
+	session := newWSSession(conn, time.Minute, 3*time.Minute)
+	t.Cleanup(func() { session.close(nil) })
+
+	return session, received
+}
+
+// receiveMessages waits for n messages on received.
+func receiveMessages(t *testing.T, received <-chan OutgoingMessage, n int) []OutgoingMessage {
+	t.Helper()
+
+	var messages []OutgoingMessage
+	for len(messages) < n {
+		select {
+		case message := <-received:
+			messages = append(messages, message)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("received %d of %d messages", len(messages), n)
+		}
+	}
+
+	return messages
+}
+
+// waitUntil polls condition until it holds, failing the test after 5s.
+func waitUntil(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestSessionDetectsSilentPeer(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+
+	// The server never reads, so pings are never answered: a half-open peer.
+	conn := startWSServer(t, func(conn *websocket.Conn) {
+		<-release
+	})
+
+	session := newWSSession(conn, 50*time.Millisecond, 200*time.Millisecond)
+	defer session.close(nil)
+
+	started := time.Now()
+	var message IncomingMessage
+	err := session.readJSON(&message)
+	if !errors.Is(err, errDeadPeer) {
+		t.Fatalf("expected dead peer error, got %v", err)
+	}
+	if elapsed := time.Since(started); elapsed > 2*time.Second {
+		t.Fatalf("dead peer detected too late: %v", elapsed)
+	}
+}
+
+func TestSessionPongsKeepConnectionAlive(t *testing.T) {
+	// The server reads (and so answers pings) and sends one message late.
+	conn := startWSServer(t, func(conn *websocket.Conn) {
+		go func() {
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}()
+		time.Sleep(500 * time.Millisecond)
+		_ = conn.WriteJSON(IncomingMessage{Type: "ping"})
+		time.Sleep(100 * time.Millisecond)
+	})
+
+	session := newWSSession(conn, 50*time.Millisecond, 200*time.Millisecond)
+	defer session.close(nil)
+
+	var message IncomingMessage
+	if err := session.readJSON(&message); err != nil {
+		t.Fatalf("live peer reported as dead: %v", err)
+	}
+	if message.Type != "ping" {
+		t.Fatalf("unexpected message: %+v", message)
+	}
+}
+
+func TestAuthOKStoresResumeToken(t *testing.T) {
+	a := newTestAgent(t)
+
+	a.handleIncoming(context.Background(), nil, IncomingMessage{Type: "auth_ok", ResumeToken: "r-123"})
+	if got := a.getResumeToken(); got != "r-123" {
+		t.Fatalf("resume token not stored: %q", got)
+	}
+
+	a.handleIncoming(context.Background(), nil, IncomingMessage{Type: "auth_ok"})
+	if got := a.getResumeToken(); got != "r-123" {
+		t.Fatalf("empty auth_ok must keep the previous token, got %q", got)
+	}
+}
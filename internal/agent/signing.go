@@ -0,0 +1,160 @@
+package agent
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/NowakAdmin/BizantiAgent/internal/config"
+)
+
+// signatureContext prefixes the signed data so that a signature made for a
+// command cannot be reused for anything else signed with the same key.
+const signatureContext = "bizanti-agent-command-v2"
+
+// commandVerifier checks ed25519 signatures on incoming commands and rejects
+// stale and replayed ones. Nonces are remembered for the accepted clock
+// window; older commands are already rejected by their timestamp.
+type commandVerifier struct {
+	key    ed25519.PublicKey
+	maxAge time.Duration
+	// configErr is set when a key is configured but unusable. Every command
+	// is rejected then, rather than silently running unsigned.
+	configErr error
+	now       func() time.Time
+
+	mu     sync.Mutex
+	nonces map[string]time.Time
+}
+
+// newCommandVerifier returns nil when command signing is not configured.
+func newCommandVerifier(cfg config.CommandSigningConfig) *commandVerifier {
+	rawKey := strings.TrimSpace(cfg.PublicKey)
+	if rawKey == "" {
+		return nil
+	}
+
+	maxAge := time.Duration(cfg.MaxAgeSeconds) * time.Second
+	if maxAge <= 0 {
+		maxAge = 5 * time.Minute
+	}
+
+	v := &commandVerifier{maxAge: maxAge, now: time.Now, nonces: map[string]time.Time{}}
+
+	key, err := base64.StdEncoding.DecodeString(rawKey)
+	switch {
+	case err != nil:
+		v.configErr = fmt.Errorf("command_signing.public_key: %w", err)
+	case len(key) != ed25519.PublicKeySize:
+		v.configErr = fmt.Errorf("command_signing.public_key: oczekiwano %d bajtów, jest %d", ed25519.PublicKeySize, len(key))
+	default:
+		v.key = ed25519.PublicKey(key)
+	}
+
+	return v
+}
+
+// signedCommandData returns the bytes covered by the signature: the context
+// string, message type ("command" when empty), job_id, command, timestamp,
+// nonce, deadline, ttl, dedupe and compacted payload JSON, each on its own
+// line. An unset ttl or dedupe is an empty line. Fields other than the
+// payload must not contain newlines.
+func signedCommandData(message IncomingMessage) ([]byte, error) {
+	messageType := strings.ToLower(strings.TrimSpace(message.Type))
+	if messageType == "" {
+		messageType = "command"
+	}
+	ttl := ""
+	if message.TTLSeconds != 0 {
+		ttl = strconv.Itoa(message.TTLSeconds)
+	}
+	dedupe := ""
+	if message.Dedupe != nil {
+		dedupe = strconv.FormatBool(*message.Dedupe)
+	}
+
+	fields := []string{messageType, message.JobID, message.Command, message.Timestamp, message.Nonce, message.Deadline, ttl, dedupe}
+	for _, field := range fields {
+		if strings.ContainsAny(field, "\r\n") {
+			return nil, errors.New("niedozwolony znak nowej linii w podpisanych polach")
+		}
+	}
+
+	var payload bytes.Buffer
+	if len(message.Payload) > 0 {
+		if err := json.Compact(&payload, message.Payload); err != nil {
+			return nil, fmt.Errorf("payload: %w", err)
+		}
+	}
+
+	var data bytes.Buffer
+	data.WriteString(signatureContext)
+	for _, field := range fields {
+		data.WriteByte('\n')
+		data.WriteString(field)
+	}
+	data.WriteByte('\n')
+	data.Write(payload.Bytes())
+
+	return data.Bytes(), nil
+}
+
+// verify accepts a command only if it is signed with the pinned key, its
+// timestamp is within maxAge of the local clock and its nonce is new.
+func (v *commandVerifier) verify(message IncomingMessage) error {
+	if v.configErr != nil {
+		return fmt.Errorf("komenda odrzucona, błędny klucz podpisu: %w", v.configErr)
+	}
+	if message.Signature == "" {
+		return errors.New("komenda odrzucona: brak podpisu")
+	}
+	if strings.TrimSpace(message.Nonce) == "" {
+		return errors.New("komenda odrzucona: brak nonce")
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(message.Signature)
+	if err != nil || len(signature) != ed25519.SignatureSize {
+		return errors.New("komenda odrzucona: nieprawidłowy format podpisu")
+	}
+
+	data, err := signedCommandData(message)
+	if err != nil {
+		return fmt.Errorf("komenda odrzucona: %w", err)
+	}
+	if !ed25519.Verify(v.key, data, signature) {
+		return errors.New("komenda odrzucona: nieprawidłowy podpis")
+	}
+
+	// The timestamp is checked only after the signature, so it cannot be
+	// forged to pass the window.
+	issuedAt, err := time.Parse(time.RFC3339, message.Timestamp)
+	if err != nil {
+		return errors.New("komenda odrzucona: nieprawidłowy timestamp")
+	}
+	now := v.now()
+	if age := now.Sub(issuedAt); age > v.maxAge || age < -v.maxAge {
+		return fmt.Errorf("komenda odrzucona: przeterminowana (timestamp %s, dopuszczalna różnica %v)", message.Timestamp, v.maxAge)
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	for nonce, expires := range v.nonces {
+		if now.After(expires) {
+			delete(v.nonces, nonce)
+		}
+	}
+	if _, seen := v.nonces[message.Nonce]; seen {
+		return errors.New("komenda odrzucona: powtórzony nonce (replay)")
+	}
+	v.nonces[message.Nonce] = issuedAt.Add(v.maxAge)
+
+	return nil
+}
@@ -0,0 +1,150 @@
+package agent
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/NowakAdmin/BizantiAgent/internal/config"
+)
+
+func newSigningKey(t *testing.T) (string, ed25519.PrivateKey) {
+	t.Helper()
+
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+
+	return base64.StdEncoding.EncodeToString(public), private
+}
+
+func signedMessage(t *testing.T, key ed25519.PrivateKey, jobID, nonce string, issuedAt time.Time) IncomingMessage {
+	t.Helper()
+
+	return signMessage(t, key, IncomingMessage{
+		Type:      "command",
+		JobID:     jobID,
+		Command:   "unknown",
+		Payload:   json.RawMessage(`{ "a": 1 }`),
+		Timestamp: issuedAt.UTC().Format(time.RFC3339),
+		Nonce:     nonce,
+	})
+}
+
+func signMessage(t *testing.T, key ed25519.PrivateKey, message IncomingMessage) IncomingMessage {
+	t.Helper()
+
+	data, err := signedCommandData(message)
+	if err != nil {
+		t.Fatalf("signed data: %v", err)
+	}
+	message.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(key, data))
+
+	return message
+}
+
+func TestCommandVerifier(t *testing.T) {
+	publicKey, privateKey := newSigningKey(t)
+	_, otherKey := newSigningKey(t)
+	now := time.Now()
+
+	verifier := newCommandVerifier(config.CommandSigningConfig{PublicKey: publicKey, MaxAgeSeconds: 60})
+	if verifier == nil || verifier.configErr != nil {
+		t.Fatalf("verifier not configured: %+v", verifier)
+	}
+
+	if err := verifier.verify(signedMessage(t, privateKey, "1", "n1", now)); err != nil {
+		t.Fatalf("valid command rejected: %v", err)
+	}
+
+	tampered := signedMessage(t, privateKey, "2", "n2", now)
+	tampered.Payload = json.RawMessage(`{"a":2}`)
+	stale := signedMessage(t, privateKey, "3", "n3", now.Add(-2*time.Minute))
+	unsigned := signedMessage(t, privateKey, "4", "n4", now)
+	unsigned.Signature = ""
+	extended := signedMessage(t, privateKey, "6", "n6", now)
+	extended.TTLSeconds = 3600
+	undeduped := signedMessage(t, privateKey, "7", "n7", now)
+	undeduped.Dedupe = new(bool)
+	retyped := signedMessage(t, privateKey, "8", "n8", now)
+	retyped.Type = "cancel"
+
+	cases := map[string]IncomingMessage{
+		"unsigned":     unsigned,
+		"foreign":      signedMessage(t, otherKey, "5", "n5", now),
+		"tampered":     tampered,
+		"stale":        stale,
+		"replay":       signedMessage(t, privateKey, "1", "n1", now),
+		"added ttl":    extended,
+		"added dedupe": undeduped,
+		"changed type": retyped,
+	}
+	for name, message := range cases {
+		if err := verifier.verify(message); err == nil {
+			t.Fatalf("%s command accepted", name)
+		}
+	}
+}
+
+func TestSignedCommandDataIgnoresPayloadFormatting(t *testing.T) {
+	compact := IncomingMessage{JobID: "1", Command: "print_label", Payload: json.RawMessage(`{"a":1}`)}
+	spaced := compact
+	spaced.Payload = json.RawMessage("{\n  \"a\": 1\n}")
+
+	first, err := signedCommandData(compact)
+	if err != nil {
+		t.Fatalf("signed data: %v", err)
+	}
+	second, _ := signedCommandData(spaced)
+	if string(first) != string(second) {
+		t.Fatalf("payload whitespace changes signed data:\n%q\n%q", first, second)
+	}
+
+	if _, err = signedCommandData(IncomingMessage{JobID: "1\nx"}); err == nil {
+		t.Fatalf("newline in job_id accepted")
+	}
+}
+
+func TestInvalidSigningKeyRejectsEverything(t *testing.T) {
+	a := newTestAgent(t)
+	a.verifier = newCommandVerifier(config.CommandSigningConfig{PublicKey: "not-a-key"})
+
+	out := dispatchAndWait(t, a, IncomingMessage{Type: "command", JobID: "1", Command: "unknown"})
+	if out.Status != "failed" || !strings.Contains(out.Error, "klucz") {
+		t.Fatalf("expected rejection, got %+v", out)
+	}
+	if _, cached := a.recentResult("1"); cached {
+		t.Fatalf("rejected command must not enter the dedupe cache")
+	}
+}
+
+func TestCancelRequiresSignature(t *testing.T) {
+	publicKey, privateKey := newSigningKey(t)
+	a := newTestAgent(t)
+	a.verifier = newCommandVerifier(config.CommandSigningConfig{PublicKey: publicKey})
+
+	ctx, done, _ := a.startJob(context.Background(), IncomingMessage{JobID: "9"})
+	defer done()
+
+	a.handleCancel(IncomingMessage{Type: "cancel", JobID: "9"})
+	if ctx.Err() != nil {
+		t.Fatalf("unsigned cancel stopped the job")
+	}
+
+	a.handleCancel(signMessage(t, privateKey, IncomingMessage{
+		Type:      "cancel",
+		JobID:     "9",
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+		Nonce:     "c1",
+	}))
+	if !errors.Is(context.Cause(ctx), errJobCancelled) {
+		t.Fatalf("signed cancel ignored: %v", context.Cause(ctx))
+	}
+}
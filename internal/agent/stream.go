@@ -0,0 +1,274 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/NowakAdmin/BizantiAgent/internal/config"
+	"github.com/NowakAdmin/BizantiAgent/internal/devices"
+	"github.com/NowakAdmin/BizantiAgent/internal/localapi"
+)
+
+// maxStreamBackoff caps the delay between attempts to reopen the scale.
+const maxStreamBackoff = 30 * time.Second
+
+// scaleReader is an open scale the weight stream reads repeatedly.
+type scaleReader interface {
+	Read(ctx context.Context) (float64, string, error)
+	Close() error
+}
+
+// weightReading is the newest reading of the streamed scale.
+type weightReading struct {
+	weight float64
+	raw    string
+	err    error
+	at     time.Time
+}
+
+// weightStream reads one scale continuously for the local WebSocket stream.
+// It owns the scale while the agent runs, so read_weight jobs for the same
+// scale take their reading from the stream instead of opening the port.
+type weightStream struct {
+	key       string
+	interval  time.Duration
+	minChange float64
+	polled    bool
+	open      func(ctx context.Context) (scaleReader, error)
+	logger    *log.Logger
+
+	mu          sync.Mutex
+	subscribers map[chan localapi.WeightUpdate]struct{}
+	sent        *localapi.WeightUpdate
+	sentAt      time.Time
+	latest      weightReading
+	// readings is closed and replaced after every reading to wake jobs
+	// waiting in next.
+	readings chan struct{}
+}
+
+func (a *Agent) newWeightStream(cfg config.WeightStreamConfig) (*weightStream, error) {
+	scale := cfg.Scale
+	key := scaleDeviceKey(scale)
+	if key == "" {
+		return nil, errors.New("weight_stream.scale: brak lub nieznany transport")
+	}
+
+	s := &weightStream{
+		key:         key,
+		interval:    time.Duration(cfg.IntervalMs) * time.Millisecond,
+		minChange:   cfg.MinChangeKg,
+		polled:      scale.RequestCommand != "",
+		logger:      a.logger,
+		subscribers: make(map[chan localapi.WeightUpdate]struct{}),
+		readings:    make(chan struct{}),
+	}
+
+	if strings.HasPrefix(key, "dibal:") {
+		// The Dibal manager keeps its connections open already; every read
+		// goes through it.
+		s.polled = true
+		s.open = func(ctx context.Context) (scaleReader, error) {
+			mgr := a.getOrCreateDibalManager(scale.BindHost, scale.RXPort, dibalScaleTXPort(scale), scale.DibalAddr)
+			return dibalScaleReader{manager: mgr, scale: scale}, nil
+		}
+	} else {
+		s.open = func(ctx context.Context) (scaleReader, error) {
+			return devices.OpenScale(ctx, scale)
+		}
+	}
+
+	return s, nil
+}
+
+// dibalScaleReader reads a Dibal TCP server scale through its manager.
+type dibalScaleReader struct {
+	manager *devices.DibalManager
+	scale   devices.ScaleConfig
+}
+
+func (r dibalScaleReader) Read(ctx context.Context) (float64, string, error) {
+	if !r.manager.IsTXConnected() {
+		return 0, "", fmt.Errorf("waga Dibal nie jest połączona na porcie TX %d", dibalScaleTXPort(r.scale))
+	}
+
+	return devices.ReadWeightPersistent(ctx, r.manager, r.scale)
+}
+
+func (r dibalScaleReader) Close() error {
+	return nil
+}
+
+// run reads the scale until ctx ends, reopening it with backoff after
+// errors.
+func (s *weightStream) run(ctx context.Context) {
+	backoff := time.Second
+	for ctx.Err() == nil {
+		reader, err := s.open(ctx)
+		if err == nil {
+			s.logger.Printf("Strumień wagi: połączono z %s", s.key)
+			err = s.readAll(ctx, reader)
+			_ = reader.Close()
+			backoff = time.Second
+		}
+		if ctx.Err() != nil {
+			return
+		}
+
+		s.record(weightReading{err: err, at: time.Now()})
+		s.logger.Printf("Strumień wagi %s: %v — ponowna próba za %v", s.key, err, backoff)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, maxStreamBackoff)
+	}
+}
+
+// readAll reads until the first error. A scale that answers requests is
+// asked once per interval; one that sends readings by itself is read as fast
+// as it sends, so no stale lines pile up in the port buffer.
+func (s *weightStream) readAll(ctx context.Context, reader scaleReader) error {
+	for {
+		started := time.Now()
+		weight, raw, err := reader.Read(ctx)
+		if err != nil {
+			return err
+		}
+		s.record(weightReading{weight: weight, raw: raw, at: time.Now()})
+
+		if !s.polled {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(s.interval - time.Since(started)):
+		}
+	}
+}
+
+// record stores a reading, wakes waiting jobs and sends it to subscribers
+// unless it is too soon or too small a change.
+func (s *weightStream) record(reading weightReading) {
+	update := localapi.WeightUpdate{
+		Weight:      reading.weight,
+		RawResponse: reading.raw,
+		Timestamp:   reading.at.UTC().Format(time.RFC3339Nano),
+	}
+	if reading.err != nil {
+		update.Error = reading.err.Error()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.latest = reading
+	close(s.readings)
+	s.readings = make(chan struct{})
+
+	if !s.shouldSend(update, reading.at) {
+		return
+	}
+	s.sent = &update
+	s.sentAt = reading.at
+
+	for subscriber := range s.subscribers {
+		offer(subscriber, update)
+	}
+}
+
+// shouldSend must be called with s.mu held.
+func (s *weightStream) shouldSend(update localapi.WeightUpdate, at time.Time) bool {
+	switch {
+	case s.sent == nil:
+		return true
+	case update.Error != "" || s.sent.Error != "":
+		return update.Error != s.sent.Error
+	case at.Sub(s.sentAt) < s.interval:
+		return false
+	case s.minChange > 0:
+		return math.Abs(update.Weight-s.sent.Weight) >= s.minChange
+	default:
+		return update.Weight != s.sent.Weight
+	}
+}
+
+// offer replaces an unread update, so a slow client always gets the newest
+// reading instead of blocking the stream.
+func offer(subscriber chan localapi.WeightUpdate, update localapi.WeightUpdate) {
+	select {
+	case <-subscriber:
+	default:
+	}
+	subscriber <- update
+}
+
+func (s *weightStream) subscribe() (<-chan localapi.WeightUpdate, func()) {
+	subscriber := make(chan localapi.WeightUpdate, 1)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.sent != nil {
+		subscriber <- *s.sent
+	}
+	s.subscribers[subscriber] = struct{}{}
+
+	return subscriber, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		delete(s.subscribers, subscriber)
+	}
+}
+
+// next waits for a reading taken after since.
+func (s *weightStream) next(ctx context.Context, since time.Time, timeout time.Duration) (float64, string, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		s.mu.Lock()
+		latest, readings := s.latest, s.readings
+		s.mu.Unlock()
+
+		if latest.at.After(since) {
+			return latest.weight, latest.raw, latest.err
+		}
+
+		select {
+		case <-ctx.Done():
+			return 0, "", ctx.Err()
+		case <-timer.C:
+			return 0, "", errors.New("strumień wagi: brak nowego odczytu")
+		case <-readings:
+		}
+	}
+}
+
+// streamedWeight serves a read from the weight stream when it owns the
+// requested scale. ok is false for any other scale.
+func (a *Agent) streamedWeight(ctx context.Context, scale devices.ScaleConfig) (weight float64, raw string, ok bool, err error) {
+	if a.weights == nil || scaleDeviceKey(scale) != a.weights.key {
+		return 0, "", false, nil
+	}
+
+	timeout := 5 * time.Second
+	if scale.ReadTimeoutMs > 0 {
+		timeout = time.Duration(scale.ReadTimeoutMs) * time.Millisecond
+	}
+
+	reportProgress(ctx, phaseWeighing, nil)
+	weight, raw, err = a.weights.next(ctx, time.Now(), timeout)
+
+	return weight, raw, true, err
+}
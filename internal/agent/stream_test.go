@@ -0,0 +1,127 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/NowakAdmin/BizantiAgent/internal/config"
+	"github.com/NowakAdmin/BizantiAgent/internal/devices"
+)
+
+// fakeScale returns its readings in order, then reports the scale silent.
+type fakeScale struct {
+	readings chan float64
+}
+
+func (f *fakeScale) Read(ctx context.Context) (float64, string, error) {
+	select {
+	case weight := <-f.readings:
+		return weight, "", nil
+	case <-ctx.Done():
+		return 0, "", ctx.Err()
+	}
+}
+
+func (f *fakeScale) Close() error {
+	return nil
+}
+
+func newTestWeightStream(t *testing.T, minChange float64) (*Agent, *fakeScale) {
+	t.Helper()
+
+	a := newTestAgent(t)
+	stream, err := a.newWeightStream(config.WeightStreamConfig{
+		Scale:       devices.ScaleConfig{Transport: "serial", SerialPort: "COM3"},
+		MinChangeKg: minChange,
+	})
+	if err != nil {
+		t.Fatalf("newWeightStream: %v", err)
+	}
+
+	scale := &fakeScale{readings: make(chan float64)}
+	stream.open = func(context.Context) (scaleReader, error) { return scale, nil }
+	a.weights = stream
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		stream.run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	return a, scale
+}
+
+func TestWeightStreamSendsChangesAboveThreshold(t *testing.T) {
+	a, scale := newTestWeightStream(t, 0.05)
+	updates, unsubscribe := a.weights.subscribe()
+	defer unsubscribe()
+
+	expect := func(want float64) {
+		t.Helper()
+		select {
+		case update := <-updates:
+			if update.Weight != want || update.Error != "" {
+				t.Fatalf("expected %v, got %+v", want, update)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("no update for %v", want)
+		}
+	}
+
+	scale.readings <- 1.00
+	expect(1.00)
+
+	// Changes below the threshold are not sent. The stream records a reading
+	// before it reads the next one, so 1.02 is processed once 1.04 is taken.
+	scale.readings <- 1.02
+	scale.readings <- 1.04
+	select {
+	case update := <-updates:
+		t.Fatalf("change below threshold sent: %+v", update)
+	default:
+	}
+
+	scale.readings <- 1.10
+	expect(1.10)
+
+	// A late subscriber starts with the last reading sent.
+	late, unsubscribeLate := a.weights.subscribe()
+	defer unsubscribeLate()
+	if update := <-late; update.Weight != 1.10 {
+		t.Fatalf("late subscriber got %+v", update)
+	}
+}
+
+func TestReadWeightUsesStreamForItsScale(t *testing.T) {
+	a, scale := newTestWeightStream(t, 0)
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		scale.readings <- 2.5
+	}()
+
+	weight, _, ok, err := a.streamedWeight(context.Background(), devices.ScaleConfig{Transport: "com", SerialPort: "com3"})
+	if !ok || err != nil || weight != 2.5 {
+		t.Fatalf("streamedWeight = %v %t %v", weight, ok, err)
+	}
+
+	if _, _, ok, _ = a.streamedWeight(context.Background(), devices.ScaleConfig{Transport: "serial", SerialPort: "COM4"}); ok {
+		t.Fatalf("other scales must be read directly")
+	}
+}
+
+func TestWeightStreamTimesOutWithoutReading(t *testing.T) {
+	a, _ := newTestWeightStream(t, 0)
+
+	_, _, ok, err := a.streamedWeight(context.Background(), devices.ScaleConfig{Transport: "serial", SerialPort: "COM3", ReadTimeoutMs: 30})
+	if !ok || err == nil || errors.Is(err, context.Canceled) {
+		t.Fatalf("expected a timeout error, got %v", err)
+	}
+}
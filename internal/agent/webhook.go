@@ -0,0 +1,36 @@
+package agent
+
+import (
+	"context"
+	"path/filepath"
+
+	"github.com/NowakAdmin/BizantiAgent/internal/config"
+	"github.com/NowakAdmin/BizantiAgent/internal/webhook"
+)
+
+// startWebhooks delivers notifications to the configured webhooks until ctx
+// ends. Like startMQTT it runs before anything that emits events.
+func (a *Agent) startWebhooks(ctx context.Context) {
+	dispatcher, err := webhook.New(a.cfg.Webhooks, filepath.Join(config.Dir(), "webhooks"), a.logger)
+	if err != nil {
+		a.logger.Printf("Webhooki wyłączone: %v", err)
+		return
+	}
+	a.webhooks = dispatcher
+
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+		if runErr := dispatcher.Run(ctx); runErr != nil {
+			a.logger.Printf("Webhooki: %v", runErr)
+		}
+	}()
+}
+
+// notifyWebhooks queues a job result or device event for the webhooks. name
+// is the command or event name the webhook filters match.
+func (a *Agent) notifyWebhooks(kind, name string, message OutgoingMessage) {
+	if a.webhooks != nil {
+		a.webhooks.Notify(kind, name, message)
+	}
+}
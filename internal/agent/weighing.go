@@ -0,0 +1,147 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/NowakAdmin/BizantiAgent/internal/devices"
+)
+
+// dibalScaleTXPort is the port a Dibal TCP server scale sends weights to.
+func dibalScaleTXPort(scale devices.ScaleConfig) int {
+	switch {
+	case scale.TXPort > 0:
+		return scale.TXPort
+	case scale.TCPPort > 0:
+		return scale.TCPPort
+	default:
+		return 3001
+	}
+}
+
+func (a *Agent) readWeightWithIntermecFallback(ctx context.Context, scale devices.ScaleConfig, printer devices.PrinterConfig) (float64, string, error) {
+	if weight, response, ok, err := a.streamedWeight(ctx, scale); ok {
+		return weight, response, err
+	}
+
+	transport := strings.ToLower(strings.TrimSpace(scale.Transport))
+	if transport == "tcp_server" || transport == "server_tcp" || transport == "dibal_tcp_server" || transport == "dibal_server" {
+		bindHost := strings.TrimSpace(scale.BindHost)
+		if bindHost == "" {
+			bindHost = "0.0.0.0"
+		}
+
+		txPort := dibalScaleTXPort(scale)
+
+		rxPort := scale.RXPort
+		if rxPort <= 0 {
+			rxPort = 3000
+		}
+
+		a.logger.Printf("Tryb Dibal TCP server: nasłuch TX=%s:%d RX=%s:%d request=%t", bindHost, txPort, bindHost, rxPort, strings.TrimSpace(scale.RequestCommand) != "")
+
+		mgr := a.getOrCreateDibalManager(bindHost, rxPort, txPort, scale.DibalAddr)
+		timeout := 5 * time.Second
+		if scale.ReadTimeoutMs > 0 {
+			timeout = time.Duration(scale.ReadTimeoutMs) * time.Millisecond
+		}
+		if !mgr.IsTXConnected() {
+			reportProgress(ctx, phaseWaitingForScale, map[string]any{"tx_port": txPort})
+		}
+		if !mgr.WaitForTXConnected(ctx, timeout) {
+			if ctx.Err() != nil {
+				return 0, "", ctx.Err()
+			}
+			return 0, "", fmt.Errorf("waga Dibal nie jest połączona na porcie TX %d", txPort)
+		}
+
+		reportProgress(ctx, phaseWeighing, nil)
+		weight, response, err := devices.ReadWeightPersistent(ctx, mgr, scale)
+		if err == nil {
+			a.logger.Printf("Dibal TCP server: odebrano odczyt wagi: %s", response)
+			return weight, response, nil
+		}
+
+		a.logger.Printf("Dibal TCP server: błąd odczytu: %v", err)
+		return 0, "", err
+	}
+
+	reportProgress(ctx, phaseWeighing, nil)
+	weight, response, err := devices.ReadWeight(ctx, scale)
+	if err == nil {
+		if transport == "tcp_server" || transport == "server_tcp" || transport == "dibal_tcp_server" || transport == "dibal_server" {
+			a.logger.Printf("Dibal TCP server: odebrano odczyt wagi: %s", response)
+		}
+
+		return weight, response, nil
+	}
+
+	if transport == "tcp_server" || transport == "server_tcp" || transport == "dibal_tcp_server" || transport == "dibal_server" {
+		a.logger.Printf("Dibal TCP server: błąd odczytu: %v", err)
+	}
+
+	if ctx.Err() != nil || !shouldTryIntermecBridge(scale, printer) {
+		return 0, "", err
+	}
+
+	fallbackScale := intermecBridgeScale(scale, printer)
+	fallbackWeight, fallbackResponse, fallbackErr := devices.ReadWeight(ctx, fallbackScale)
+	if fallbackErr != nil {
+		return 0, "", fmt.Errorf("%w; fallback przez Intermec PM43 (%s:%d) nie powiódł się: %v", err, fallbackScale.TCPHost, fallbackScale.TCPPort, fallbackErr)
+	}
+
+	a.logger.Printf("Odczyt wagi przez fallback Intermec PM43 (%s:%d)", fallbackScale.TCPHost, fallbackScale.TCPPort)
+
+	return fallbackWeight, fallbackResponse, nil
+}
+
+// intermecBridgeScale is the scale config the Intermec fallback reads with:
+// scale.tcp_host and tcp_port when set, else the printer's address.
+func intermecBridgeScale(scale devices.ScaleConfig, printer devices.PrinterConfig) devices.ScaleConfig {
+	bridge := scale
+	bridge.Transport = "tcp"
+	if strings.TrimSpace(bridge.TCPHost) == "" {
+		bridge.TCPHost = strings.TrimSpace(printer.Host)
+	}
+	if bridge.TCPPort <= 0 {
+		if printer.Port > 0 {
+			bridge.TCPPort = printer.Port
+		} else {
+			bridge.TCPPort = 9100
+		}
+	}
+
+	return bridge
+}
+
+// intermecBridgeEndpoints lists what the Intermec fallback dials, for the
+// local policy, or nil when the fallback is not tried.
+func intermecBridgeEndpoints(scale devices.ScaleConfig, printer devices.PrinterConfig) []endpoint {
+	if !shouldTryIntermecBridge(scale, printer) {
+		return nil
+	}
+
+	bridge := intermecBridgeScale(scale, printer)
+	return []endpoint{{Host: strings.TrimSpace(bridge.TCPHost), Port: bridge.TCPPort}}
+}
+
+func shouldTryIntermecBridge(scale devices.ScaleConfig, printer devices.PrinterConfig) bool {
+	transport := strings.ToLower(strings.TrimSpace(scale.Transport))
+	if transport != "serial" && transport != "rs232" && transport != "com" {
+		return false
+	}
+
+	model := strings.ToLower(strings.TrimSpace(printer.Model))
+	if model != "" && !strings.Contains(model, "intermec") && !strings.Contains(model, "pm43") {
+		return false
+	}
+
+	printerTransport := strings.ToLower(strings.TrimSpace(printer.Transport))
+	if printerTransport != "" && printerTransport != "raw_tcp" && printerTransport != "tcp" && printerTransport != "network" && printerTransport != "jetdirect" {
+		return false
+	}
+
+	return strings.TrimSpace(printer.Host) != ""
+}
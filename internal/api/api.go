@@ -0,0 +1,210 @@
+// Package api is the HTTP client for the Bizanti agent API. Every call has a
+// timeout, idempotent calls are retried with jittered backoff, and failures
+// come back as typed errors (AuthError, RateLimitError, ServerError,
+// RequestError, NetworkError) the agent can branch on.
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultTimeout bounds one attempt of a call without its own timeout.
+	DefaultTimeout = 15 * time.Second
+	// DefaultAttempts is the number of tries for idempotent calls.
+	DefaultAttempts = 3
+
+	// maxDrain is how much of an unread body is discarded so the connection
+	// can be reused.
+	maxDrain = 64 << 10
+	// maxRetryAfter caps a server-requested delay between retries; longer
+	// waits are left to the caller.
+	maxRetryAfter = 30 * time.Second
+)
+
+// retryBase is the first retry delay. A variable so tests can shrink it.
+var retryBase = 500 * time.Millisecond
+
+// Client calls the Bizanti agent API on behalf of one agent.
+type Client struct {
+	// HTTP carries TLS and proxy settings. Its connections are reused
+	// across calls.
+	HTTP *http.Client
+
+	ServerURL string
+	Token     string
+	TenantID  string
+
+	// Timeout overrides DefaultTimeout and Attempts overrides
+	// DefaultAttempts when set.
+	Timeout  time.Duration
+	Attempts int
+}
+
+// Call describes one API request.
+type Call struct {
+	// Op names the call in errors, e.g. "heartbeat".
+	Op     string
+	Method string
+	Path   string
+	// Body is encoded as JSON when not nil.
+	Body any
+	// Idempotent calls are retried on network errors, 5xx and 429.
+	Idempotent bool
+	// Timeout overrides the client timeout for each attempt, e.g. for
+	// long-poll requests.
+	Timeout time.Duration
+}
+
+// Do runs the call and decodes a JSON response into out, if out is not nil.
+func (c *Client) Do(ctx context.Context, call Call, out any) error {
+	base := strings.TrimRight(strings.TrimSpace(c.ServerURL), "/")
+	if base == "" {
+		return fmt.Errorf("server_url is empty")
+	}
+
+	path := call.Path
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+
+	var body []byte
+	if call.Body != nil {
+		var err error
+		if body, err = json.Marshal(call.Body); err != nil {
+			return err
+		}
+	}
+
+	attempts := 1
+	if call.Idempotent {
+		attempts = c.Attempts
+		if attempts <= 0 {
+			attempts = DefaultAttempts
+		}
+	}
+
+	var err error
+	for attempt := 0; attempt < attempts; attempt++ {
+		if attempt > 0 {
+			if waitErr := sleep(ctx, retryDelay(attempt, err)); waitErr != nil {
+				return err
+			}
+		}
+
+		err = c.attempt(ctx, call, base+path, body, out)
+		if err == nil || !retryable(err) {
+			return err
+		}
+	}
+
+	return err
+}
+
+func (c *Client) attempt(ctx context.Context, call Call, url string, body []byte, out any) error {
+	timeout := call.Timeout
+	if timeout <= 0 {
+		timeout = c.Timeout
+	}
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	request, err := http.NewRequestWithContext(ctx, call.Method, url, reader)
+	if err != nil {
+		return err
+	}
+	if c.Token != "" {
+		request.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	request.Header.Set("Accept", "application/json")
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	if tenant := strings.TrimSpace(c.TenantID); tenant != "" {
+		request.Header.Set("X-Tenant-ID", tenant)
+	}
+
+	client := c.HTTP
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	response, err := client.Do(request)
+	if err != nil {
+		return &NetworkError{Op: call.Op, Err: err}
+	}
+	defer func() {
+		_, _ = io.Copy(io.Discard, io.LimitReader(response.Body, maxDrain))
+		_ = response.Body.Close()
+	}()
+
+	if response.StatusCode >= 300 {
+		responseBody, _ := io.ReadAll(io.LimitReader(response.Body, maxDrain))
+		return StatusError(call.Op, response, responseBody)
+	}
+
+	// Endpoints that only acknowledge may answer 204 or an empty 200.
+	if out == nil || response.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	if err = json.NewDecoder(response.Body).Decode(out); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return &NetworkError{Op: call.Op, Err: err}
+		}
+		return fmt.Errorf("%s: nieprawidłowa odpowiedź JSON: %w", call.Op, err)
+	}
+
+	return nil
+}
+
+func retryable(err error) bool {
+	var networkErr *NetworkError
+	var serverErr *ServerError
+	var rateErr *RateLimitError
+
+	return errors.As(err, &networkErr) || errors.As(err, &serverErr) || errors.As(err, &rateErr)
+}
+
+// retryDelay doubles from retryBase with ±50% jitter, so agents that lost
+// the server at the same moment do not retry in lockstep. A Retry-After from
+// the server wins, up to maxRetryAfter.
+func retryDelay(attempt int, lastErr error) time.Duration {
+	var rateErr *RateLimitError
+	if errors.As(lastErr, &rateErr) && rateErr.RetryAfter > 0 {
+		return min(rateErr.RetryAfter, maxRetryAfter)
+	}
+
+	delay := retryBase << (attempt - 1)
+	return delay/2 + rand.N(delay)
+}
+
+func sleep(ctx context.Context, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,151 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestClient(t *testing.T, handler http.HandlerFunc) (*Client, *atomic.Int32) {
+	t.Helper()
+
+	retryBase = time.Millisecond
+	t.Cleanup(func() { retryBase = 500 * time.Millisecond })
+
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		handler(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	return &Client{HTTP: server.Client(), ServerURL: server.URL + "/", Token: "token", TenantID: "7"}, &calls
+}
+
+func TestDoSendsCredentialsAndDecodes(t *testing.T) {
+	client, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/x" || r.Header.Get("Authorization") != "Bearer token" || r.Header.Get("X-Tenant-ID") != "7" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if r.Header.Get("Content-Type") != "application/json" {
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			return
+		}
+		_, _ = w.Write([]byte(`{"agent_id": 12}`))
+	})
+
+	var out struct {
+		AgentID int `json:"agent_id"`
+	}
+	err := client.Do(context.Background(), Call{Op: "x", Method: http.MethodPost, Path: "api/x", Body: map[string]any{"a": 1}}, &out)
+	if err != nil || out.AgentID != 12 {
+		t.Fatalf("Do = %+v, %v", out, err)
+	}
+}
+
+func TestEmptyResponsesSkipDecoding(t *testing.T) {
+	for name, handler := range map[string]http.HandlerFunc{
+		"204":       func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) },
+		"empty 200": func(w http.ResponseWriter, r *http.Request) {},
+		"empty 201": func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusCreated) },
+	} {
+		client, _ := newTestClient(t, handler)
+
+		var out struct {
+			AgentID int `json:"agent_id"`
+		}
+		if err := client.Do(context.Background(), Call{Op: "x", Method: http.MethodPost, Path: "/x"}, &out); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
+
+func TestIdempotentCallsRetryServerErrors(t *testing.T) {
+	client, calls := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	err := client.Do(context.Background(), Call{Op: "heartbeat", Method: http.MethodPost, Path: "/hb", Idempotent: true}, nil)
+	var serverErr *ServerError
+	if !errors.As(err, &serverErr) || serverErr.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("expected ServerError, got %v", err)
+	}
+	if calls.Load() != DefaultAttempts {
+		t.Fatalf("expected %d attempts, got %d", DefaultAttempts, calls.Load())
+	}
+
+	calls.Store(0)
+	_ = client.Do(context.Background(), Call{Op: "pull", Method: http.MethodGet, Path: "/next"}, nil)
+	if calls.Load() != 1 {
+		t.Fatalf("non-idempotent call retried: %d attempts", calls.Load())
+	}
+}
+
+func TestRetrySucceedsAfterTransientFailure(t *testing.T) {
+	var failures atomic.Int32
+	client, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if failures.Add(1) == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	if err := client.Do(context.Background(), Call{Op: "events", Method: http.MethodPost, Path: "/e", Idempotent: true}, nil); err != nil {
+		t.Fatalf("expected success after retry, got %v", err)
+	}
+}
+
+func TestTypedErrors(t *testing.T) {
+	cases := []struct {
+		status int
+		header string
+		check  func(error) bool
+	}{
+		{http.StatusUnauthorized, "", func(err error) bool { var e *AuthError; return errors.As(err, &e) }},
+		{http.StatusForbidden, "", func(err error) bool { var e *AuthError; return errors.As(err, &e) }},
+		{http.StatusTooManyRequests, "7", func(err error) bool {
+			var e *RateLimitError
+			return errors.As(err, &e) && e.RetryAfter == 7*time.Second
+		}},
+		{http.StatusNotFound, "", func(err error) bool { return HasStatus(err, http.StatusNotFound) }},
+	}
+
+	for _, tc := range cases {
+		client, calls := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+			if tc.header != "" {
+				w.Header().Set("Retry-After", tc.header)
+			}
+			w.WriteHeader(tc.status)
+		})
+
+		err := client.Do(context.Background(), Call{Op: "op", Method: http.MethodGet, Path: "/"}, nil)
+		if !tc.check(err) {
+			t.Fatalf("status %d: unexpected error %T %v", tc.status, err, err)
+		}
+		if calls.Load() != 1 {
+			t.Fatalf("status %d: %d attempts", tc.status, calls.Load())
+		}
+	}
+}
+
+func TestTimeoutIsNetworkError(t *testing.T) {
+	client, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	})
+
+	err := client.Do(context.Background(), Call{Op: "pull", Method: http.MethodGet, Path: "/", Timeout: 20 * time.Millisecond}, nil)
+	var networkErr *NetworkError
+	if !errors.As(err, &networkErr) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected NetworkError wrapping a deadline, got %v", err)
+	}
+}
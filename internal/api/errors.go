@@ -0,0 +1,154 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AuthError reports that the server rejected the agent token (HTTP 401/403).
+// It is never retried: the agent has to wait for a new token.
+type AuthError struct {
+	Op         string
+	StatusCode int
+}
+
+func (e *AuthError) Error() string {
+	return fmt.Sprintf("%s: serwer odrzucił token agenta (http %d)", e.Op, e.StatusCode)
+}
+
+// RateLimitError reports HTTP 429. RetryAfter is the delay requested by the
+// server, zero if it sent none.
+type RateLimitError struct {
+	Op         string
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	if e.RetryAfter > 0 {
+		return fmt.Sprintf("%s: serwer ogranicza liczbę zapytań (ponów za %v)", e.Op, e.RetryAfter)
+	}
+	return fmt.Sprintf("%s: serwer ogranicza liczbę zapytań", e.Op)
+}
+
+// ServerError reports a 5xx response.
+type ServerError struct {
+	Op         string
+	StatusCode int
+	Body       string
+}
+
+func (e *ServerError) Error() string {
+	return fmt.Sprintf("%s status %d: %s", e.Op, e.StatusCode, e.Body)
+}
+
+// RequestError reports any other non-2xx response. Retrying the same
+// request will not help.
+type RequestError struct {
+	Op         string
+	StatusCode int
+	Body       string
+}
+
+func (e *RequestError) Error() string {
+	return fmt.Sprintf("%s status %d: %s", e.Op, e.StatusCode, e.Body)
+}
+
+// NetworkError reports a request that got no HTTP response at all:
+// connection refused, TLS failure, timeout.
+type NetworkError struct {
+	Op  string
+	Err error
+}
+
+func (e *NetworkError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Op, e.Err)
+}
+
+func (e *NetworkError) Unwrap() error {
+	return e.Err
+}
+
+// HasStatus reports whether err is a RequestError or ServerError with one of
+// the given status codes.
+func HasStatus(err error, codes ...int) bool {
+	status := 0
+	var requestErr *RequestError
+	var serverErr *ServerError
+	switch {
+	case errors.As(err, &requestErr):
+		status = requestErr.StatusCode
+	case errors.As(err, &serverErr):
+		status = serverErr.StatusCode
+	default:
+		return false
+	}
+
+	for _, code := range codes {
+		if status == code {
+			return true
+		}
+	}
+
+	return false
+}
+
+// StatusError maps a response status to the matching typed error, or nil for
+// 2xx. body is summarized for the message.
+func StatusError(op string, response *http.Response, body []byte) error {
+	code := response.StatusCode
+	switch {
+	case code < 300:
+		return nil
+	case code == http.StatusUnauthorized || code == http.StatusForbidden:
+		return &AuthError{Op: op, StatusCode: code}
+	case code == http.StatusTooManyRequests:
+		return &RateLimitError{Op: op, RetryAfter: parseRetryAfter(response.Header.Get("Retry-After"))}
+	case code >= 500:
+		return &ServerError{Op: op, StatusCode: code, Body: SummarizeBody(body)}
+	default:
+		return &RequestError{Op: op, StatusCode: code, Body: SummarizeBody(body)}
+	}
+}
+
+// parseRetryAfter accepts both delay-seconds and HTTP-date forms.
+func parseRetryAfter(value string) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		if delay := time.Until(at); delay > 0 {
+			return delay
+		}
+	}
+
+	return 0
+}
+
+// SummarizeBody shortens a response body for error messages and drops HTML
+// error pages.
+func SummarizeBody(body []byte) string {
+	text := strings.TrimSpace(string(body))
+	if text == "" {
+		return "(pusta odpowiedź)"
+	}
+
+	lower := strings.ToLower(text)
+	if strings.Contains(lower, "<!doctype") || strings.Contains(lower, "<html") {
+		return "(odpowiedź HTML pominięta)"
+	}
+
+	const maxLen = 200
+	if len(text) > maxLen {
+		return text[:maxLen] + "..."
+	}
+
+	return text
+}
@@ -6,6 +6,9 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"strings"
+
+	"github.com/NowakAdmin/BizantiAgent/internal/devices"
 )
 
 type UpdateConfig struct {
@@ -13,6 +16,179 @@ type UpdateConfig struct {
 	CheckIntervalHours int    `json:"check_interval_hours"`
 }
 
+// TLSConfig adds trust and client authentication on top of the system roots
+// for connections to the Bizanti server. Relative paths are resolved against
+// Dir().
+type TLSConfig struct {
+	// CAFile is a PEM bundle of additional trusted root certificates.
+	CAFile string `json:"ca_file,omitempty"`
+	// ClientCertFile and ClientKeyFile enable mutual TLS.
+	ClientCertFile string `json:"client_cert_file,omitempty"`
+	ClientKeyFile  string `json:"client_key_file,omitempty"`
+	// SPKIPins, when set, restrict the server to certificates whose public
+	// key hashes ("sha256/<base64>") match one of the pins.
+	SPKIPins []string `json:"spki_pins,omitempty"`
+}
+
+// ProxyConfig routes server connections through an outbound proxy.
+type ProxyConfig struct {
+	// URL is "http://host:port" or "socks5://host:port".
+	URL      string `json:"url,omitempty"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	// NoProxy lists hosts, domain suffixes, IPs and CIDR ranges reached
+	// directly.
+	NoProxy []string `json:"no_proxy,omitempty"`
+	// UseEnvironment honours HTTP_PROXY/HTTPS_PROXY/NO_PROXY when URL is
+	// empty. Defaults to true.
+	UseEnvironment *bool `json:"use_environment,omitempty"`
+}
+
+// CommandSigningConfig enables verification of server-signed commands.
+type CommandSigningConfig struct {
+	// PublicKey is the server's base64-encoded ed25519 public key. When set,
+	// unsigned commands are rejected.
+	PublicKey string `json:"public_key,omitempty"`
+	// MaxAgeSeconds is how far a command timestamp may differ from the local
+	// clock. Defaults to 300.
+	MaxAgeSeconds int `json:"max_age_seconds,omitempty"`
+}
+
+// ReconnectConfig tunes how the agent backs off after connection failures
+// and when its circuit breaker pauses reconnecting altogether.
+type ReconnectConfig struct {
+	// InitialBackoffSeconds and MaxBackoffSeconds bound the exponential
+	// delay between attempts.
+	InitialBackoffSeconds int `json:"initial_backoff_seconds,omitempty"`
+	MaxBackoffSeconds     int `json:"max_backoff_seconds,omitempty"`
+	// JitterPercent spreads every delay by up to ±JitterPercent so agents do
+	// not reconnect in lockstep after an outage.
+	JitterPercent int `json:"jitter_percent,omitempty"`
+	// FailureThreshold consecutive failures open the breaker for
+	// PauseSeconds. A failed attempt after the pause doubles the pause, up
+	// to MaxPauseSeconds.
+	FailureThreshold int `json:"failure_threshold,omitempty"`
+	PauseSeconds     int `json:"pause_seconds,omitempty"`
+	MaxPauseSeconds  int `json:"max_pause_seconds,omitempty"`
+}
+
+// PolicyConfig limits what server commands may make the agent do. Each empty
+// list leaves that dimension unrestricted.
+type PolicyConfig struct {
+	// AllowedCommands lists the command names the agent executes.
+	AllowedCommands []string `json:"allowed_commands,omitempty"`
+	// DialHosts lists host names, IPs and CIDR ranges that printers and TCP
+	// scales may be reached at. Host names are matched literally, without
+	// DNS resolution.
+	DialHosts []string `json:"dial_hosts,omitempty"`
+	// DialPorts lists ports ("9100") or ranges ("9100-9102") that may be
+	// dialed.
+	DialPorts []string `json:"dial_ports,omitempty"`
+	// BindAddresses lists IPs and CIDR ranges listeners may bind to;
+	// "0.0.0.0" must be listed explicitly to allow binding to all interfaces.
+	BindAddresses []string `json:"bind_addresses,omitempty"`
+	// BindPorts lists ports or ranges listeners may use.
+	BindPorts []string `json:"bind_ports,omitempty"`
+}
+
+// LocalAPIConfig enables the REST API for on-premise applications. It only
+// listens on 127.0.0.1.
+type LocalAPIConfig struct {
+	Enabled bool `json:"enabled,omitempty"`
+	// Port defaults to 8765.
+	Port int `json:"port,omitempty"`
+	// Token is required as "Authorization: Bearer <token>". It is kept in
+	// the secret store like the agent token.
+	Token string `json:"token,omitempty"`
+
+	WeightStream WeightStreamConfig `json:"weight_stream"`
+}
+
+// WeightStreamConfig streams live readings of one continuously read scale
+// over the local API WebSocket and to MQTT. It runs when either is enabled.
+type WeightStreamConfig struct {
+	Enabled bool                `json:"enabled,omitempty"`
+	Scale   devices.ScaleConfig `json:"scale"`
+	// IntervalMs is the minimum time between readings sent to clients.
+	// Defaults to 200.
+	IntervalMs int `json:"interval_ms,omitempty"`
+	// MinChangeKg suppresses updates that differ from the last one sent by
+	// less than this. Zero sends every change.
+	MinChangeKg float64 `json:"min_change_kg,omitempty"`
+	// AllowedOrigins lists the web app origins, e.g.
+	// "https://pakowanie.firma.local", allowed to open the stream from a
+	// browser.
+	AllowedOrigins []string `json:"allowed_origins,omitempty"`
+}
+
+// MetricsConfig enables the Prometheus /metrics endpoint.
+type MetricsConfig struct {
+	Enabled bool `json:"enabled,omitempty"`
+	// Listen is the host:port to serve on. Defaults to 127.0.0.1:9464; use
+	// e.g. 0.0.0.0:9464 to let a remote Prometheus scrape the agent.
+	Listen string `json:"listen,omitempty"`
+}
+
+// MQTTConfig publishes weights, job results and device events to an MQTT
+// broker and optionally takes commands from it.
+type MQTTConfig struct {
+	Enabled bool `json:"enabled,omitempty"`
+	// Broker is the broker URL: "tcp://host:1883", "ssl://host:8883",
+	// "ws://host/mqtt" or "wss://host/mqtt".
+	Broker string `json:"broker,omitempty"`
+	// ClientID defaults to "bizanti-agent-<hostname>".
+	ClientID string `json:"client_id,omitempty"`
+	Username string `json:"username,omitempty"`
+	// Password is kept in the secret store like the agent token.
+	Password string `json:"password,omitempty"`
+	// TLS applies to ssl:// and wss:// brokers.
+	TLS TLSConfig `json:"tls"`
+	// QoS (0, 1 or 2) of published messages and of the command
+	// subscription. Defaults to 1.
+	QoS *int `json:"qos,omitempty"`
+	// TopicPrefix is the parent of the default topics. Defaults to
+	// "bizanti/<client_id>".
+	TopicPrefix string     `json:"topic_prefix,omitempty"`
+	Topics      MQTTTopics `json:"topics"`
+	// RetainWeight publishes weight readings as retained, so a new
+	// subscriber gets the last reading at once.
+	RetainWeight bool `json:"retain_weight,omitempty"`
+	// Commands subscribes to Topics.Commands and runs the commands received
+	// there like local API commands.
+	Commands bool `json:"commands,omitempty"`
+}
+
+// MQTTTopics overrides individual topics. Empty topics default to
+// TopicPrefix followed by "/status", "/weight", "/results", "/events" and
+// "/commands".
+type MQTTTopics struct {
+	// Status holds the retained "online"/"offline" state of the agent.
+	Status   string `json:"status,omitempty"`
+	Weight   string `json:"weight,omitempty"`
+	Results  string `json:"results,omitempty"`
+	Events   string `json:"events,omitempty"`
+	Commands string `json:"commands,omitempty"`
+}
+
+// WebhookConfig is a local HTTP endpoint that receives job results and device
+// events as signed JSON POSTs.
+type WebhookConfig struct {
+	Name string `json:"name,omitempty"`
+	URL  string `json:"url"`
+	// Secret signs every body with HMAC-SHA256. It is kept in the secret
+	// store like the agent token.
+	Secret string `json:"secret,omitempty"`
+	// Types limits deliveries to "command_result" and/or "event". Empty
+	// sends both.
+	Types []string `json:"types,omitempty"`
+	// Commands limits job results to these commands, e.g. "print_label".
+	// Empty sends all.
+	Commands []string `json:"commands,omitempty"`
+	// Events limits device events to these names, e.g. "dibal_connected".
+	// Empty sends all.
+	Events []string `json:"events,omitempty"`
+}
+
 type DibalServerConfig struct {
 	Name     string `json:"name,omitempty"`
 	BindHost string `json:"bind_host,omitempty"`
@@ -23,6 +199,10 @@ type DibalServerConfig struct {
 }
 
 type Config struct {
+	// secretErrs holds, by secret name, why a stored secret could not be
+	// read on Load.
+	secretErrs map[string]error
+
 	ServerURL        string              `json:"server_url"`
 	WebSocketURL     string              `json:"websocket_url"`
 	AgentToken       string              `json:"agent_token"`
@@ -30,15 +210,55 @@ type Config struct {
 	HeartbeatSeconds int                 `json:"heartbeat_seconds"`
 	Update           UpdateConfig        `json:"update"`
 	DibalServers     []DibalServerConfig `json:"dibal_servers,omitempty"`
+
+	// MaxConcurrentJobs limits how many commands run at the same time.
+	// Jobs touching the same device are always serialized.
+	MaxConcurrentJobs int `json:"max_concurrent_jobs,omitempty"`
+
+	// WSPingSeconds is the interval of WebSocket ping frames. A session with
+	// no frame at all from the server for WSDeadPeerSeconds is treated as
+	// dead and reconnected, which catches half-open TCP connections.
+	WSPingSeconds     int `json:"ws_ping_seconds,omitempty"`
+	WSDeadPeerSeconds int `json:"ws_dead_peer_seconds,omitempty"`
+
+	TLS   TLSConfig   `json:"tls"`
+	Proxy ProxyConfig `json:"proxy"`
+
+	// ReenrollOnUnauthorized starts device-code enrollment when the server
+	// rejects the agent token, instead of waiting for a new token.
+	ReenrollOnUnauthorized bool `json:"reenroll_on_unauthorized,omitempty"`
+
+	CommandSigning CommandSigningConfig `json:"command_signing"`
+
+	Policy PolicyConfig `json:"policy"`
+
+	Reconnect ReconnectConfig `json:"reconnect"`
+
+	LocalAPI LocalAPIConfig `json:"local_api"`
+
+	Metrics MetricsConfig `json:"metrics"`
+
+	MQTT MQTTConfig `json:"mqtt"`
+
+	Webhooks []WebhookConfig `json:"webhooks,omitempty"`
 }
 
 func Default() *Config {
 	return &Config{
-		ServerURL:        "https://bizanti.pl",
-		WebSocketURL:     "wss://bizanti.pl/agent/ws",
-		AgentToken:       "",
-		TenantID:         "",
-		HeartbeatSeconds: 30,
+		ServerURL:         "https://bizanti.pl",
+		WebSocketURL:      "wss://bizanti.pl/agent/ws",
+		AgentToken:        "",
+		TenantID:          "",
+		HeartbeatSeconds:  30,
+		MaxConcurrentJobs: 4,
+		WSPingSeconds:     20,
+		WSDeadPeerSeconds: 60,
+		Reconnect:         DefaultReconnect(),
+		LocalAPI: LocalAPIConfig{
+			Port:         8765,
+			WeightStream: WeightStreamConfig{IntervalMs: 200},
+		},
+		Metrics: MetricsConfig{Listen: "127.0.0.1:9464"},
 		Update: UpdateConfig{
 			GitHubRepo:         "NowakAdmin/BizantiAgent",
 			CheckIntervalHours: 6,
@@ -46,6 +266,46 @@ func Default() *Config {
 	}
 }
 
+// DefaultReconnect returns the reconnection settings used for unset fields.
+func DefaultReconnect() ReconnectConfig {
+	return ReconnectConfig{
+		InitialBackoffSeconds: 1,
+		MaxBackoffSeconds:     60,
+		JitterPercent:         50,
+		FailureThreshold:      3,
+		PauseSeconds:          300,
+		MaxPauseSeconds:       1800,
+	}
+}
+
+// WithDefaults fills unset or invalid fields from DefaultReconnect.
+func (r ReconnectConfig) WithDefaults() ReconnectConfig {
+	defaults := DefaultReconnect()
+	if r.InitialBackoffSeconds <= 0 {
+		r.InitialBackoffSeconds = defaults.InitialBackoffSeconds
+	}
+	if r.MaxBackoffSeconds < r.InitialBackoffSeconds {
+		r.MaxBackoffSeconds = max(defaults.MaxBackoffSeconds, r.InitialBackoffSeconds)
+	}
+	if r.JitterPercent <= 0 {
+		r.JitterPercent = defaults.JitterPercent
+	}
+	if r.JitterPercent > 100 {
+		r.JitterPercent = 100
+	}
+	if r.FailureThreshold <= 0 {
+		r.FailureThreshold = defaults.FailureThreshold
+	}
+	if r.PauseSeconds <= 0 {
+		r.PauseSeconds = defaults.PauseSeconds
+	}
+	if r.MaxPauseSeconds < r.PauseSeconds {
+		r.MaxPauseSeconds = max(defaults.MaxPauseSeconds, r.PauseSeconds)
+	}
+
+	return r
+}
+
 func LoadOrCreateDefault() (*Config, error) {
 	if _, err := os.Stat(Path()); errors.Is(err, os.ErrNotExist) {
 		cfg := Default()
@@ -69,6 +329,8 @@ func Load() (*Config, error) {
 		return nil, err
 	}
 
+	migrate := resolveSecrets(cfg)
+
 	if cfg.HeartbeatSeconds <= 0 {
 		cfg.HeartbeatSeconds = 30
 	}
@@ -77,6 +339,36 @@ func Load() (*Config, error) {
 		cfg.Update.CheckIntervalHours = 6
 	}
 
+	if cfg.MaxConcurrentJobs <= 0 {
+		cfg.MaxConcurrentJobs = 4
+	}
+
+	if cfg.WSPingSeconds <= 0 {
+		cfg.WSPingSeconds = 20
+	}
+
+	if cfg.WSDeadPeerSeconds <= cfg.WSPingSeconds {
+		cfg.WSDeadPeerSeconds = 3 * cfg.WSPingSeconds
+	}
+
+	cfg.Reconnect = cfg.Reconnect.WithDefaults()
+
+	if cfg.LocalAPI.Port <= 0 {
+		cfg.LocalAPI.Port = 8765
+	}
+
+	if strings.TrimSpace(cfg.Metrics.Listen) == "" {
+		cfg.Metrics.Listen = "127.0.0.1:9464"
+	}
+
+	if cfg.LocalAPI.WeightStream.IntervalMs <= 0 {
+		cfg.LocalAPI.WeightStream.IntervalMs = 200
+	}
+
+	if cfg.CommandSigning.MaxAgeSeconds <= 0 {
+		cfg.CommandSigning.MaxAgeSeconds = 300
+	}
+
 	for i := range cfg.DibalServers {
 		if cfg.DibalServers[i].BindHost == "" {
 			cfg.DibalServers[i].BindHost = "0.0.0.0"
@@ -92,6 +384,13 @@ func Load() (*Config, error) {
 		}
 	}
 
+	// Plaintext secrets from older versions are moved to the secret store.
+	// This is best effort: a process without write access to Dir() keeps
+	// working with the values it read.
+	if migrate {
+		_ = Save(cfg)
+	}
+
 	return cfg, nil
 }
 
@@ -100,12 +399,45 @@ func Save(cfg *Config) error {
 		return err
 	}
 
-	data, err := json.MarshalIndent(cfg, "", "  ")
+	// config.json only holds references; the secrets themselves are
+	// encrypted in SecretsDir().
+	stored, err := withSecretsStored(cfg)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(stored, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file and rename it over config.json so a crash
+	// mid-write (e.g. while rotating the token) never leaves a truncated
+	// config behind.
+	tmp, err := os.CreateTemp(Dir(), "config-*.tmp")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+
+	if _, err = tmp.Write(data); err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmpPath, 0o600)
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, Path())
+	}
 	if err != nil {
+		_ = os.Remove(tmpPath)
 		return err
 	}
 
-	return os.WriteFile(Path(), data, 0o600)
+	return nil
 }
 
 func Dir() string {
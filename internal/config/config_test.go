@@ -0,0 +1,150 @@
+package config
+
+import (
+	"os"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestPlaintextSecretsMigrateOnLoad(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	if err := os.MkdirAll(Dir(), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+
+	legacy := `{"server_url":"https://example.test","agent_token":"plain-token","proxy":{"url":"http://proxy:3128","password":"proxy-pass"}}`
+	if err := os.WriteFile(Path(), []byte(legacy), 0o600); err != nil {
+		t.Fatalf("write legacy config: %v", err)
+	}
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if cfg.AgentToken != "plain-token" || cfg.Proxy.Password != "proxy-pass" {
+		t.Fatalf("secrets not loaded: %+v", cfg)
+	}
+
+	data, _ := os.ReadFile(Path())
+	if strings.Contains(string(data), "plain-token") || strings.Contains(string(data), "proxy-pass") {
+		t.Fatalf("plaintext secrets left in config.json:\n%s", data)
+	}
+	if !strings.Contains(string(data), `"agent_token": "secret:agent_token"`) {
+		t.Fatalf("config.json should reference the secret store:\n%s", data)
+	}
+
+	reloaded, err := Load()
+	if err != nil || reloaded.AgentToken != "plain-token" || reloaded.Proxy.Password != "proxy-pass" {
+		t.Fatalf("reload after migration = %+v, %v", reloaded, err)
+	}
+}
+
+func TestSaveClearsRemovedSecret(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+
+	cfg := Default()
+	cfg.AgentToken = "token"
+	if err := Save(cfg); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+
+	cfg.AgentToken = ""
+	if err := Save(cfg); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+
+	loaded, err := Load()
+	if err != nil || loaded.AgentToken != "" {
+		t.Fatalf("cleared token came back: %+v, %v", loaded, err)
+	}
+}
+
+func TestSaveKeepsUnreadableSecret(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+
+	cfg := Default()
+	cfg.AgentToken = "token"
+	if err := Save(cfg); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+
+	// Another key, as on a machine the config was copied to.
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	loaded, err := Load()
+	if err != nil || loaded.AgentToken != "" || len(loaded.SecretErrors()) != 1 {
+		t.Fatalf("load = %+v, %v, secret errors %v", loaded, err, loaded.SecretErrors())
+	}
+	if err = Save(loaded); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+
+	names, err := openSecrets().Names()
+	if err != nil || !slices.Contains(names, "agent_token") {
+		t.Fatalf("unreadable secret deleted: %v, %v", names, err)
+	}
+	data, _ := os.ReadFile(Path())
+	if !strings.Contains(string(data), `"secret:agent_token"`) {
+		t.Fatalf("reference to the unreadable secret dropped:\n%s", data)
+	}
+}
+
+func TestWebhookSecretsAreStored(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+
+	cfg := Default()
+	cfg.Webhooks = []WebhookConfig{{URL: "http://erp.local/hook", Secret: "hook-secret"}}
+	if err := Save(cfg); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	if cfg.Webhooks[0].Secret != "hook-secret" {
+		t.Fatalf("Save replaced the in-memory secret with %q", cfg.Webhooks[0].Secret)
+	}
+
+	data, _ := os.ReadFile(Path())
+	if strings.Contains(string(data), "hook-secret") {
+		t.Fatalf("plaintext webhook secret in config.json:\n%s", data)
+	}
+
+	loaded, err := Load()
+	if err != nil || len(loaded.Webhooks) != 1 || loaded.Webhooks[0].Secret != "hook-secret" {
+		t.Fatalf("reload = %+v, %v", loaded, err)
+	}
+}
+
+func TestWebhookSecretsFollowTheirURL(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+
+	cfg := Default()
+	cfg.Webhooks = []WebhookConfig{
+		{URL: "http://erp.local/a", Secret: "secret-a"},
+		{URL: "http://erp.local/b", Secret: "secret-b"},
+	}
+	if err := Save(cfg); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+
+	// Removing the first webhook must neither hand its secret to the second
+	// nor leave it in the store.
+	cfg.Webhooks = cfg.Webhooks[1:]
+	if err := Save(cfg); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+
+	loaded, err := Load()
+	if err != nil || len(loaded.Webhooks) != 1 || loaded.Webhooks[0].Secret != "secret-b" {
+		t.Fatalf("reload = %+v, %v", loaded, err)
+	}
+	names, err := openSecrets().Names()
+	if err != nil || slices.Contains(names, webhookSecretName("http://erp.local/a")) {
+		t.Fatalf("removed webhook secret kept: %v, %v", names, err)
+	}
+	if !slices.Contains(names, webhookSecretName("http://erp.local/b")) {
+		t.Fatalf("webhook secret missing: %v", names)
+	}
+}
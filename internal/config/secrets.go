@@ -0,0 +1,168 @@
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/NowakAdmin/BizantiAgent/internal/secrets"
+)
+
+// secretRefPrefix marks a config.json value that names an entry in the
+// secret store instead of holding the secret itself.
+const secretRefPrefix = "secret:"
+
+// SecretsDir is where encrypted secrets are kept.
+func SecretsDir() string {
+	return filepath.Join(Dir(), "secrets")
+}
+
+// SecretKeyDir holds the local key of the secret store outside Dir(), so a
+// copied or backed-up config directory cannot be decrypted on its own. It is
+// $XDG_STATE_HOME/bizanti-agent, by default ~/.local/state/bizanti-agent.
+// Windows protects secrets with DPAPI and keeps no key file.
+func SecretKeyDir() string {
+	stateDir := os.Getenv("XDG_STATE_HOME")
+	if stateDir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			// Without a home directory the key stays where older
+			// versions kept it.
+			return SecretsDir()
+		}
+		stateDir = filepath.Join(home, ".local", "state")
+	}
+
+	return filepath.Join(stateDir, "bizanti-agent")
+}
+
+func openSecrets() *secrets.Store {
+	return secrets.Open(SecretsDir(), SecretKeyDir())
+}
+
+// secretFields lists the config values kept in the secret store, by name.
+func secretFields(cfg *Config) map[string]*string {
+	fields := map[string]*string{
+		"agent_token":     &cfg.AgentToken,
+		"proxy_password":  &cfg.Proxy.Password,
+		"local_api_token": &cfg.LocalAPI.Token,
+		"mqtt_password":   &cfg.MQTT.Password,
+	}
+	for i := range cfg.Webhooks {
+		name := webhookSecretName(cfg.Webhooks[i].URL)
+		if _, taken := fields[name]; taken {
+			// The same URL twice; the later entry keeps its own secret.
+			name = strings.TrimSuffix(name, "_secret") + fmt.Sprintf("_%d_secret", i)
+		}
+		fields[name] = &cfg.Webhooks[i].Secret
+	}
+
+	return fields
+}
+
+// webhookSecretPrefix starts the secret names of webhooks.
+const webhookSecretPrefix = "webhook_"
+
+// webhookSecretName keys a webhook secret by its URL, so adding, removing or
+// reordering webhooks does not hand one target another's secret.
+func webhookSecretName(rawURL string) string {
+	sum := sha256.Sum256([]byte(strings.TrimSpace(rawURL)))
+
+	return webhookSecretPrefix + hex.EncodeToString(sum[:8]) + "_secret"
+}
+
+// resolveSecrets replaces secret references with their values. It reports
+// whether any plaintext secret was found, which Load then migrates. A secret
+// that cannot be decrypted, e.g. in a config copied from another machine, is
+// left empty so the agent asks for it again instead of failing to start; its
+// error is kept in cfg.secretErrs and its stored file survives later saves.
+func resolveSecrets(cfg *Config) bool {
+	store := openSecrets()
+	plaintext := false
+
+	for name, field := range secretFields(cfg) {
+		value := *field
+		switch {
+		case strings.HasPrefix(value, secretRefPrefix):
+			resolved, err := store.Get(strings.TrimPrefix(value, secretRefPrefix))
+			if err != nil && !errors.Is(err, secrets.ErrNotFound) {
+				if cfg.secretErrs == nil {
+					cfg.secretErrs = map[string]error{}
+				}
+				cfg.secretErrs[name] = err
+			}
+			*field = resolved
+		case value != "":
+			plaintext = true
+		}
+	}
+
+	return plaintext
+}
+
+// SecretErrors lists the secrets Load could not read, sorted by name. Those
+// fields are empty until set again.
+func (cfg *Config) SecretErrors() []error {
+	names := slices.Sorted(maps.Keys(cfg.secretErrs))
+	errs := make([]error, 0, len(names))
+	for _, name := range names {
+		errs = append(errs, cfg.secretErrs[name])
+	}
+
+	return errs
+}
+
+// withSecretsStored writes the secrets of cfg to the store and returns a copy
+// of cfg holding only references to them.
+func withSecretsStored(cfg *Config) (*Config, error) {
+	store := openSecrets()
+	stored := *cfg
+	// Webhooks share their backing array with cfg; the copy must not
+	// overwrite the secrets cfg keeps using.
+	stored.Webhooks = slices.Clone(cfg.Webhooks)
+
+	for name, field := range secretFields(&stored) {
+		if *field == "" {
+			if _, unreadable := cfg.secretErrs[name]; unreadable {
+				// Not cleared by the user, only unreadable here: keep
+				// the stored file and the reference to it.
+				*field = secretRefPrefix + name
+				continue
+			}
+			if err := store.Delete(name); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if strings.HasPrefix(*field, secretRefPrefix) {
+			return nil, errors.New(name + ": wartość nie może zaczynać się od " + secretRefPrefix)
+		}
+		if err := store.Set(name, *field); err != nil {
+			return nil, err
+		}
+		*field = secretRefPrefix + name
+	}
+
+	// Secrets of webhooks that were removed or changed URL.
+	names, err := store.Names()
+	if err != nil {
+		return nil, err
+	}
+	fields := secretFields(&stored)
+	for _, name := range names {
+		if _, current := fields[name]; current || !strings.HasPrefix(name, webhookSecretPrefix) {
+			continue
+		}
+		if err = store.Delete(name); err != nil {
+			return nil, err
+		}
+	}
+
+	return &stored, nil
+}
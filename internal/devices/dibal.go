@@ -27,6 +27,7 @@ package devices
 // exchanges ENQ/ACK handshakes just like dibaldrv.exe would.
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"strconv"
@@ -70,6 +71,15 @@ type DibalPLU struct {
 	LabelNum string
 }
 
+type dibalLineHookKey struct{}
+
+// WithDibalLineHook returns a context that makes SendDibalLines call hook with
+// every register line the scale has acknowledged. The agent uses it to report
+// progress of multi-line sequences such as KB lock → PLU → KB unlock.
+func WithDibalLineHook(ctx context.Context, hook func(line string)) context.Context {
+	return context.WithValue(ctx, dibalLineHookKey{}, hook)
+}
+
 // buildDibalFrame converts a semicolon-delimited high-level Dibal register line
 // into a binary K-series frame.
 //
@@ -106,8 +116,10 @@ func buildDibalFrame(addr byte, semicolonLine string) []byte {
 }
 
 // sendDibalPacket executes one ENQ → ACK → frame → ACK exchange.
-func sendDibalPacket(conn net.Conn, frame []byte, timeout time.Duration) error {
-	_ = conn.SetDeadline(time.Now().Add(timeout))
+func sendDibalPacket(ctx context.Context, conn net.Conn, frame []byte, timeout time.Duration) error {
+	if err := setConnDeadline(ctx, conn, timeout); err != nil {
+		return err
+	}
 
 	// Send ENQ
 	if _, err := conn.Write([]byte{dibalENQ}); err != nil {
@@ -124,13 +136,17 @@ func sendDibalPacket(conn net.Conn, frame []byte, timeout time.Duration) error {
 	}
 
 	// Send frame
-	_ = conn.SetDeadline(time.Now().Add(timeout))
+	if err := setConnDeadline(ctx, conn, timeout); err != nil {
+		return err
+	}
 	if _, err := conn.Write(frame); err != nil {
 		return fmt.Errorf("błąd wysyłania ramki Dibal: %w", err)
 	}
 
 	// Wait for final ACK confirming data reception
-	_ = conn.SetDeadline(time.Now().Add(timeout))
+	if err := setConnDeadline(ctx, conn, timeout); err != nil {
+		return err
+	}
 	if _, err := conn.Read(ack); err != nil {
 		return fmt.Errorf("brak ACK po ramce Dibal: %w", err)
 	}
@@ -145,7 +161,8 @@ func sendDibalPacket(conn net.Conn, frame []byte, timeout time.Duration) error {
 }
 
 // SendDibalLines sends a sequence of high-level semicolon-delimited register
-// lines over an already-established TCP connection.
+// lines over an already-established TCP connection. Cancelling ctx interrupts
+// the ENQ/ACK handshake in progress and skips the remaining lines.
 //
 // Example lines:
 //
@@ -154,27 +171,37 @@ func sendDibalPacket(conn net.Conn, frame []byte, timeout time.Duration) error {
 //	    "X1;00;M;000001;MĄKA;001099;000000000000;0;...",            // PLU
 //	    "KB;00;02;03",                                               // unlock
 //	}
-func SendDibalLines(conn net.Conn, addr byte, lines []string, timeout time.Duration) error {
+func SendDibalLines(ctx context.Context, conn net.Conn, addr byte, lines []string, timeout time.Duration) error {
+	defer interruptOnDone(ctx, conn)()
+
+	hook, _ := ctx.Value(dibalLineHookKey{}).(func(line string))
+
 	for _, line := range lines {
 		line = strings.TrimSpace(strings.TrimRight(line, ";"))
 		if line == "" {
 			continue
 		}
 		frame := buildDibalFrame(addr, line)
-		if err := sendDibalPacket(conn, frame, timeout); err != nil {
+		if err := sendDibalPacket(ctx, conn, frame, timeout); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
 			preview := line
 			if len(preview) > 30 {
 				preview = preview[:30] + "…"
 			}
 			return fmt.Errorf("błąd Dibal dla '%s': %w", preview, err)
 		}
+		if hook != nil {
+			hook(line)
+		}
 	}
 	return nil
 }
 
 // SendDibalPLU programs a single PLU into the scale over an open TCP
 // connection, wrapped in the mandatory KB lock/unlock sequence.
-func SendDibalPLU(conn net.Conn, addr byte, plu DibalPLU, timeout time.Duration) error {
+func SendDibalPLU(ctx context.Context, conn net.Conn, addr byte, plu DibalPLU, timeout time.Duration) error {
 	mode := plu.Mode
 	if mode == "" {
 		mode = "M"
@@ -208,7 +235,7 @@ func SendDibalPLU(conn net.Conn, addr byte, plu DibalPLU, timeout time.Duration)
 		"KB;00;02;03", // unlock keyboard / end programming session
 	}
 
-	return SendDibalLines(conn, addr, lines, timeout)
+	return SendDibalLines(ctx, conn, addr, lines, timeout)
 }
 
 // SendDibalContentOverTCPServer listens on the RX port for the scale's inbound
@@ -216,7 +243,7 @@ func SendDibalPLU(conn net.Conn, addr byte, plu DibalPLU, timeout time.Duration)
 // lines. content is a newline-separated string of high-level semicolon lines.
 //
 // This replaces Windows Spooler for Dibal scales — no Windows driver needed.
-func SendDibalContentOverTCPServer(cfg PrinterConfig, content string) error {
+func SendDibalContentOverTCPServer(ctx context.Context, cfg PrinterConfig, content string) error {
 	bindHost := strings.TrimSpace(cfg.DibalBindHost)
 	if bindHost == "" {
 		bindHost = "0.0.0.0"
@@ -247,6 +274,7 @@ func SendDibalContentOverTCPServer(cfg PrinterConfig, content string) error {
 	defer func() {
 		_ = listener.Close()
 	}()
+	defer context.AfterFunc(ctx, func() { _ = listener.Close() })()
 
 	if tcpL, ok := listener.(*net.TCPListener); ok {
 		_ = tcpL.SetDeadline(time.Now().Add(timeout))
@@ -254,13 +282,16 @@ func SendDibalContentOverTCPServer(cfg PrinterConfig, content string) error {
 
 	conn, err := listener.Accept()
 	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
 		return fmt.Errorf("brak połączenia od wagi Dibal (RX %s) w ciągu %v: %w", rxAddr, timeout, err)
 	}
 	defer func() {
 		_ = conn.Close()
 	}()
 
-	return SendDibalLines(conn, addr, lines, timeout)
+	return SendDibalLines(ctx, conn, addr, lines, timeout)
 }
 
 // dibalPad zero-pads a string on the left to the requested length.
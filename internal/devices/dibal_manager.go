@@ -20,6 +20,7 @@ package devices
 //   - SendLines() and ReadWeight() use the cached connections under a mutex.
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"log"
@@ -37,6 +38,11 @@ type DibalManagerConfig struct {
 	TXPort   int    // Port scale connects to for sending weight data (default 3001)
 	Addr     byte   // Scale K-series address (default 1)
 	Logger   *log.Logger
+
+	// OnConnectionChange, when set, is called after the scale connects to or
+	// disconnects from a port. channel is "rx" or "tx". It runs on the
+	// accept goroutine and must not block.
+	OnConnectionChange func(channel string, connected bool, remote string)
 }
 
 // DibalManager holds live TCP connections to the Dibal scale.
@@ -96,6 +102,8 @@ func (m *DibalManager) Close() {
 		_ = m.txConn.Close()
 		m.txConn = nil
 	}
+	dibalConnected.Set(0, m.metricsServer(), "rx")
+	dibalConnected.Set(0, m.metricsServer(), "tx")
 }
 
 // acceptLoop listens on the given port and stores accepted connections.
@@ -171,6 +179,7 @@ func (m *DibalManager) acceptLoop(port int, isRX bool) {
 				m.txConn = conn
 			}
 			m.mu.Unlock()
+			m.notifyConnectionChange(label, true, remote)
 
 			// Wait until this connection closes (EOF/error), then re-accept.
 			waitForConnectionClose(conn, m.done)
@@ -187,6 +196,7 @@ func (m *DibalManager) acceptLoop(port int, isRX bool) {
 				}
 			}
 			m.mu.Unlock()
+			m.notifyConnectionChange(label, false, remote)
 		}
 
 		// Wait before reopening listener.
@@ -198,6 +208,24 @@ func (m *DibalManager) acceptLoop(port int, isRX bool) {
 	}
 }
 
+func (m *DibalManager) notifyConnectionChange(label string, connected bool, remote string) {
+	value := 0.0
+	if connected {
+		value = 1
+	}
+	dibalConnected.Set(value, m.metricsServer(), strings.ToLower(label))
+
+	if m.cfg.OnConnectionChange != nil {
+		m.cfg.OnConnectionChange(strings.ToLower(label), connected, remote)
+	}
+}
+
+// metricsServer labels this manager's metrics, matching the key the agent
+// uses for Dibal servers.
+func (m *DibalManager) metricsServer() string {
+	return net.JoinHostPort(m.cfg.BindHost, strconv.Itoa(m.cfg.RXPort))
+}
+
 // waitForConnectionClose blocks until conn is closed or manager is shutting down.
 func waitForConnectionClose(conn net.Conn, done chan struct{}) {
 	buf := make([]byte, 1)
@@ -229,7 +257,13 @@ func isTimeout(err error) bool {
 
 // SendLines sends semicolon-delimited Dibal register lines over the RX
 // connection. Returns an error if the scale is not connected.
-func (m *DibalManager) SendLines(lines []string, timeout time.Duration) error {
+// Cancelling ctx interrupts the handshake in progress; the connection is then
+// dropped because the scale may be left mid-frame.
+func (m *DibalManager) SendLines(ctx context.Context, lines []string, timeout time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	m.mu.Lock()
 	conn := m.rxConn
 	m.mu.Unlock()
@@ -238,7 +272,7 @@ func (m *DibalManager) SendLines(lines []string, timeout time.Duration) error {
 		return fmt.Errorf("waga Dibal nie jest połączona na porcie RX %d — sprawdź konfigurację Lantronix (Remote IP = IP tego komputera)", m.cfg.RXPort)
 	}
 
-	err := SendDibalLines(conn, m.cfg.Addr, lines, timeout)
+	err := SendDibalLines(ctx, conn, m.cfg.Addr, lines, timeout)
 	if err != nil {
 		// Mark connection as dead so acceptLoop can re-accept.
 		m.mu.Lock()
@@ -252,7 +286,11 @@ func (m *DibalManager) SendLines(lines []string, timeout time.Duration) error {
 }
 
 // ReadWeightFromTX reads one weight line from the TX connection.
-func (m *DibalManager) ReadWeightFromTX(timeout time.Duration) (float64, string, error) {
+func (m *DibalManager) ReadWeightFromTX(ctx context.Context, timeout time.Duration) (float64, string, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, "", err
+	}
+
 	m.mu.Lock()
 	conn := m.txConn
 	m.mu.Unlock()
@@ -261,8 +299,13 @@ func (m *DibalManager) ReadWeightFromTX(timeout time.Duration) (float64, string,
 		return 0, "", fmt.Errorf("waga Dibal nie jest połączona na porcie TX %d", m.cfg.TXPort)
 	}
 
-	line, err := readLineFromConn(conn, timeout)
+	stop := interruptOnDone(ctx, conn)
+	line, err := readLineFromConn(ctx, conn, timeout)
+	stop()
 	if err != nil {
+		if ctx.Err() != nil {
+			return 0, "", ctx.Err()
+		}
 		if !isTimeout(err) {
 			m.mu.Lock()
 			if m.txConn == conn {
@@ -292,47 +335,77 @@ func (m *DibalManager) IsTXConnected() bool {
 	return m.txConn != nil
 }
 
-// WaitForRXConnected waits up to timeout for an RX connection from the scale.
-func (m *DibalManager) WaitForRXConnected(timeout time.Duration) bool {
-	if timeout <= 0 {
-		timeout = 8 * time.Second
-	}
-	deadline := time.Now().Add(timeout)
-	for time.Now().Before(deadline) {
-		if m.IsRXConnected() {
-			return true
-		}
-		time.Sleep(200 * time.Millisecond)
+// DibalStatus is a snapshot of a DibalManager's listeners and connections.
+type DibalStatus struct {
+	BindHost    string `json:"bind_host"`
+	RXPort      int    `json:"rx_port"`
+	TXPort      int    `json:"tx_port"`
+	Addr        byte   `json:"addr"`
+	RXConnected bool   `json:"rx_connected"`
+	TXConnected bool   `json:"tx_connected"`
+}
+
+// Status returns the current state of the manager.
+func (m *DibalManager) Status() DibalStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return DibalStatus{
+		BindHost:    m.cfg.BindHost,
+		RXPort:      m.cfg.RXPort,
+		TXPort:      m.cfg.TXPort,
+		Addr:        m.cfg.Addr,
+		RXConnected: m.rxConn != nil,
+		TXConnected: m.txConn != nil,
 	}
-	return m.IsRXConnected()
+}
+
+// WaitForRXConnected waits up to timeout for an RX connection from the scale.
+// It returns false early when ctx ends.
+func (m *DibalManager) WaitForRXConnected(ctx context.Context, timeout time.Duration) bool {
+	return waitForCondition(ctx, timeout, m.IsRXConnected)
 }
 
 // WaitForTXConnected waits up to timeout for a TX connection from the scale.
-func (m *DibalManager) WaitForTXConnected(timeout time.Duration) bool {
+// It returns false early when ctx ends.
+func (m *DibalManager) WaitForTXConnected(ctx context.Context, timeout time.Duration) bool {
+	return waitForCondition(ctx, timeout, m.IsTXConnected)
+}
+
+func waitForCondition(ctx context.Context, timeout time.Duration, connected func() bool) bool {
 	if timeout <= 0 {
 		timeout = 8 * time.Second
 	}
-	deadline := time.Now().Add(timeout)
-	for time.Now().Before(deadline) {
-		if m.IsTXConnected() {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	poll := time.NewTicker(200 * time.Millisecond)
+	defer poll.Stop()
+
+	for {
+		if connected() {
 			return true
 		}
-		time.Sleep(200 * time.Millisecond)
+		select {
+		case <-ctx.Done():
+			return false
+		case <-deadline.C:
+			return connected()
+		case <-poll.C:
+		}
 	}
-	return m.IsTXConnected()
 }
 
 // SendDibalContentPersistent calls manager.SendLines with newline-split content.
-func SendDibalContentPersistent(manager *DibalManager, content string, timeout time.Duration) error {
+func SendDibalContentPersistent(ctx context.Context, manager *DibalManager, content string, timeout time.Duration) error {
 	if manager == nil {
 		return fmt.Errorf("brak DibalManager — skonfiguruj dibal_manager w agencie")
 	}
 	lines := strings.Split(content, "\n")
-	return manager.SendLines(lines, timeout)
+	return manager.SendLines(ctx, lines, timeout)
 }
 
 // SendDibalPLUPersistent programs a PLU via the persistent manager connection.
-func SendDibalPLUPersistent(manager *DibalManager, plu DibalPLU, timeout time.Duration) error {
+func SendDibalPLUPersistent(ctx context.Context, manager *DibalManager, plu DibalPLU, timeout time.Duration) error {
 	if manager == nil {
 		return fmt.Errorf("brak DibalManager — skonfiguruj dibal_manager w agencie")
 	}
@@ -368,14 +441,14 @@ func SendDibalPLUPersistent(manager *DibalManager, plu DibalPLU, timeout time.Du
 		"KB;00;02;03",
 	}
 
-	return manager.SendLines(lines, timeout)
+	return manager.SendLines(ctx, lines, timeout)
 }
 
 // ReadWeightPersistent reads weight using the persistent TX connection.
 // Falls back to single-shot tcp_server if manager is nil (backward compat).
-func ReadWeightPersistent(manager *DibalManager, cfg ScaleConfig) (float64, string, error) {
+func ReadWeightPersistent(ctx context.Context, manager *DibalManager, cfg ScaleConfig) (float64, string, error) {
 	if manager == nil {
-		return ReadWeight(cfg)
+		return ReadWeight(ctx, cfg)
 	}
 	timeout := time.Duration(cfg.ReadTimeoutMs) * time.Millisecond
 	if cfg.ReadTimeoutMs <= 0 {
@@ -384,10 +457,10 @@ func ReadWeightPersistent(manager *DibalManager, cfg ScaleConfig) (float64, stri
 
 	// Send request command via RX if configured.
 	if cfg.RequestCommand != "" && manager.IsRXConnected() {
-		_ = manager.SendLines([]string{cfg.RequestCommand}, timeout)
+		_ = manager.SendLines(ctx, []string{cfg.RequestCommand}, timeout)
 	}
 
-	return manager.ReadWeightFromTX(timeout)
+	return manager.ReadWeightFromTX(ctx, timeout)
 }
 
 // Ensure io is used (for future weight streaming).
@@ -0,0 +1,10 @@
+package devices
+
+import "github.com/NowakAdmin/BizantiAgent/internal/metrics"
+
+var (
+	printerSendErrors = metrics.NewCounter("bizanti_agent_printer_send_errors_total",
+		"Failed printer deliveries by transport (raw_tcp, windows_spooler, dibal_direct).", "transport")
+	dibalConnected = metrics.NewGauge("bizanti_agent_dibal_connected",
+		"1 while the Dibal scale is connected to a DibalManager port; server is bind_host:rx_port, channel rx or tx.", "server", "channel")
+)
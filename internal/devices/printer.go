@@ -1,31 +1,49 @@
 package devices
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"os"
 	"os/exec"
 	"runtime"
+	"strconv"
 	"strings"
 	"time"
 )
 
-func SendToPrinter(cfg PrinterConfig, content string) error {
+// PrinterTransports lists the printer transports SendToPrinter accepts,
+// canonical names only.
+var PrinterTransports = []string{"raw_tcp", "windows_spooler", "dibal_direct"}
+
+// SendToPrinter delivers rendered content to the printer. Cancelling ctx aborts
+// the dial, the write or the spooler process.
+func SendToPrinter(ctx context.Context, cfg PrinterConfig, content string) (err error) {
 	transport := strings.ToLower(strings.TrimSpace(cfg.Transport))
 
 	if transport == "" {
 		transport = "raw_tcp"
 	}
 
+	defer func() {
+		if err != nil && ctx.Err() == nil {
+			printerSendErrors.Inc(canonicalPrinterTransport(transport))
+		}
+	}()
+
 	switch transport {
 	case "raw_tcp", "tcp", "network", "jetdirect":
-		err := sendRawTCP(cfg, content)
+		err := sendRawTCP(ctx, cfg, content)
 		if err == nil {
 			return nil
 		}
 
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
 		if canUseWindowsSpoolerFallback(cfg) {
-			fallbackErr := sendWindowsSpooler(cfg, content)
+			fallbackErr := sendWindowsSpooler(ctx, cfg, content)
 			if fallbackErr == nil {
 				return nil
 			}
@@ -35,19 +53,34 @@ func SendToPrinter(cfg PrinterConfig, content string) error {
 
 		return err
 	case "windows", "windows_spooler", "spooler", "windows_printer":
-		return sendWindowsSpooler(cfg, content)
+		return sendWindowsSpooler(ctx, cfg, content)
 	case "dibal_direct", "dibal", "dibal_tcp_server", "dibal_server":
 		// Direct Dibal K-series protocol over TCP.
 		// The Lantronix adapter on the scale connects TO the PC (PC = server).
 		// content = newline-separated high-level semicolon-delimited register lines.
 		// KB lock/unlock lines must be included in content (or will be added automatically
 		// if content contains X1/28 lines without KB wrappers).
-		return SendDibalContentOverTCPServer(cfg, content)
+		return SendDibalContentOverTCPServer(ctx, cfg, content)
 	default:
 		return fmt.Errorf("nieobsługiwany transport drukarki: %s", transport)
 	}
 }
 
+// canonicalPrinterTransport maps transport aliases to the names in
+// PrinterTransports, or "unknown".
+func canonicalPrinterTransport(transport string) string {
+	switch transport {
+	case "raw_tcp", "tcp", "network", "jetdirect":
+		return "raw_tcp"
+	case "windows", "windows_spooler", "spooler", "windows_printer":
+		return "windows_spooler"
+	case "dibal_direct", "dibal", "dibal_tcp_server", "dibal_server":
+		return "dibal_direct"
+	default:
+		return "unknown"
+	}
+}
+
 func canUseWindowsSpoolerFallback(cfg PrinterConfig) bool {
 	if runtime.GOOS != "windows" {
 		return false
@@ -56,7 +89,7 @@ func canUseWindowsSpoolerFallback(cfg PrinterConfig) bool {
 	return strings.TrimSpace(cfg.PrinterName) != ""
 }
 
-func sendRawTCP(cfg PrinterConfig, content string) error {
+func sendRawTCP(ctx context.Context, cfg PrinterConfig, content string) error {
 	host := strings.TrimSpace(cfg.Host)
 	if host == "" {
 		return fmt.Errorf("brak host dla drukarki %s", cfg.Model)
@@ -72,22 +105,26 @@ func sendRawTCP(cfg PrinterConfig, content string) error {
 		timeout = 5 * time.Second
 	}
 
-	addr := fmt.Sprintf("%s:%d", host, port)
-	conn, err := net.DialTimeout("tcp", addr, timeout)
+	addr := net.JoinHostPort(host, strconv.Itoa(port))
+	dialer := net.Dialer{Timeout: timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
 	if err != nil {
-		return err
+		return contextErr(ctx, err)
 	}
 	defer func() {
 		_ = conn.Close()
 	}()
+	defer interruptOnDone(ctx, conn)()
 
-	_ = conn.SetDeadline(time.Now().Add(timeout))
+	if err = setConnDeadline(ctx, conn, timeout); err != nil {
+		return err
+	}
 
 	_, err = conn.Write([]byte(content))
-	return err
+	return contextErr(ctx, err)
 }
 
-func sendWindowsSpooler(cfg PrinterConfig, content string) error {
+func sendWindowsSpooler(ctx context.Context, cfg PrinterConfig, content string) error {
 	if runtime.GOOS != "windows" {
 		return fmt.Errorf("windows_spooler jest dostępny tylko na Windows")
 	}
@@ -117,9 +154,12 @@ func sendWindowsSpooler(cfg PrinterConfig, content string) error {
 		script = fmt.Sprintf("$ErrorActionPreference='Stop'; Get-Content -LiteralPath '%s' -Raw | Out-Printer -Name '%s'", pathArg, printerArg)
 	}
 
-	cmd := exec.Command("powershell", "-NoProfile", "-NonInteractive", "-ExecutionPolicy", "Bypass", "-Command", script)
+	cmd := exec.CommandContext(ctx, "powershell", "-NoProfile", "-NonInteractive", "-ExecutionPolicy", "Bypass", "-Command", script)
 	out, err := cmd.CombinedOutput()
 	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
 		msg := strings.TrimSpace(string(out))
 		if msg == "" {
 			msg = err.Error()
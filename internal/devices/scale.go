@@ -2,6 +2,7 @@ package devices
 
 import (
 	"bufio"
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -10,6 +11,7 @@ import (
 	"runtime"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"go.bug.st/serial"
@@ -17,7 +19,9 @@ import (
 
 var weightPattern = regexp.MustCompile(`([0-9]+(?:[\.,][0-9]+)?)\s?kg`)
 
-func ReadWeight(cfg ScaleConfig) (float64, string, error) {
+// ReadWeight reads one weight line from the scale. Cancelling ctx aborts the
+// serial read, TCP dial/read or listener accept immediately.
+func ReadWeight(ctx context.Context, cfg ScaleConfig) (float64, string, error) {
 	timeout := time.Duration(cfg.ReadTimeoutMs) * time.Millisecond
 	if cfg.ReadTimeoutMs <= 0 {
 		timeout = 3 * time.Second
@@ -27,40 +31,45 @@ func ReadWeight(cfg ScaleConfig) (float64, string, error) {
 
 	switch transport {
 	case "serial", "rs232", "com":
-		return readWeightSerial(cfg, timeout)
+		return readWeightSerial(ctx, cfg, timeout)
 	case "tcp", "ethernet":
-		return readWeightTCP(cfg, timeout)
+		return readWeightTCP(ctx, cfg, timeout)
 	case "tcp_server", "server_tcp", "dibal_tcp_server", "dibal_server":
-		return readWeightTCPServer(cfg, timeout)
+		return readWeightTCPServer(ctx, cfg, timeout)
 	default:
 		return 0, "", fmt.Errorf("nieobsługiwany transport wagi: %s", cfg.Transport)
 	}
 }
 
-func readWeightSerial(cfg ScaleConfig, timeout time.Duration) (float64, string, error) {
-	if strings.TrimSpace(cfg.SerialPort) == "" {
-		return 0, "", errors.New("brak serial_port w konfiguracji")
-	}
-
-	requestedPort := strings.TrimSpace(cfg.SerialPort)
-	availablePorts, portsErr := serial.GetPortsList()
-	resolvedPort := normalizeSerialPortName(requestedPort, availablePorts)
+// ScaleTransports lists the scale transports ReadWeight accepts, canonical
+// names only.
+var ScaleTransports = []string{"serial", "tcp", "tcp_server", "dibal_tcp_server"}
 
-	mode := buildSerialMode(cfg)
-	port, err := serial.Open(resolvedPort, mode)
-	if err != nil {
-		if portsErr != nil {
-			return 0, "", fmt.Errorf("nie można otworzyć portu %s: %w (nie udało się pobrać listy portów: %v)", resolvedPort, err, portsErr)
-		}
+// ListSerialPorts returns the serial ports present on this machine.
+func ListSerialPorts() ([]string, error) {
+	return serial.GetPortsList()
+}
 
-		if len(availablePorts) == 0 {
-			return 0, "", fmt.Errorf("nie można otworzyć portu %s: %w (brak dostępnych portów szeregowych)", resolvedPort, err)
-		}
+func readWeightSerial(ctx context.Context, cfg ScaleConfig, timeout time.Duration) (float64, string, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, "", err
+	}
 
-		return 0, "", fmt.Errorf("nie można otworzyć portu %s: %w (dostępne porty: %s)", resolvedPort, err, strings.Join(availablePorts, ", "))
+	port, err := openSerialPort(cfg)
+	if err != nil {
+		return 0, "", err
 	}
+	// Closing the port is the only way to interrupt a blocking serial read.
+	var closeOnce sync.Once
+	closePort := func() {
+		closeOnce.Do(func() {
+			_ = port.Close()
+		})
+	}
+	stop := context.AfterFunc(ctx, closePort)
 	defer func() {
-		_ = port.Close()
+		stop()
+		closePort()
 	}()
 
 	_ = port.SetReadTimeout(timeout)
@@ -71,7 +80,7 @@ func readWeightSerial(cfg ScaleConfig, timeout time.Duration) (float64, string,
 
 	line, err := readLineFromReader(port)
 	if err != nil {
-		return 0, "", err
+		return 0, "", contextErr(ctx, err)
 	}
 
 	weight, err := parseWeight(line)
@@ -82,6 +91,34 @@ func readWeightSerial(cfg ScaleConfig, timeout time.Duration) (float64, string,
 	return weight, line, nil
 }
 
+// openSerialPort opens the scale's serial port. The error lists the ports
+// that do exist, which is usually what the operator needs to fix the config.
+func openSerialPort(cfg ScaleConfig) (serial.Port, error) {
+	if strings.TrimSpace(cfg.SerialPort) == "" {
+		return nil, errors.New("brak serial_port w konfiguracji")
+	}
+
+	requestedPort := strings.TrimSpace(cfg.SerialPort)
+	availablePorts, portsErr := serial.GetPortsList()
+	resolvedPort := normalizeSerialPortName(requestedPort, availablePorts)
+
+	mode := buildSerialMode(cfg)
+	port, err := serial.Open(resolvedPort, mode)
+	if err != nil {
+		if portsErr != nil {
+			return nil, fmt.Errorf("nie można otworzyć portu %s: %w (nie udało się pobrać listy portów: %v)", resolvedPort, err, portsErr)
+		}
+
+		if len(availablePorts) == 0 {
+			return nil, fmt.Errorf("nie można otworzyć portu %s: %w (brak dostępnych portów szeregowych)", resolvedPort, err)
+		}
+
+		return nil, fmt.Errorf("nie można otworzyć portu %s: %w (dostępne porty: %s)", resolvedPort, err, strings.Join(availablePorts, ", "))
+	}
+
+	return port, nil
+}
+
 func buildSerialMode(cfg ScaleConfig) *serial.Mode {
 	baud := cfg.BaudRate
 	if baud <= 0 {
@@ -141,29 +178,33 @@ func normalizeSerialPortName(requested string, available []string) string {
 	return trimmed
 }
 
-func readWeightTCP(cfg ScaleConfig, timeout time.Duration) (float64, string, error) {
+func readWeightTCP(ctx context.Context, cfg ScaleConfig, timeout time.Duration) (float64, string, error) {
 	if strings.TrimSpace(cfg.TCPHost) == "" || cfg.TCPPort <= 0 {
 		return 0, "", errors.New("brak tcp_host/tcp_port w konfiguracji")
 	}
 
 	addr := net.JoinHostPort(cfg.TCPHost, strconv.Itoa(cfg.TCPPort))
-	conn, err := net.DialTimeout("tcp", addr, timeout)
+	dialer := net.Dialer{Timeout: timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
 	if err != nil {
-		return 0, "", err
+		return 0, "", contextErr(ctx, err)
 	}
 	defer func() {
 		_ = conn.Close()
 	}()
+	defer interruptOnDone(ctx, conn)()
 
-	_ = conn.SetDeadline(time.Now().Add(timeout))
+	if err = setConnDeadline(ctx, conn, timeout); err != nil {
+		return 0, "", err
+	}
 
 	if cfg.RequestCommand != "" {
 		_, _ = conn.Write([]byte(cfg.RequestCommand))
 	}
 
-	line, err := readLineFromConn(conn, timeout)
+	line, err := readLineFromConn(ctx, conn, timeout)
 	if err != nil {
-		return 0, "", err
+		return 0, "", contextErr(ctx, err)
 	}
 
 	weight, err := parseWeight(line)
@@ -174,7 +215,7 @@ func readWeightTCP(cfg ScaleConfig, timeout time.Duration) (float64, string, err
 	return weight, line, nil
 }
 
-func readWeightTCPServer(cfg ScaleConfig, timeout time.Duration) (float64, string, error) {
+func readWeightTCPServer(ctx context.Context, cfg ScaleConfig, timeout time.Duration) (float64, string, error) {
 	bindHost := strings.TrimSpace(cfg.BindHost)
 	if bindHost == "" {
 		bindHost = "0.0.0.0"
@@ -202,6 +243,7 @@ func readWeightTCPServer(cfg ScaleConfig, timeout time.Duration) (float64, strin
 	defer func() {
 		_ = txListener.Close()
 	}()
+	defer context.AfterFunc(ctx, func() { _ = txListener.Close() })()
 
 	var rxListener net.Listener
 	if cfg.RequestCommand != "" {
@@ -213,6 +255,7 @@ func readWeightTCPServer(cfg ScaleConfig, timeout time.Duration) (float64, strin
 		defer func() {
 			_ = rxListener.Close()
 		}()
+		defer context.AfterFunc(ctx, func() { _ = rxListener.Close() })()
 	}
 
 	txConnCh := make(chan net.Conn, 1)
@@ -238,8 +281,12 @@ func readWeightTCPServer(cfg ScaleConfig, timeout time.Duration) (float64, strin
 			defer func() {
 				_ = rxConn.Close()
 			}()
+			defer interruptOnDone(ctx, rxConn)()
 
-			_ = rxConn.SetWriteDeadline(time.Now().Add(timeout))
+			if deadlineErr := setConnDeadline(ctx, rxConn, timeout); deadlineErr != nil {
+				rxResultCh <- deadlineErr
+				return
+			}
 			if _, writeErr := rxConn.Write([]byte(cfg.RequestCommand)); writeErr != nil {
 				rxResultCh <- fmt.Errorf("błąd wysyłania request_command do RX: %w", writeErr)
 				return
@@ -254,22 +301,29 @@ func readWeightTCPServer(cfg ScaleConfig, timeout time.Duration) (float64, strin
 	case conn := <-txConnCh:
 		txConn = conn
 	case acceptErr := <-txErrCh:
+		if ctx.Err() != nil {
+			return 0, "", ctx.Err()
+		}
 		return 0, "", fmt.Errorf("błąd połączenia TX: %w", acceptErr)
 	}
 	defer func() {
 		_ = txConn.Close()
 	}()
+	defer interruptOnDone(ctx, txConn)()
 
 	if cfg.RequestCommand != "" {
 		rxErr := <-rxResultCh
 		if rxErr != nil {
+			if ctx.Err() != nil {
+				return 0, "", ctx.Err()
+			}
 			return 0, "", fmt.Errorf("błąd połączenia RX: %w", rxErr)
 		}
 	}
 
-	line, err := readLineFromConn(txConn, timeout)
+	line, err := readLineFromConn(ctx, txConn, timeout)
 	if err != nil {
-		return 0, "", err
+		return 0, "", contextErr(ctx, err)
 	}
 
 	weight, err := parseWeight(line)
@@ -293,11 +347,47 @@ func acceptSingleConnection(listener net.Listener, timeout time.Duration) (net.C
 	return conn, nil
 }
 
-func readLineFromConn(conn net.Conn, timeout time.Duration) (string, error) {
-	_ = conn.SetReadDeadline(time.Now().Add(timeout))
+func readLineFromConn(ctx context.Context, conn net.Conn, timeout time.Duration) (string, error) {
+	if err := setConnDeadline(ctx, conn, timeout); err != nil {
+		return "", err
+	}
 	return readLineFromReader(conn)
 }
 
+// interruptOnDone makes blocking I/O on conn return as soon as ctx ends by
+// moving its deadline into the past. Call the returned func when done.
+func interruptOnDone(ctx context.Context, conn net.Conn) func() {
+	stop := context.AfterFunc(ctx, func() {
+		_ = conn.SetDeadline(time.Unix(1, 0))
+	})
+
+	return func() {
+		stop()
+	}
+}
+
+// setConnDeadline sets the I/O deadline to now+timeout, capped by the ctx
+// deadline. Checking ctx afterwards closes the race with interruptOnDone:
+// a cancellation that already fired is reported instead of being overwritten.
+func setConnDeadline(ctx context.Context, conn net.Conn, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+
+	_ = conn.SetDeadline(deadline)
+	return ctx.Err()
+}
+
+// contextErr prefers the context error over the I/O error it caused.
+func contextErr(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+
+	return err
+}
+
 func readLineFromReader(r io.Reader) (string, error) {
 	reader := bufio.NewReader(r)
 	line, err := reader.ReadString('\n')
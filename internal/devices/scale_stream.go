@@ -0,0 +1,118 @@
+package devices
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var errScaleSilent = errors.New("waga nie przysłała odczytu")
+
+// serialTimeoutConn reports a serial read timeout, which the port returns as
+// (0, nil), as an error. Otherwise bufio would retry a silent scale up to a
+// hundred times before giving up.
+type serialTimeoutConn struct {
+	io.ReadWriteCloser
+}
+
+func (c serialTimeoutConn) Read(p []byte) (int, error) {
+	n, err := c.ReadWriteCloser.Read(p)
+	if n == 0 && err == nil {
+		return 0, errScaleSilent
+	}
+
+	return n, err
+}
+
+// ScaleConn keeps a serial or TCP scale connection open for repeated reads.
+// ReadWeight opens and closes the port for every reading, which is too slow
+// and too disruptive for a live display.
+type ScaleConn struct {
+	cfg     ScaleConfig
+	timeout time.Duration
+	conn    io.ReadWriteCloser
+	reader  *bufio.Reader
+
+	// setReadDeadline is set for TCP connections; serial ports use the read
+	// timeout configured when the port is opened.
+	setReadDeadline func(time.Time) error
+}
+
+// OpenScale connects to a serial or TCP scale. Dibal TCP server scales are
+// already connected persistently through DibalManager and are not supported.
+func OpenScale(ctx context.Context, cfg ScaleConfig) (*ScaleConn, error) {
+	timeout := time.Duration(cfg.ReadTimeoutMs) * time.Millisecond
+	if cfg.ReadTimeoutMs <= 0 {
+		timeout = 3 * time.Second
+	}
+
+	c := &ScaleConn{cfg: cfg, timeout: timeout}
+
+	switch strings.ToLower(strings.TrimSpace(cfg.Transport)) {
+	case "serial", "rs232", "com":
+		port, err := openSerialPort(cfg)
+		if err != nil {
+			return nil, err
+		}
+		_ = port.SetReadTimeout(timeout)
+		c.conn = serialTimeoutConn{port}
+	case "tcp", "ethernet":
+		if strings.TrimSpace(cfg.TCPHost) == "" || cfg.TCPPort <= 0 {
+			return nil, errors.New("brak tcp_host/tcp_port w konfiguracji")
+		}
+		dialer := net.Dialer{Timeout: timeout}
+		conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(cfg.TCPHost, strconv.Itoa(cfg.TCPPort)))
+		if err != nil {
+			return nil, contextErr(ctx, err)
+		}
+		c.conn = conn
+		c.setReadDeadline = conn.SetReadDeadline
+	default:
+		return nil, fmt.Errorf("transport wagi %s nie obsługuje ciągłego odczytu", cfg.Transport)
+	}
+
+	c.reader = bufio.NewReader(c.conn)
+
+	return c, nil
+}
+
+// Read returns the next weight. With a request_command it asks the scale
+// first; otherwise it waits for the next line the scale sends by itself.
+// Cancelling ctx closes the connection.
+func (c *ScaleConn) Read(ctx context.Context) (float64, string, error) {
+	stop := context.AfterFunc(ctx, func() { _ = c.conn.Close() })
+	defer stop()
+
+	if c.cfg.RequestCommand != "" {
+		if _, err := c.conn.Write([]byte(c.cfg.RequestCommand)); err != nil {
+			return 0, "", contextErr(ctx, err)
+		}
+	}
+	if c.setReadDeadline != nil {
+		_ = c.setReadDeadline(time.Now().Add(c.timeout))
+	}
+
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return 0, "", contextErr(ctx, err)
+	}
+
+	line = strings.TrimSpace(line)
+	weight, err := parseWeight(line)
+	if err != nil {
+		return 0, line, err
+	}
+
+	return weight, line, nil
+}
+
+// Close releases the port.
+func (c *ScaleConn) Close() error {
+	return c.conn.Close()
+}
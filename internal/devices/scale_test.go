@@ -1,6 +1,7 @@
 package devices
 
 import (
+	"context"
 	"net"
 	"strconv"
 	"testing"
@@ -26,7 +27,7 @@ func TestReadWeightTCPServerTXOnly(t *testing.T) {
 		_, _ = conn.Write([]byte("12.34 kg\r\n"))
 	}()
 
-	weight, raw, err := ReadWeight(cfg)
+	weight, raw, err := ReadWeight(context.Background(), cfg)
 	if err != nil {
 		t.Fatalf("ReadWeight returned error: %v", err)
 	}
@@ -81,7 +82,7 @@ func TestReadWeightTCPServerWithRequestCommand(t *testing.T) {
 		_, _ = conn.Write([]byte("7.500 kg\n"))
 	}()
 
-	weight, raw, err := ReadWeight(cfg)
+	weight, raw, err := ReadWeight(context.Background(), cfg)
 	if err != nil {
 		t.Fatalf("ReadWeight returned error: %v", err)
 	}
@@ -138,3 +139,80 @@ func dialWithRetry(t *testing.T, host string, port int, maxWait time.Duration) n
 		time.Sleep(50 * time.Millisecond)
 	}
 }
+
+func TestReadWeightTCPServerStopsWhenContextIsCancelled(t *testing.T) {
+	cfg := ScaleConfig{
+		Transport:     "tcp_server",
+		BindHost:      "127.0.0.1",
+		TXPort:        reserveFreePort(t),
+		ReadTimeoutMs: 10000,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	started := time.Now()
+	_, _, err := ReadWeight(ctx, cfg)
+	if err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+
+	if elapsed := time.Since(started); elapsed > 2*time.Second {
+		t.Fatalf("cancelled read took %v, listener was not closed", elapsed)
+	}
+}
+
+func TestScaleConnKeepsTCPConnectionOpen(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer func() {
+		_ = listener.Close()
+	}()
+
+	accepted := make(chan int, 4)
+	go func() {
+		for i := 1; ; i++ {
+			conn, acceptErr := listener.Accept()
+			if acceptErr != nil {
+				return
+			}
+			accepted <- i
+			go func() {
+				buffer := make([]byte, 16)
+				for {
+					if _, readErr := conn.Read(buffer); readErr != nil {
+						return
+					}
+					_, _ = conn.Write([]byte("1,50 kg\r\n"))
+				}
+			}()
+		}
+	}()
+
+	port := listener.Addr().(*net.TCPAddr).Port
+	conn, err := OpenScale(context.Background(), ScaleConfig{Transport: "tcp", TCPHost: "127.0.0.1", TCPPort: port, RequestCommand: "W\r\n", ReadTimeoutMs: 1000})
+	if err != nil {
+		t.Fatalf("OpenScale: %v", err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	for i := 0; i < 3; i++ {
+		weight, raw, readErr := conn.Read(context.Background())
+		if readErr != nil || weight != 1.5 || raw != "1,50 kg" {
+			t.Fatalf("read %d: %v %q %v", i, weight, raw, readErr)
+		}
+	}
+	if len(accepted) != 1 {
+		t.Fatalf("expected one connection for all reads, got %d", len(accepted))
+	}
+}
+
+func TestOpenScaleRejectsDibalServer(t *testing.T) {
+	if _, err := OpenScale(context.Background(), ScaleConfig{Transport: "dibal_tcp_server"}); err == nil {
+		t.Fatalf("dibal server scales are read through DibalManager")
+	}
+}
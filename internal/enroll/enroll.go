@@ -0,0 +1,168 @@
+// Package enroll pairs a new agent with Bizanti using a short code approved by
+// an administrator, so installers never copy the agent token by hand.
+//
+// The flow follows the OAuth device authorization grant: the agent asks the
+// server for a pairing code, shows it, and polls until an administrator
+// approves (or denies) it in Bizanti. Approval returns the agent token and the
+// connection settings.
+package enroll
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/NowakAdmin/BizantiAgent/internal/api"
+)
+
+const (
+	startPath = "/api/bizanticore/agent/enroll"
+	pollPath  = "/api/bizanticore/agent/enroll/poll"
+
+	defaultInterval = 5
+	defaultExpiry   = 10 * time.Minute
+	// requestTimeout bounds one enrollment request.
+	requestTimeout = 30 * time.Second
+)
+
+// pollUnit is the unit of the server-supplied polling interval. Tests shrink it.
+var pollUnit = time.Second
+
+var (
+	// ErrDenied means an administrator rejected the pairing request.
+	ErrDenied = errors.New("parowanie odrzucone przez administratora")
+	// ErrExpired means the pairing code was not approved in time.
+	ErrExpired = errors.New("kod parowania wygasł")
+)
+
+// DeviceInfo identifies the station in the Bizanti approval screen.
+type DeviceInfo struct {
+	Hostname string `json:"hostname"`
+	Version  string `json:"version"`
+	OS       string `json:"os"`
+}
+
+// Pairing is an enrollment request waiting for approval.
+type Pairing struct {
+	DeviceCode      string `json:"device_code"`
+	UserCode        string `json:"user_code"`
+	VerificationURL string `json:"verification_url"`
+	ExpiresIn       int    `json:"expires_in"`
+	Interval        int    `json:"interval"`
+
+	expiresAt time.Time
+}
+
+// Credentials are issued once the pairing is approved. Empty URLs mean the
+// server URL used for enrollment stays in effect.
+type Credentials struct {
+	AgentToken   string `json:"agent_token"`
+	TenantID     string `json:"tenant_id"`
+	ServerURL    string `json:"server_url"`
+	WebSocketURL string `json:"websocket_url"`
+}
+
+// Client talks to the enrollment endpoints of one Bizanti server.
+type Client struct {
+	ServerURL string
+	HTTP      *http.Client
+}
+
+// Start requests a new pairing code.
+func (c *Client) Start(ctx context.Context, info DeviceInfo) (*Pairing, error) {
+	var pairing Pairing
+	if err := c.post(ctx, startPath, info, &pairing); err != nil {
+		return nil, err
+	}
+	if pairing.DeviceCode == "" || pairing.UserCode == "" {
+		return nil, errors.New("enroll: serwer nie zwrócił kodu parowania")
+	}
+
+	expiresIn := time.Duration(pairing.ExpiresIn) * time.Second
+	if expiresIn <= 0 {
+		expiresIn = defaultExpiry
+	}
+	pairing.expiresAt = time.Now().Add(expiresIn)
+
+	return &pairing, nil
+}
+
+// pollResponse is the answer to a poll. Status is "pending", "slow_down",
+// "approved", "denied" or "expired".
+type pollResponse struct {
+	Status string `json:"status"`
+	Credentials
+}
+
+// Wait polls until the pairing is approved, denied or expires, or ctx ends.
+func (c *Client) Wait(ctx context.Context, pairing *Pairing) (*Credentials, error) {
+	interval := time.Duration(pairing.Interval) * pollUnit
+	if interval <= 0 {
+		interval = defaultInterval * pollUnit
+	}
+
+	expiresAt := pairing.expiresAt
+	if expiresAt.IsZero() {
+		expiresAt = time.Now().Add(defaultExpiry)
+	}
+
+	for {
+		if time.Now().After(expiresAt) {
+			return nil, ErrExpired
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(interval):
+		}
+
+		var response pollResponse
+		err := c.post(ctx, pollPath, map[string]string{"device_code": pairing.DeviceCode}, &response)
+		var networkErr *api.NetworkError
+		var rateErr *api.RateLimitError
+		var requestErr *api.RequestError
+		switch {
+		case err == nil:
+		case errors.As(err, &networkErr):
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			// A dropped request is not fatal; the code stays valid.
+			continue
+		case errors.As(err, &rateErr):
+			response.Status = "slow_down"
+			interval = max(interval, rateErr.RetryAfter)
+		case errors.As(err, &requestErr):
+			// Denied and expired pairings may come back as 4xx with a status.
+			if json.Unmarshal([]byte(requestErr.Body), &response) != nil || response.Status == "" {
+				return nil, err
+			}
+		default:
+			return nil, err
+		}
+
+		switch response.Status {
+		case "approved":
+			if strings.TrimSpace(response.AgentToken) == "" {
+				return nil, errors.New("enroll: zatwierdzono bez tokena agenta")
+			}
+			credentials := response.Credentials
+			return &credentials, nil
+		case "denied":
+			return nil, ErrDenied
+		case "expired":
+			return nil, ErrExpired
+		case "slow_down":
+			interval += defaultInterval * pollUnit
+		}
+	}
+}
+
+func (c *Client) post(ctx context.Context, path string, body any, out any) error {
+	client := &api.Client{HTTP: c.HTTP, ServerURL: c.ServerURL}
+	return client.Do(ctx, api.Call{Op: "enroll", Method: http.MethodPost, Path: path, Body: body, Timeout: requestTimeout}, out)
+}
@@ -0,0 +1,158 @@
+package enroll
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeServer approves (or denies) the pairing after a number of pending polls.
+type fakeServer struct {
+	mu        sync.Mutex
+	polls     int
+	pendingN  int
+	final     string
+	gotDevice DeviceInfo
+}
+
+func (f *fakeServer) handler(t *testing.T) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(startPath, func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		_ = json.NewDecoder(r.Body).Decode(&f.gotDevice)
+		f.mu.Unlock()
+		_, _ = w.Write([]byte(`{"device_code": "dev-1", "user_code": "WXYZ-1234", "verification_url": "https://bizanti.pl/agents/pair", "expires_in": 60, "interval": 1}`))
+	})
+	mux.HandleFunc(pollPath, func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		if body["device_code"] != "dev-1" {
+			t.Errorf("unexpected device code %q", body["device_code"])
+		}
+
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.polls++
+		if f.polls <= f.pendingN {
+			_, _ = w.Write([]byte(`{"status": "pending"}`))
+			return
+		}
+		if f.final == "approved" {
+			_, _ = w.Write([]byte(`{"status": "approved", "agent_token": "tok-abc", "tenant_id": "tenant_7", "websocket_url": "wss://bizanti.pl/agent/ws"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"status": "` + f.final + `"}`))
+	})
+	return mux
+}
+
+func shrinkPollUnit(t *testing.T) {
+	t.Helper()
+	previous := pollUnit
+	pollUnit = 10 * time.Millisecond
+	t.Cleanup(func() { pollUnit = previous })
+}
+
+func TestEnrollApproved(t *testing.T) {
+	shrinkPollUnit(t)
+	fake := &fakeServer{pendingN: 2, final: "approved"}
+	server := httptest.NewServer(fake.handler(t))
+	defer server.Close()
+
+	client := &Client{ServerURL: server.URL + "/"}
+	pairing, err := client.Start(context.Background(), DeviceInfo{Hostname: "WAGA-01", Version: "0.1.19", OS: "windows"})
+	if err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	if pairing.UserCode != "WXYZ-1234" || fake.gotDevice.Hostname != "WAGA-01" {
+		t.Fatalf("unexpected pairing %+v / device %+v", pairing, fake.gotDevice)
+	}
+
+	credentials, err := client.Wait(context.Background(), pairing)
+	if err != nil {
+		t.Fatalf("wait: %v", err)
+	}
+	if credentials.AgentToken != "tok-abc" || credentials.TenantID != "tenant_7" || credentials.WebSocketURL != "wss://bizanti.pl/agent/ws" {
+		t.Fatalf("unexpected credentials: %+v", credentials)
+	}
+	if fake.polls != 3 {
+		t.Fatalf("expected 3 polls, got %d", fake.polls)
+	}
+}
+
+func TestEnrollDeniedAndExpired(t *testing.T) {
+	shrinkPollUnit(t)
+
+	for final, want := range map[string]error{"denied": ErrDenied, "expired": ErrExpired} {
+		server := httptest.NewServer((&fakeServer{final: final}).handler(t))
+		client := &Client{ServerURL: server.URL}
+
+		pairing, err := client.Start(context.Background(), DeviceInfo{})
+		if err != nil {
+			t.Fatalf("start: %v", err)
+		}
+		if _, err = client.Wait(context.Background(), pairing); !errors.Is(err, want) {
+			t.Fatalf("%s: expected %v, got %v", final, want, err)
+		}
+		server.Close()
+	}
+}
+
+func TestEnrollStopsOnContext(t *testing.T) {
+	shrinkPollUnit(t)
+	server := httptest.NewServer((&fakeServer{pendingN: 1 << 30}).handler(t))
+	defer server.Close()
+
+	client := &Client{ServerURL: server.URL}
+	pairing, err := client.Start(context.Background(), DeviceInfo{})
+	if err != nil {
+		t.Fatalf("start: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err = client.Wait(ctx, pairing); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context error, got %v", err)
+	}
+}
+
+func TestEnrollSlowsDownOnRateLimit(t *testing.T) {
+	shrinkPollUnit(t)
+	var mu sync.Mutex
+	var polls []time.Time
+	mux := http.NewServeMux()
+	mux.HandleFunc(pollPath, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		polls = append(polls, time.Now())
+		switch len(polls) {
+		case 1:
+			w.WriteHeader(http.StatusTooManyRequests)
+		case 2:
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"status": "pending"}`))
+		default:
+			_, _ = w.Write([]byte(`{"status": "approved", "agent_token": "tok-abc"}`))
+		}
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	client := &Client{ServerURL: server.URL}
+	credentials, err := client.Wait(context.Background(), &Pairing{DeviceCode: "dev-1", Interval: 1})
+	if err != nil {
+		t.Fatalf("wait: %v", err)
+	}
+	if credentials.AgentToken != "tok-abc" || len(polls) != 3 {
+		t.Fatalf("unexpected credentials %+v after %d polls", credentials, len(polls))
+	}
+	// slow_down adds defaultInterval units to the 1-unit interval.
+	if gap := polls[2].Sub(polls[1]); gap < (1+defaultInterval)*pollUnit {
+		t.Fatalf("expected slower polling after 429, got %v", gap)
+	}
+}
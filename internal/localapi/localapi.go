@@ -0,0 +1,214 @@
+// Package localapi is the optional REST API for on-premise applications such
+// as POS software. It listens on 127.0.0.1 only, requires a bearer token and
+// runs commands through the agent's own pipeline, so it keeps working while
+// the Bizanti server is unreachable.
+package localapi
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/NowakAdmin/BizantiAgent/internal/config"
+)
+
+// Commands are the commands exposed as POST /v1/<command>.
+var Commands = []string{"read_weight", "print_label", "weigh_and_print"}
+
+const weightStreamPath = "/v1/weight/stream"
+
+const (
+	// maxBody limits a command payload.
+	maxBody = 1 << 20
+	// shutdownTimeout is how long running requests may finish on stop.
+	shutdownTimeout = 5 * time.Second
+)
+
+// Backend runs commands and reports status on behalf of the local API.
+type Backend interface {
+	// RunCommand executes a command with the same validation, policy and
+	// device locking as commands from the server.
+	RunCommand(ctx context.Context, command string, payload json.RawMessage) (map[string]any, error)
+	// Status describes the agent for GET /v1/status.
+	Status() map[string]any
+}
+
+// Error is a failed command with the HTTP status to answer with. Errors of
+// other types are answered with 500.
+type Error struct {
+	StatusCode int
+	Err        error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Server is the local REST API.
+type Server struct {
+	port    int
+	token   string
+	backend Backend
+	logger  *log.Logger
+	mux     *http.ServeMux
+
+	// weights is set when the weight stream is enabled and the backend
+	// provides it.
+	weights        WeightSource
+	allowedOrigins []string
+}
+
+// New prepares the server. It refuses to run without a token.
+func New(cfg config.LocalAPIConfig, backend Backend, logger *log.Logger) (*Server, error) {
+	token := strings.TrimSpace(cfg.Token)
+	if token == "" {
+		return nil, errors.New("local_api.token jest wymagany")
+	}
+	if cfg.Port <= 0 || cfg.Port > 65535 {
+		return nil, fmt.Errorf("local_api.port: nieprawidłowy port %d", cfg.Port)
+	}
+
+	s := &Server{
+		port:    cfg.Port,
+		token:   token,
+		backend: backend,
+		logger:  logger,
+		mux:     http.NewServeMux(),
+	}
+	s.mux.HandleFunc("GET /v1/status", s.handleStatus)
+	s.mux.HandleFunc("POST /v1/{command}", s.handleCommand)
+
+	if cfg.WeightStream.Enabled {
+		if source, ok := backend.(WeightSource); ok {
+			s.weights = source
+			s.allowedOrigins = cfg.WeightStream.AllowedOrigins
+			s.mux.HandleFunc("GET "+weightStreamPath, s.handleWeightStream)
+		}
+	}
+
+	return s, nil
+}
+
+// Handler returns the authenticated API handler.
+func (s *Server) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.authorized(r) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="bizanti-agent"`)
+			writeJSON(w, http.StatusUnauthorized, map[string]any{"error": "brak lub błędny token"})
+			return
+		}
+		s.mux.ServeHTTP(w, r)
+	})
+}
+
+// Run listens on 127.0.0.1 until ctx ends.
+func (s *Server) Run(ctx context.Context) error {
+	listener, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(s.port)))
+	if err != nil {
+		return err
+	}
+
+	server := &http.Server{
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+		BaseContext:       func(net.Listener) context.Context { return ctx },
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+
+	s.logger.Printf("Lokalne API: nasłuch na http://%s", listener.Addr())
+	if err = server.Serve(listener); errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+
+	return err
+}
+
+// authorized checks the bearer token. Browsers cannot set headers on a
+// WebSocket, so the weight stream also accepts it as the token query
+// parameter.
+func (s *Server) authorized(r *http.Request) bool {
+	header := r.Header.Get("Authorization")
+	token, ok := strings.CutPrefix(header, "Bearer ")
+	if !ok && r.URL.Path == weightStreamPath {
+		token, ok = r.URL.Query().Get("token"), true
+	}
+	if !ok {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(strings.TrimSpace(token)), []byte(s.token)) == 1
+}
+
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.backend.Status())
+}
+
+// handleCommand runs one command. The request body is the command payload,
+// the same JSON the server sends in "payload". The response mirrors a
+// command_result: status plus data or error.
+func (s *Server) handleCommand(w http.ResponseWriter, r *http.Request) {
+	command := r.PathValue("command")
+	if !slices.Contains(Commands, command) {
+		writeJSON(w, http.StatusNotFound, map[string]any{"error": "nieznana komenda: " + command})
+		return
+	}
+
+	payload, err := readPayload(w, r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"status": "failed", "error": err.Error()})
+		return
+	}
+
+	result, err := s.backend.RunCommand(r.Context(), command, payload)
+	if err != nil {
+		status := http.StatusInternalServerError
+		var apiErr *Error
+		if errors.As(err, &apiErr) {
+			status = apiErr.StatusCode
+		}
+		s.logger.Printf("Lokalne API: %s: %v", command, err)
+		writeJSON(w, status, map[string]any{"status": "failed", "error": err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{"status": "completed", "data": result})
+}
+
+func readPayload(w http.ResponseWriter, r *http.Request) (json.RawMessage, error) {
+	var payload json.RawMessage
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBody))
+	if err := decoder.Decode(&payload); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("nieprawidłowy JSON: %w", err)
+	}
+
+	return payload, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,110 @@
+package localapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/NowakAdmin/BizantiAgent/internal/config"
+)
+
+type fakeBackend struct {
+	command string
+	payload json.RawMessage
+	err     error
+}
+
+func (f *fakeBackend) RunCommand(ctx context.Context, command string, payload json.RawMessage) (map[string]any, error) {
+	f.command, f.payload = command, payload
+	if f.err != nil {
+		return nil, f.err
+	}
+	return map[string]any{"weight_kg": 1.25}, nil
+}
+
+func (f *fakeBackend) Status() map[string]any {
+	return map[string]any{"status": "Offline"}
+}
+
+func newTestServer(t *testing.T, backend Backend) *httptest.Server {
+	t.Helper()
+
+	s, err := New(config.LocalAPIConfig{Enabled: true, Port: 8765, Token: "sekret"}, backend, log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	server := httptest.NewServer(s.Handler())
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func call(t *testing.T, server *httptest.Server, method, path, token, body string) (int, map[string]any) {
+	t.Helper()
+
+	request, _ := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+	response, err := server.Client().Do(request)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer response.Body.Close()
+
+	var decoded map[string]any
+	_ = json.NewDecoder(response.Body).Decode(&decoded)
+
+	return response.StatusCode, decoded
+}
+
+func TestNewRequiresToken(t *testing.T) {
+	if _, err := New(config.LocalAPIConfig{Enabled: true, Port: 8765}, &fakeBackend{}, log.New(io.Discard, "", 0)); err == nil {
+		t.Fatalf("server without token must be refused")
+	}
+}
+
+func TestRequestsNeedBearerToken(t *testing.T) {
+	server := newTestServer(t, &fakeBackend{})
+
+	for _, token := range []string{"", "zly"} {
+		if status, _ := call(t, server, http.MethodGet, "/v1/status", token, ""); status != http.StatusUnauthorized {
+			t.Fatalf("token %q: status %d", token, status)
+		}
+	}
+	if status, body := call(t, server, http.MethodGet, "/v1/status", "sekret", ""); status != http.StatusOK || body["status"] != "Offline" {
+		t.Fatalf("status: %d %v", status, body)
+	}
+}
+
+func TestCommandEndpoints(t *testing.T) {
+	backend := &fakeBackend{}
+	server := newTestServer(t, backend)
+
+	status, body := call(t, server, http.MethodPost, "/v1/read_weight", "sekret", `{"scale": {"transport": "serial"}}`)
+	if status != http.StatusOK || body["status"] != "completed" || backend.command != "read_weight" {
+		t.Fatalf("read_weight: %d %v", status, body)
+	}
+	if !strings.Contains(string(backend.payload), `"serial"`) {
+		t.Fatalf("payload not passed through: %s", backend.payload)
+	}
+
+	if status, _ = call(t, server, http.MethodPost, "/v1/program_dibal_plu", "sekret", `{}`); status != http.StatusNotFound {
+		t.Fatalf("commands outside the list must not be exposed, got %d", status)
+	}
+	if status, _ = call(t, server, http.MethodPost, "/v1/print_label", "sekret", `{`); status != http.StatusBadRequest {
+		t.Fatalf("malformed JSON: got %d", status)
+	}
+
+	backend.err = &Error{StatusCode: http.StatusForbidden, Err: errors.New("polityka")}
+	status, body = call(t, server, http.MethodPost, "/v1/print_label", "sekret", `{}`)
+	if status != http.StatusForbidden || body["status"] != "failed" || body["error"] != "polityka" {
+		t.Fatalf("mapped error: %d %v", status, body)
+	}
+}
@@ -0,0 +1,108 @@
+package localapi
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	streamWriteTimeout = 5 * time.Second
+	streamPingInterval = 30 * time.Second
+)
+
+// WeightUpdate is one message of the live weight stream. Error is set instead
+// of Weight while the scale cannot be read.
+type WeightUpdate struct {
+	Weight      float64 `json:"weight"`
+	RawResponse string  `json:"raw_response,omitempty"`
+	Timestamp   string  `json:"timestamp"`
+	Error       string  `json:"error,omitempty"`
+}
+
+// WeightSource feeds GET /v1/weight/stream. Backends implement it when the
+// weight stream is enabled.
+type WeightSource interface {
+	// SubscribeWeight returns a channel of updates, starting with the last
+	// known reading, and a func that ends the subscription.
+	SubscribeWeight() (<-chan WeightUpdate, func())
+}
+
+// originAllowed reports whether a browser origin is on the allowlist.
+// Requests without an Origin header do not come from a browser and are left
+// to the token check.
+func originAllowed(allowed []string, origin string) bool {
+	if origin == "" {
+		return true
+	}
+
+	parsed, err := url.Parse(origin)
+	if err != nil || parsed.Host == "" {
+		return false
+	}
+	normalized := strings.ToLower(parsed.Scheme + "://" + parsed.Host)
+
+	for _, entry := range allowed {
+		if strings.ToLower(strings.TrimRight(strings.TrimSpace(entry), "/")) == normalized {
+			return true
+		}
+	}
+
+	return false
+}
+
+// handleWeightStream upgrades to a WebSocket and sends a WeightUpdate as JSON
+// for every reading. Messages from the client are ignored.
+func (s *Server) handleWeightStream(w http.ResponseWriter, r *http.Request) {
+	upgrader := websocket.Upgrader{
+		CheckOrigin: func(r *http.Request) bool {
+			return originAllowed(s.allowedOrigins, r.Header.Get("Origin"))
+		},
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	updates, unsubscribe := s.weights.SubscribeWeight()
+	defer unsubscribe()
+
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, readErr := conn.ReadMessage(); readErr != nil {
+				return
+			}
+		}
+	}()
+
+	ping := time.NewTicker(streamPingInterval)
+	defer ping.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-closed:
+			return
+		case <-ping.C:
+			if err = conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(streamWriteTimeout)); err != nil {
+				return
+			}
+		case update, ok := <-updates:
+			if !ok {
+				return
+			}
+			_ = conn.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+			if err = conn.WriteJSON(update); err != nil {
+				return
+			}
+		}
+	}
+}
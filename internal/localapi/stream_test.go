@@ -0,0 +1,84 @@
+package localapi
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/NowakAdmin/BizantiAgent/internal/config"
+)
+
+type streamBackend struct {
+	fakeBackend
+	updates chan WeightUpdate
+}
+
+func (b *streamBackend) SubscribeWeight() (<-chan WeightUpdate, func()) {
+	return b.updates, func() {}
+}
+
+func TestOriginAllowed(t *testing.T) {
+	allowed := []string{"https://pakowanie.firma.local", "http://localhost:3000/"}
+
+	cases := map[string]bool{
+		"":                              true,
+		"https://pakowanie.firma.local": true,
+		"HTTPS://Pakowanie.Firma.Local": true,
+		"http://localhost:3000":         true,
+		"http://pakowanie.firma.local":  false,
+		"https://evil.example":          false,
+		"null":                          false,
+	}
+	for origin, want := range cases {
+		if got := originAllowed(allowed, origin); got != want {
+			t.Fatalf("originAllowed(%q) = %t", origin, got)
+		}
+	}
+}
+
+func TestWeightStreamOverWebSocket(t *testing.T) {
+	backend := &streamBackend{updates: make(chan WeightUpdate, 1)}
+	s, err := New(config.LocalAPIConfig{
+		Port:  8765,
+		Token: "sekret",
+		WeightStream: config.WeightStreamConfig{
+			Enabled:        true,
+			AllowedOrigins: []string{"https://pakowanie.firma.local"},
+		},
+	}, backend, log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	server := httptest.NewServer(s.Handler())
+	defer server.Close()
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + weightStreamPath
+
+	header := http.Header{"Origin": []string{"https://evil.example"}}
+	if _, response, dialErr := websocket.DefaultDialer.Dial(url+"?token=sekret", header); dialErr == nil || response.StatusCode != http.StatusForbidden {
+		t.Fatalf("foreign origin must be refused, got %v", dialErr)
+	}
+
+	header.Set("Origin", "https://pakowanie.firma.local")
+	if _, response, dialErr := websocket.DefaultDialer.Dial(url, header); dialErr == nil || response.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("stream without token must be refused, got %v", dialErr)
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial(url+"?token=sekret", header)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	backend.updates <- WeightUpdate{Weight: 3.25, Timestamp: "2026-01-01T00:00:00Z"}
+
+	var update WeightUpdate
+	if err = conn.ReadJSON(&update); err != nil || update.Weight != 3.25 {
+		t.Fatalf("ReadJSON = %+v, %v", update, err)
+	}
+}
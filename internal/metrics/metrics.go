@@ -0,0 +1,372 @@
+// Package metrics keeps process-wide counters, gauges and histograms and
+// serves them in the Prometheus text exposition format. Packages declare
+// their metrics as package variables; every metric is registered on
+// creation and appears on /metrics from then on.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are histogram upper bounds in seconds, sized for device
+// jobs that take from a fraction of a second to a couple of minutes.
+var DefaultBuckets = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120}
+
+type metric interface {
+	write(w io.Writer) error
+}
+
+// registry holds metrics by name. The exported constructors register on
+// defaultRegistry; tests use their own.
+type registry struct {
+	mu      sync.Mutex
+	metrics map[string]metric
+}
+
+var defaultRegistry = newRegistry()
+
+func newRegistry() *registry {
+	return &registry{metrics: map[string]metric{}}
+}
+
+func (r *registry) register(name string, m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.metrics[name]; exists {
+		panic(fmt.Sprintf("metrics: %q zarejestrowana dwukrotnie", name))
+	}
+	r.metrics[name] = m
+}
+
+// family holds the label names and per-label-set values shared by all
+// metric types.
+type family[V any] struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+
+	mu     sync.Mutex
+	series map[string]*series[V]
+}
+
+type series[V any] struct {
+	labelValues []string
+	value       V
+}
+
+func newFamily[V any](name, help, kind string, labels []string) *family[V] {
+	return &family[V]{name: name, help: help, kind: kind, labels: labels, series: map[string]*series[V]{}}
+}
+
+// with returns the series for labelValues, creating it, with f.mu held.
+func (f *family[V]) with(labelValues []string, init func() V) *series[V] {
+	if len(labelValues) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s oczekuje %d etykiet, podano %d", f.name, len(f.labels), len(labelValues)))
+	}
+
+	key := strings.Join(labelValues, "\xff")
+	s, ok := f.series[key]
+	if !ok {
+		s = &series[V]{labelValues: append([]string(nil), labelValues...), value: init()}
+		f.series[key] = s
+	}
+
+	return s
+}
+
+// value returns the value for labelValues, or the zero value if the series
+// does not exist yet.
+func (f *family[V]) value(labelValues []string) V {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var zero V
+	if s, ok := f.series[strings.Join(labelValues, "\xff")]; ok {
+		return s.value
+	}
+
+	return zero
+}
+
+// sorted returns the series ordered by label values, with f.mu held.
+func (f *family[V]) sorted() []*series[V] {
+	list := make([]*series[V], 0, len(f.series))
+	for _, s := range f.series {
+		list = append(list, s)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return strings.Join(list[i].labelValues, "\xff") < strings.Join(list[j].labelValues, "\xff")
+	})
+
+	return list
+}
+
+func (f *family[V]) header(w io.Writer) error {
+	_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", f.name, escapeHelp(f.help), f.name, f.kind)
+	return err
+}
+
+// Counter is a monotonically increasing value, optionally split by labels.
+type Counter struct {
+	f *family[float64]
+}
+
+// NewCounter creates and registers a counter. A counter without labels is
+// exported as 0 before the first increment.
+func NewCounter(name, help string, labels ...string) *Counter {
+	c := newCounter(name, help, labels...)
+	defaultRegistry.register(name, c)
+
+	return c
+}
+
+func newCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{f: newFamily[float64](name, help, "counter", labels)}
+	if len(labels) == 0 {
+		c.f.with(nil, func() float64 { return 0 })
+	}
+
+	return c
+}
+
+// Inc adds one.
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds v, which must not be negative.
+func (c *Counter) Add(v float64, labelValues ...string) {
+	if v < 0 {
+		panic("metrics: licznik nie może maleć")
+	}
+
+	c.f.mu.Lock()
+	defer c.f.mu.Unlock()
+
+	c.f.with(labelValues, func() float64 { return 0 }).value += v
+}
+
+// Value returns the current value for labelValues.
+func (c *Counter) Value(labelValues ...string) float64 {
+	return c.f.value(labelValues)
+}
+
+func (c *Counter) write(w io.Writer) error {
+	return writeSimple(w, c.f)
+}
+
+// Gauge is a value that can go up and down.
+type Gauge struct {
+	f *family[float64]
+}
+
+// NewGauge creates and registers a gauge.
+func NewGauge(name, help string, labels ...string) *Gauge {
+	g := newGauge(name, help, labels...)
+	defaultRegistry.register(name, g)
+
+	return g
+}
+
+func newGauge(name, help string, labels ...string) *Gauge {
+	g := &Gauge{f: newFamily[float64](name, help, "gauge", labels)}
+	if len(labels) == 0 {
+		g.f.with(nil, func() float64 { return 0 })
+	}
+
+	return g
+}
+
+// Set sets the value for labelValues.
+func (g *Gauge) Set(v float64, labelValues ...string) {
+	g.f.mu.Lock()
+	defer g.f.mu.Unlock()
+
+	g.f.with(labelValues, func() float64 { return 0 }).value = v
+}
+
+// Value returns the current value for labelValues.
+func (g *Gauge) Value(labelValues ...string) float64 {
+	return g.f.value(labelValues)
+}
+
+func (g *Gauge) write(w io.Writer) error {
+	return writeSimple(w, g.f)
+}
+
+func writeSimple(w io.Writer, f *family[float64]) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.header(w); err != nil {
+		return err
+	}
+	for _, s := range f.sorted() {
+		if _, err := fmt.Fprintf(w, "%s%s %s\n", f.name, labelPairs(f.labels, s.labelValues, "", ""), formatFloat(s.value)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Histogram counts observations into cumulative buckets.
+type Histogram struct {
+	f       *family[*histogramValue]
+	buckets []float64
+}
+
+type histogramValue struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// NewHistogram creates and registers a histogram with the given upper
+// bounds, DefaultBuckets when nil.
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h := newHistogram(name, help, buckets, labels...)
+	defaultRegistry.register(name, h)
+
+	return h
+}
+
+func newHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+
+	return &Histogram{f: newFamily[*histogramValue](name, help, "histogram", labels), buckets: buckets}
+}
+
+// Observe records one value.
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	h.f.mu.Lock()
+	defer h.f.mu.Unlock()
+
+	value := h.f.with(labelValues, func() *histogramValue {
+		return &histogramValue{counts: make([]uint64, len(h.buckets))}
+	}).value
+
+	for i, bound := range h.buckets {
+		if v <= bound {
+			value.counts[i]++
+		}
+	}
+	value.count++
+	value.sum += v
+}
+
+// Count returns how many values were observed for labelValues.
+func (h *Histogram) Count(labelValues ...string) uint64 {
+	if value := h.f.value(labelValues); value != nil {
+		return value.count
+	}
+
+	return 0
+}
+
+func (h *Histogram) write(w io.Writer) error {
+	h.f.mu.Lock()
+	defer h.f.mu.Unlock()
+
+	if err := h.f.header(w); err != nil {
+		return err
+	}
+	for _, s := range h.f.sorted() {
+		for i, bound := range h.buckets {
+			if _, err := fmt.Fprintf(w, "%s_bucket%s %d\n", h.f.name, labelPairs(h.f.labels, s.labelValues, "le", formatFloat(bound)), s.value.counts[i]); err != nil {
+				return err
+			}
+		}
+		if _, err := fmt.Fprintf(w, "%s_bucket%s %d\n", h.f.name, labelPairs(h.f.labels, s.labelValues, "le", "+Inf"), s.value.count); err != nil {
+			return err
+		}
+		labels := labelPairs(h.f.labels, s.labelValues, "", "")
+		if _, err := fmt.Fprintf(w, "%s_sum%s %s\n%s_count%s %d\n", h.f.name, labels, formatFloat(s.value.sum), h.f.name, labels, s.value.count); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// WriteText writes every registered metric, sorted by name, in the
+// Prometheus text format.
+func WriteText(w io.Writer) error {
+	return defaultRegistry.writeText(w)
+}
+
+func (r *registry) writeText(w io.Writer) error {
+	r.mu.Lock()
+	names := make([]string, 0, len(r.metrics))
+	for name := range r.metrics {
+		names = append(names, name)
+	}
+	metrics := make([]metric, len(names))
+	sort.Strings(names)
+	for i, name := range names {
+		metrics[i] = r.metrics[name]
+	}
+	r.mu.Unlock()
+
+	for _, m := range metrics {
+		if err := m.write(w); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// labelPairs renders {a="x",b="y"}, with an extra pair appended when
+// extraName is set, or "" without labels.
+func labelPairs(names, values []string, extraName, extraValue string) string {
+	if len(names) == 0 && extraName == "" {
+		return ""
+	}
+
+	pairs := make([]string, 0, len(names)+1)
+	for i, name := range names {
+		pairs = append(pairs, name+`="`+escapeLabel(values[i])+`"`)
+	}
+	if extraName != "" {
+		pairs = append(pairs, extraName+`="`+extraValue+`"`)
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
+
+func escapeHelp(help string) string {
+	return helpEscaper.Replace(help)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
@@ -0,0 +1,93 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWriteTextCountersAndGauges(t *testing.T) {
+	r := newRegistry()
+	counter := newCounter("test_jobs_total", "Jobs.", "command", "status")
+	r.register("test_jobs_total", counter)
+	counter.Inc("read_weight", "completed")
+	counter.Add(2, "read_weight", "completed")
+	counter.Inc("print_label", `bad "quoted"`+"\n")
+	r.register("test_plain_total", newCounter("test_plain_total", "Plain."))
+
+	var out strings.Builder
+	if err := r.writeText(&out); err != nil {
+		t.Fatalf("WriteText: %v", err)
+	}
+	text := out.String()
+
+	for _, want := range []string{
+		"# HELP test_jobs_total Jobs.\n# TYPE test_jobs_total counter\n",
+		`test_jobs_total{command="print_label",status="bad \"quoted\"\n"} 1` + "\n",
+		`test_jobs_total{command="read_weight",status="completed"} 3` + "\n",
+		"test_plain_total 0\n",
+	} {
+		if !strings.Contains(text, want) {
+			t.Fatalf("missing %q in:\n%s", want, text)
+		}
+	}
+	if strings.Index(text, "test_jobs_total") > strings.Index(text, "test_plain_total") {
+		t.Fatalf("metrics not sorted by name:\n%s", text)
+	}
+}
+
+func TestHistogramBucketsAreCumulative(t *testing.T) {
+	histogram := newHistogram("test_duration_seconds", "Duration.", []float64{1, 0.5}, "command")
+	histogram.Observe(0.2, "x")
+	histogram.Observe(0.7, "x")
+	histogram.Observe(3, "x")
+
+	if got := histogram.Count("x"); got != 3 {
+		t.Fatalf("Count = %d", got)
+	}
+
+	var out strings.Builder
+	if err := histogram.write(&out); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	want := `# HELP test_duration_seconds Duration.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{command="x",le="0.5"} 1
+test_duration_seconds_bucket{command="x",le="1"} 2
+test_duration_seconds_bucket{command="x",le="+Inf"} 3
+test_duration_seconds_sum{command="x"} 3.9
+test_duration_seconds_count{command="x"} 3
+`
+	if out.String() != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", out.String(), want)
+	}
+}
+
+func TestDuplicateRegistrationPanics(t *testing.T) {
+	r := newRegistry()
+	r.register("test_duplicate", newGauge("test_duplicate", "Once."))
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("expected a panic")
+		}
+	}()
+	r.register("test_duplicate", newGauge("test_duplicate", "Twice."))
+}
+
+func TestHandlerServesTextFormat(t *testing.T) {
+	r := newRegistry()
+	gauge := newGauge("test_handler_gauge", "Gauge.")
+	r.register("test_handler_gauge", gauge)
+	gauge.Set(1.5)
+
+	recorder := httptest.NewRecorder()
+	handler(r).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if ct := recorder.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+	if !strings.Contains(recorder.Body.String(), "test_handler_gauge 1.5\n") {
+		t.Fatalf("body:\n%s", recorder.Body.String())
+	}
+}
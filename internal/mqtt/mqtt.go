@@ -0,0 +1,279 @@
+// Package mqtt connects the agent to a shop-floor MQTT broker. It publishes
+// weight readings, job results and device events, keeps a retained status
+// topic backed by a last will, and hands messages from the command topic to
+// the agent. It works independently of the Bizanti server connection.
+package mqtt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+
+	"github.com/NowakAdmin/BizantiAgent/internal/config"
+	"github.com/NowakAdmin/BizantiAgent/internal/transport"
+	"github.com/NowakAdmin/BizantiAgent/internal/version"
+)
+
+const (
+	// queueSize bounds messages waiting to be handed to the client.
+	queueSize = 256
+	// stopTimeout is how long the offline status may take on shutdown.
+	stopTimeout = 2 * time.Second
+	// maxReconnectInterval caps the delay between connection attempts.
+	maxReconnectInterval = time.Minute
+)
+
+// Handler runs a message from the command topic and returns the result to
+// publish on the results topic, or nil to publish nothing.
+type Handler func(ctx context.Context, payload []byte) any
+
+// message is a publication waiting in the queue.
+type message struct {
+	topic    string
+	retained bool
+	payload  []byte
+}
+
+// Client publishes to one broker. Publish calls never block; messages are
+// dropped when the queue is full.
+type Client struct {
+	broker       string
+	clientID     string
+	topics       config.MQTTTopics
+	qos          byte
+	retainWeight bool
+	handler      Handler
+	options      *paho.ClientOptions
+	logger       *log.Logger
+
+	queue chan message
+}
+
+// New validates the configuration. handler is only used when cfg.Commands is
+// set.
+func New(cfg config.MQTTConfig, handler Handler, logger *log.Logger) (*Client, error) {
+	broker := strings.TrimSpace(cfg.Broker)
+	if broker == "" {
+		return nil, errors.New("mqtt.broker jest wymagany")
+	}
+	parsed, err := url.Parse(broker)
+	if err != nil || parsed.Host == "" {
+		return nil, fmt.Errorf("mqtt.broker: nieprawidłowy adres %q", broker)
+	}
+
+	secure := false
+	switch strings.ToLower(parsed.Scheme) {
+	case "tcp", "mqtt", "ws":
+	case "ssl", "tls", "mqtts", "wss":
+		secure = true
+	default:
+		return nil, fmt.Errorf("mqtt.broker: nieobsługiwany schemat %q (tcp, ssl, ws, wss)", parsed.Scheme)
+	}
+
+	qos := 1
+	if cfg.QoS != nil {
+		qos = *cfg.QoS
+	}
+	if qos < 0 || qos > 2 {
+		return nil, fmt.Errorf("mqtt.qos: nieprawidłowa wartość %d (0, 1 lub 2)", qos)
+	}
+
+	clientID := strings.TrimSpace(cfg.ClientID)
+	if clientID == "" {
+		clientID = defaultClientID()
+	}
+
+	c := &Client{
+		broker:       broker,
+		clientID:     clientID,
+		topics:       Topics(cfg, clientID),
+		qos:          byte(qos),
+		retainWeight: cfg.RetainWeight,
+		logger:       logger,
+		queue:        make(chan message, queueSize),
+	}
+	if cfg.Commands {
+		c.handler = handler
+	}
+
+	options := paho.NewClientOptions().
+		AddBroker(broker).
+		SetClientID(clientID).
+		SetUsername(cfg.Username).
+		SetPassword(cfg.Password).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetMaxReconnectInterval(maxReconnectInterval).
+		SetBinaryWill(c.topics.Status, c.status("offline"), c.qos, true).
+		SetConnectionLostHandler(func(_ paho.Client, lostErr error) {
+			c.logger.Printf("MQTT: utracono połączenie z %s: %v", c.broker, lostErr)
+		})
+	if secure {
+		tlsConfig, tlsErr := transport.NewTLSConfig(cfg.TLS)
+		if tlsErr != nil {
+			return nil, fmt.Errorf("mqtt.%w", tlsErr)
+		}
+		options.SetTLSConfig(tlsConfig)
+	}
+	c.options = options
+
+	return c, nil
+}
+
+// Topics returns the configured topics with empty ones filled in under the
+// topic prefix.
+func Topics(cfg config.MQTTConfig, clientID string) config.MQTTTopics {
+	prefix := strings.TrimRight(strings.TrimSpace(cfg.TopicPrefix), "/")
+	if prefix == "" {
+		prefix = "bizanti/" + clientID
+	}
+
+	topics := cfg.Topics
+	for topic, suffix := range map[*string]string{
+		&topics.Status:   "/status",
+		&topics.Weight:   "/weight",
+		&topics.Results:  "/results",
+		&topics.Events:   "/events",
+		&topics.Commands: "/commands",
+	} {
+		if strings.TrimSpace(*topic) == "" {
+			*topic = prefix + suffix
+		}
+	}
+
+	return topics
+}
+
+func defaultClientID() string {
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "unknown"
+	}
+
+	return "bizanti-agent-" + strings.ToLower(hostname)
+}
+
+// status is the retained payload of the status topic.
+func (c *Client) status(state string) []byte {
+	payload, _ := json.Marshal(map[string]any{
+		"state":     state,
+		"client_id": c.clientID,
+		"version":   version.Version,
+		"timestamp": time.Now().UTC().Format(time.RFC3339),
+	})
+
+	return payload
+}
+
+// PublishWeight publishes a weight reading, retained if configured.
+func (c *Client) PublishWeight(reading any) {
+	c.enqueue(c.topics.Weight, c.retainWeight, reading)
+}
+
+// PublishResult publishes a finished job.
+func (c *Client) PublishResult(result any) {
+	c.enqueue(c.topics.Results, false, result)
+}
+
+// PublishEvent publishes a device event.
+func (c *Client) PublishEvent(event any) {
+	c.enqueue(c.topics.Events, false, event)
+}
+
+func (c *Client) enqueue(topic string, retained bool, value any) {
+	payload, err := json.Marshal(value)
+	if err != nil {
+		c.logger.Printf("MQTT: nie można zakodować wiadomości dla %s: %v", topic, err)
+		return
+	}
+
+	select {
+	case c.queue <- message{topic: topic, retained: retained, payload: payload}:
+	default:
+		c.logger.Printf("MQTT: kolejka pełna — pominięto wiadomość dla %s", topic)
+	}
+}
+
+// Run connects, retrying in the background, and publishes queued messages
+// until ctx ends. On shutdown it sets the status topic to "offline".
+func (c *Client) Run(ctx context.Context) error {
+	c.options.SetOnConnectHandler(func(client paho.Client) {
+		c.onConnect(ctx, client)
+	})
+	client := paho.NewClient(c.options)
+	client.Connect()
+	c.logger.Printf("MQTT: łączenie z %s jako %s", c.broker, c.clientID)
+
+	for {
+		select {
+		case <-ctx.Done():
+			c.stop(client)
+			return nil
+		case msg := <-c.queue:
+			c.send(client, msg)
+		}
+	}
+}
+
+// onConnect runs after every (re)connection: it marks the agent online and
+// renews the command subscription.
+func (c *Client) onConnect(ctx context.Context, client paho.Client) {
+	c.logger.Printf("MQTT: połączono z %s", c.broker)
+	client.Publish(c.topics.Status, c.qos, true, c.status("online"))
+
+	if c.handler == nil {
+		return
+	}
+
+	client.Subscribe(c.topics.Commands, c.qos, func(_ paho.Client, msg paho.Message) {
+		// Commands wait for devices, so they must not block the client's
+		// message router.
+		go c.handle(ctx, msg.Payload())
+	})
+	c.logger.Printf("MQTT: oczekuję komend na %s", c.topics.Commands)
+}
+
+func (c *Client) handle(ctx context.Context, payload []byte) {
+	if result := c.handler(ctx, payload); result != nil {
+		c.PublishResult(result)
+	}
+}
+
+// send hands a message to the client. Weight readings and QoS 0 messages are
+// dropped while disconnected; QoS 1 and 2 messages are kept in memory and
+// sent after reconnecting.
+func (c *Client) send(client paho.Client, msg message) {
+	if !client.IsConnectionOpen() {
+		if msg.topic == c.topics.Weight {
+			return
+		}
+		if c.qos == 0 {
+			c.logger.Printf("MQTT: brak połączenia — pominięto wiadomość dla %s", msg.topic)
+			return
+		}
+	}
+
+	token := client.Publish(msg.topic, c.qos, msg.retained, msg.payload)
+	select {
+	case <-token.Done():
+		if err := token.Error(); err != nil {
+			c.logger.Printf("MQTT: publikacja na %s nie powiodła się: %v", msg.topic, err)
+		}
+	default:
+	}
+}
+
+func (c *Client) stop(client paho.Client) {
+	if client.IsConnectionOpen() {
+		client.Publish(c.topics.Status, c.qos, true, c.status("offline")).WaitTimeout(stopTimeout)
+	}
+	client.Disconnect(250)
+}
@@ -0,0 +1,234 @@
+package mqtt
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/NowakAdmin/BizantiAgent/internal/config"
+)
+
+func TestTopicsDefaultUnderPrefix(t *testing.T) {
+	topics := Topics(config.MQTTConfig{
+		TopicPrefix: "linia1/waga/",
+		Topics:      config.MQTTTopics{Events: "zdarzenia/agent"},
+	}, "agent-1")
+
+	want := config.MQTTTopics{
+		Status:   "linia1/waga/status",
+		Weight:   "linia1/waga/weight",
+		Results:  "linia1/waga/results",
+		Events:   "zdarzenia/agent",
+		Commands: "linia1/waga/commands",
+	}
+	if topics != want {
+		t.Fatalf("Topics = %+v", topics)
+	}
+
+	if got := Topics(config.MQTTConfig{}, "agent-1").Status; got != "bizanti/agent-1/status" {
+		t.Fatalf("default status topic = %q", got)
+	}
+}
+
+func TestNewValidatesConfig(t *testing.T) {
+	three := 3
+	for name, cfg := range map[string]config.MQTTConfig{
+		"no broker":   {},
+		"bad scheme":  {Broker: "http://broker:1883"},
+		"invalid qos": {Broker: "tcp://broker:1883", QoS: &three},
+	} {
+		if _, err := New(cfg, nil, log.New(io.Discard, "", 0)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+// publication is a PUBLISH packet seen by the fake broker.
+type publication struct {
+	topic    string
+	retained bool
+	payload  string
+}
+
+// fakeBroker accepts one MQTT 3.1.1 client with QoS 0 and records what it
+// publishes.
+type fakeBroker struct {
+	listener      net.Listener
+	published     chan publication
+	subscriptions chan string
+	conn          chan net.Conn
+}
+
+func newFakeBroker(t *testing.T) *fakeBroker {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	b := &fakeBroker{
+		listener:      listener,
+		published:     make(chan publication, 16),
+		subscriptions: make(chan string, 4),
+		conn:          make(chan net.Conn, 1),
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+
+	go b.serve()
+
+	return b
+}
+
+func (b *fakeBroker) serve() {
+	conn, err := b.listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	b.conn <- conn
+
+	reader := bufio.NewReader(conn)
+	for {
+		header, body, readErr := readPacket(reader)
+		if readErr != nil {
+			return
+		}
+
+		switch header >> 4 {
+		case 1: // CONNECT
+			_, _ = conn.Write([]byte{0x20, 0x02, 0x00, 0x00})
+		case 3: // PUBLISH
+			topicLen := int(body[0])<<8 | int(body[1])
+			b.published <- publication{
+				topic:    string(body[2 : 2+topicLen]),
+				retained: header&0x01 != 0,
+				payload:  string(body[2+topicLen:]),
+			}
+		case 8: // SUBSCRIBE
+			topicLen := int(body[2])<<8 | int(body[3])
+			b.subscriptions <- string(body[4 : 4+topicLen])
+			_, _ = conn.Write([]byte{0x90, 0x03, body[0], body[1], 0x00})
+		case 12: // PINGREQ
+			_, _ = conn.Write([]byte{0xd0, 0x00})
+		case 14: // DISCONNECT
+			return
+		}
+	}
+}
+
+func readPacket(reader *bufio.Reader) (byte, []byte, error) {
+	header, err := reader.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	length, multiplier := 0, 1
+	for {
+		digit, readErr := reader.ReadByte()
+		if readErr != nil {
+			return 0, nil, readErr
+		}
+		length += int(digit&0x7f) * multiplier
+		if digit&0x80 == 0 {
+			break
+		}
+		multiplier *= 128
+	}
+
+	body := make([]byte, length)
+	_, err = io.ReadFull(reader, body)
+
+	return header, body, err
+}
+
+// deliver sends a QoS 0 PUBLISH to the client.
+func (b *fakeBroker) deliver(t *testing.T, topic, payload string) {
+	t.Helper()
+
+	body := append([]byte{byte(len(topic) >> 8), byte(len(topic))}, topic...)
+	body = append(body, payload...)
+	conn := <-b.conn
+	b.conn <- conn
+	if _, err := conn.Write(append([]byte{0x30, byte(len(body))}, body...)); err != nil {
+		t.Fatalf("deliver: %v", err)
+	}
+}
+
+func (b *fakeBroker) next(t *testing.T, topic string) publication {
+	t.Helper()
+
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case p := <-b.published:
+			if p.topic == topic {
+				return p
+			}
+		case <-timeout:
+			t.Fatalf("nothing published on %s", topic)
+		}
+	}
+}
+
+func TestClientPublishesAndRunsCommands(t *testing.T) {
+	broker := newFakeBroker(t)
+	qos := 0
+
+	client, err := New(config.MQTTConfig{
+		Broker:      "tcp://" + broker.listener.Addr().String(),
+		ClientID:    "test",
+		QoS:         &qos,
+		TopicPrefix: "linia1",
+		Commands:    true,
+	}, func(_ context.Context, payload []byte) any {
+		return map[string]any{"echo": string(payload)}
+	}, log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = client.Run(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	status := broker.next(t, "linia1/status")
+	if !status.retained || !strings.Contains(status.payload, `"state":"online"`) {
+		t.Fatalf("status = %+v", status)
+	}
+
+	select {
+	case topic := <-broker.subscriptions:
+		if topic != "linia1/commands" {
+			t.Fatalf("subscribed to %q", topic)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no command subscription")
+	}
+
+	client.PublishEvent(map[string]any{"event": "dibal_connected"})
+	if event := broker.next(t, "linia1/events"); event.retained || event.payload != `{"event":"dibal_connected"}` {
+		t.Fatalf("event = %+v", event)
+	}
+
+	broker.deliver(t, "linia1/commands", `{"command":"read_weight"}`)
+	var result map[string]string
+	if err = json.Unmarshal([]byte(broker.next(t, "linia1/results").payload), &result); err != nil {
+		t.Fatalf("result: %v", err)
+	}
+	if result["echo"] != `{"command":"read_weight"}` {
+		t.Fatalf("result = %v", result)
+	}
+}
@@ -0,0 +1,118 @@
+//go:build !windows
+
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// keyFileName holds random bytes mixed into the key, so the machine ID alone
+// (often world-readable) is not enough to decrypt.
+const keyFileName = "store.key"
+
+var machineIDFiles = []string{"/etc/machine-id", "/var/lib/dbus/machine-id"}
+
+func (s *Store) protect(plain []byte) ([]byte, error) {
+	aead, err := s.cipher(true)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, plain, nil), nil
+}
+
+func (s *Store) unprotect(blob []byte) ([]byte, error) {
+	aead, err := s.cipher(false)
+	if err != nil {
+		return nil, err
+	}
+	if len(blob) < aead.NonceSize() {
+		return nil, errors.New("uszkodzony plik sekretu")
+	}
+
+	return aead.Open(nil, blob[:aead.NonceSize()], blob[aead.NonceSize():], nil)
+}
+
+// cipher derives the AES-256-GCM key from the machine ID and the key file,
+// creating the key file when create is set.
+func (s *Store) cipher(create bool) (cipher.AEAD, error) {
+	keyPath := filepath.Join(s.keyDir, keyFileName)
+	local, err := os.ReadFile(keyPath)
+	if errors.Is(err, os.ErrNotExist) {
+		local, err = s.moveLegacyKey(keyPath)
+	}
+	if errors.Is(err, os.ErrNotExist) && create {
+		local = make([]byte, 32)
+		if _, err = rand.Read(local); err != nil {
+			return nil, err
+		}
+		if err = os.MkdirAll(s.keyDir, 0o700); err == nil {
+			err = writeFileAtomic(keyPath, local)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	hash := sha256.New()
+	hash.Write([]byte("BizantiAgent secrets v1\n"))
+	hash.Write([]byte(machineID()))
+	hash.Write(local)
+
+	block, err := aes.NewCipher(hash.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// moveLegacyKey moves a key file left next to the ciphertexts by older
+// versions to keyPath.
+func (s *Store) moveLegacyKey(keyPath string) ([]byte, error) {
+	legacyPath := filepath.Join(s.dir, keyFileName)
+	if legacyPath == keyPath {
+		return nil, os.ErrNotExist
+	}
+
+	local, err := os.ReadFile(legacyPath)
+	if err != nil {
+		return nil, err
+	}
+	if err = os.MkdirAll(s.keyDir, 0o700); err != nil {
+		return nil, err
+	}
+	if err = writeFileAtomic(keyPath, local); err != nil {
+		return nil, err
+	}
+	if err = os.Remove(legacyPath); err != nil {
+		return nil, err
+	}
+
+	return local, nil
+}
+
+func machineID() string {
+	for _, path := range machineIDFiles {
+		if data, err := os.ReadFile(path); err == nil {
+			if id := strings.TrimSpace(string(data)); id != "" {
+				return id
+			}
+		}
+	}
+
+	hostname, _ := os.Hostname()
+	return hostname
+}
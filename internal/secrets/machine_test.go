@@ -0,0 +1,45 @@
+//go:build !windows
+
+package secrets
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestKeyFileLivesOutsideSecretsDir(t *testing.T) {
+	store := Open(t.TempDir(), filepath.Join(t.TempDir(), "state"))
+	if err := store.Set("agent_token", "s3cret-token"); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(store.dir, keyFileName)); !os.IsNotExist(err) {
+		t.Fatalf("key file written next to the secrets: %v", err)
+	}
+	info, err := os.Stat(filepath.Join(store.keyDir, keyFileName))
+	if err != nil {
+		t.Fatalf("key file missing: %v", err)
+	}
+	if mode := info.Mode().Perm(); mode != 0o600 {
+		t.Fatalf("key file mode = %v", mode)
+	}
+}
+
+func TestLegacyKeyFileIsMoved(t *testing.T) {
+	dir := t.TempDir()
+	if err := Open(dir, dir).Set("agent_token", "s3cret-token"); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+
+	store := Open(dir, filepath.Join(t.TempDir(), "state"))
+	if value, err := store.Get("agent_token"); err != nil || value != "s3cret-token" {
+		t.Fatalf("get = %q, %v", value, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, keyFileName)); !os.IsNotExist(err) {
+		t.Fatalf("legacy key file left in the secrets dir: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(store.keyDir, keyFileName)); err != nil {
+		t.Fatalf("key file not moved: %v", err)
+	}
+}
@@ -0,0 +1,139 @@
+// Package secrets keeps small secrets such as the agent token encrypted at
+// rest, bound to the machine they were written on. On Windows blobs are
+// protected with DPAPI (machine scope); elsewhere with AES-GCM under a key
+// derived from the machine ID and a local key file.
+package secrets
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// ErrNotFound is returned by Get for a secret that was never stored.
+var ErrNotFound = errors.New("sekret nie istnieje")
+
+var validName = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+// Store keeps one encrypted file per secret in a directory.
+type Store struct {
+	dir string
+	// keyDir holds the local key file outside dir, so a copy of the
+	// secrets directory alone cannot be decrypted. Unused on Windows.
+	keyDir string
+}
+
+// Open returns a store in dir with its key file in keyDir. Both directories
+// are created on first write.
+func Open(dir, keyDir string) *Store {
+	return &Store{dir: dir, keyDir: keyDir}
+}
+
+func (s *Store) path(name string) (string, error) {
+	if !validName.MatchString(name) {
+		return "", fmt.Errorf("nieprawidłowa nazwa sekretu %q", name)
+	}
+
+	return filepath.Join(s.dir, name+".secret"), nil
+}
+
+// Get decrypts the named secret.
+func (s *Store) Get(name string) (string, error) {
+	path, err := s.path(name)
+	if err != nil {
+		return "", err
+	}
+
+	blob, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+
+	plain, err := s.unprotect(blob)
+	if err != nil {
+		return "", fmt.Errorf("sekret %s: nie można odszyfrować (inna maszyna?): %w", name, err)
+	}
+
+	return string(plain), nil
+}
+
+// Set encrypts and stores the named secret, replacing the file atomically.
+func (s *Store) Set(name, value string) error {
+	path, err := s.path(name)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(s.dir, 0o700); err != nil {
+		return err
+	}
+
+	blob, err := s.protect([]byte(value))
+	if err != nil {
+		return fmt.Errorf("sekret %s: szyfrowanie nieudane: %w", name, err)
+	}
+
+	return writeFileAtomic(path, blob)
+}
+
+// Delete removes the named secret. Deleting a missing secret is not an error.
+func (s *Store) Delete(name string) error {
+	path, err := s.path(name)
+	if err != nil {
+		return err
+	}
+
+	if err = os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+// Names lists the stored secrets, sorted. A missing directory holds none.
+func (s *Store) Names() ([]string, error) {
+	paths, err := filepath.Glob(filepath.Join(s.dir, "*.secret"))
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(paths))
+	for _, path := range paths {
+		if name := strings.TrimSuffix(filepath.Base(path), ".secret"); validName.MatchString(name) {
+			names = append(names, name)
+		}
+	}
+
+	return names, nil
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+"-*.tmp")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+
+	if _, err = tmp.Write(data); err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmpPath, 0o600)
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, path)
+	}
+	if err != nil {
+		_ = os.Remove(tmpPath)
+	}
+
+	return err
+}
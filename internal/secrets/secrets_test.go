@@ -0,0 +1,71 @@
+package secrets
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	store := Open(filepath.Join(t.TempDir(), "secrets"), filepath.Join(t.TempDir(), "state"))
+
+	if _, err := store.Get("agent_token"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	if err := store.Set("agent_token", "s3cret-token"); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	value, err := store.Get("agent_token")
+	if err != nil || value != "s3cret-token" {
+		t.Fatalf("get = %q, %v", value, err)
+	}
+
+	blob, err := os.ReadFile(filepath.Join(store.dir, "agent_token.secret"))
+	if err != nil {
+		t.Fatalf("read blob: %v", err)
+	}
+	if bytes.Contains(blob, []byte("s3cret-token")) {
+		t.Fatalf("secret stored in plaintext")
+	}
+
+	if names, namesErr := store.Names(); namesErr != nil || len(names) != 1 || names[0] != "agent_token" {
+		t.Fatalf("Names = %v, %v", names, namesErr)
+	}
+
+	if err = store.Delete("agent_token"); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if _, err = store.Get("agent_token"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("secret still present after delete: %v", err)
+	}
+	if err = store.Delete("agent_token"); err != nil {
+		t.Fatalf("deleting a missing secret: %v", err)
+	}
+}
+
+func TestTamperedBlobIsRejected(t *testing.T) {
+	store := Open(t.TempDir(), t.TempDir())
+	if err := store.Set("proxy_password", "hunter2"); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+
+	path := filepath.Join(store.dir, "proxy_password.secret")
+	blob, _ := os.ReadFile(path)
+	blob[len(blob)-1] ^= 0xff
+	if err := os.WriteFile(path, blob, 0o600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	if _, err := store.Get("proxy_password"); err == nil {
+		t.Fatalf("tampered secret decrypted")
+	}
+}
+
+func TestInvalidName(t *testing.T) {
+	if err := Open(t.TempDir(), t.TempDir()).Set("../config", "x"); err == nil {
+		t.Fatalf("path traversal in secret name accepted")
+	}
+}
@@ -0,0 +1,53 @@
+//go:build windows
+
+package secrets
+
+import (
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// entropy separates the agent's blobs from other DPAPI users on the machine.
+var entropy = []byte("BizantiAgent secrets v1")
+
+// protect uses DPAPI in machine scope, so both the service and the tray
+// process can read the token, but a copy of the file is useless elsewhere.
+func (s *Store) protect(plain []byte) ([]byte, error) {
+	var out windows.DataBlob
+	err := windows.CryptProtectData(newBlob(plain), nil, newBlob(entropy), 0, nil,
+		windows.CRYPTPROTECT_UI_FORBIDDEN|windows.CRYPTPROTECT_LOCAL_MACHINE, &out)
+	if err != nil {
+		return nil, err
+	}
+
+	return takeBlob(&out), nil
+}
+
+func (s *Store) unprotect(blob []byte) ([]byte, error) {
+	var out windows.DataBlob
+	err := windows.CryptUnprotectData(newBlob(blob), nil, newBlob(entropy), 0, nil,
+		windows.CRYPTPROTECT_UI_FORBIDDEN, &out)
+	if err != nil {
+		return nil, err
+	}
+
+	return takeBlob(&out), nil
+}
+
+func newBlob(data []byte) *windows.DataBlob {
+	if len(data) == 0 {
+		return &windows.DataBlob{}
+	}
+
+	return &windows.DataBlob{Size: uint32(len(data)), Data: &data[0]}
+}
+
+// takeBlob copies a DPAPI output buffer and frees it.
+func takeBlob(blob *windows.DataBlob) []byte {
+	defer func() {
+		_, _ = windows.LocalFree(windows.Handle(unsafe.Pointer(blob.Data)))
+	}()
+
+	return append([]byte(nil), unsafe.Slice(blob.Data, blob.Size)...)
+}
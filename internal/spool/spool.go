@@ -0,0 +1,235 @@
+// Package spool implements a small durable queue of JSON records.
+//
+// Every record is kept in its own file inside one directory, written through a
+// temporary file and an atomic rename, so a crash or power loss never leaves a
+// half-written record behind. Records are addressed by a caller-supplied key;
+// putting the same key twice replaces the previous record.
+package spool
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const recordExt = ".json"
+
+// Record is a single queued item.
+type Record struct {
+	Key       string          `json:"key"`
+	CreatedAt time.Time       `json:"created_at"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// Decode unmarshals the record payload into v.
+func (r Record) Decode(v any) error {
+	return json.Unmarshal(r.Data, v)
+}
+
+// Queue is a directory-backed FIFO of records. It is safe for concurrent use.
+type Queue struct {
+	dir   string
+	limit int
+
+	mu sync.Mutex
+}
+
+// Open creates the directory if needed and returns a queue backed by it.
+// When limit > 0 the oldest records are dropped once the queue grows past it.
+func Open(dir string, limit int) (*Queue, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	return &Queue{dir: dir, limit: limit}, nil
+}
+
+// Dir returns the directory holding the queue files.
+func (q *Queue) Dir() string {
+	return q.dir
+}
+
+// Put stores value under key. An existing record keeps its original
+// CreatedAt so that replay order does not change when it is rewritten.
+func (q *Queue) Put(key string, value any) error {
+	if strings.TrimSpace(key) == "" {
+		return errors.New("spool: pusty klucz")
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	record := Record{Key: key, CreatedAt: time.Now().UTC(), Data: data}
+	if existing, readErr := q.read(q.path(key)); readErr == nil {
+		record.CreatedAt = existing.CreatedAt
+	}
+
+	if err = q.write(record); err != nil {
+		return err
+	}
+
+	if q.limit > 0 {
+		q.trim()
+	}
+
+	return nil
+}
+
+// Remove deletes the record stored under key. Missing records are not an error.
+func (q *Queue) Remove(key string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	err := os.Remove(q.path(key))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+// Get returns the record stored under key.
+func (q *Queue) Get(key string) (Record, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	record, err := q.read(q.path(key))
+	if err != nil {
+		return Record{}, false
+	}
+
+	return record, true
+}
+
+// Has reports whether a record is stored under key.
+func (q *Queue) Has(key string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	_, err := os.Stat(q.path(key))
+	return err == nil
+}
+
+// List returns all records, oldest first. Unreadable files are skipped.
+func (q *Queue) List() ([]Record, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.list()
+}
+
+// Len returns the number of stored records.
+func (q *Queue) Len() int {
+	records, err := q.List()
+	if err != nil {
+		return 0
+	}
+
+	return len(records)
+}
+
+func (q *Queue) list() ([]Record, error) {
+	entries, err := os.ReadDir(q.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]Record, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), recordExt) {
+			continue
+		}
+
+		record, readErr := q.read(filepath.Join(q.dir, entry.Name()))
+		if readErr != nil {
+			continue
+		}
+
+		records = append(records, record)
+	}
+
+	sort.SliceStable(records, func(i, j int) bool {
+		if records[i].CreatedAt.Equal(records[j].CreatedAt) {
+			return records[i].Key < records[j].Key
+		}
+		return records[i].CreatedAt.Before(records[j].CreatedAt)
+	})
+
+	return records, nil
+}
+
+func (q *Queue) trim() {
+	records, err := q.list()
+	if err != nil || len(records) <= q.limit {
+		return
+	}
+
+	for _, record := range records[:len(records)-q.limit] {
+		_ = os.Remove(q.path(record.Key))
+	}
+}
+
+func (q *Queue) read(path string) (Record, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Record{}, err
+	}
+
+	var record Record
+	if err = json.Unmarshal(data, &record); err != nil {
+		return Record{}, fmt.Errorf("spool: uszkodzony rekord %s: %w", filepath.Base(path), err)
+	}
+
+	return record, nil
+}
+
+func (q *Queue) write(record Record) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(q.dir, "tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+
+	if _, err = tmp.Write(data); err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+
+	if err = os.Rename(tmpPath, q.path(record.Key)); err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+
+	return nil
+}
+
+// path maps a key to a file name. Keys come from the server (job IDs) so they
+// are hashed rather than used verbatim as file names.
+func (q *Queue) path(key string) string {
+	sum := sha1.Sum([]byte(key))
+	return filepath.Join(q.dir, hex.EncodeToString(sum[:])+recordExt)
+}
@@ -0,0 +1,78 @@
+package spool
+
+import (
+	"testing"
+	"time"
+)
+
+func TestQueuePersistsRecordsAcrossReopen(t *testing.T) {
+	dir := t.TempDir()
+
+	queue, err := Open(dir, 0)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+
+	if err = queue.Put("145", map[string]string{"status": "completed"}); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	time.Sleep(2 * time.Millisecond)
+	if err = queue.Put("146", map[string]string{"status": "failed"}); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+
+	reopened, err := Open(dir, 0)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+
+	records, err := reopened.List()
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+
+	if len(records) != 2 || records[0].Key != "145" || records[1].Key != "146" {
+		t.Fatalf("unexpected records: %+v", records)
+	}
+
+	var payload map[string]string
+	if err = records[1].Decode(&payload); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if payload["status"] != "failed" {
+		t.Fatalf("unexpected payload: %v", payload)
+	}
+
+	if err = reopened.Remove("145"); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	if reopened.Has("145") || !reopened.Has("146") {
+		t.Fatal("remove deleted the wrong record")
+	}
+	if err = reopened.Remove("145"); err != nil {
+		t.Fatalf("removing a missing record should not fail: %v", err)
+	}
+}
+
+func TestQueuePutKeepsOrderAndTrimsOldest(t *testing.T) {
+	queue, err := Open(t.TempDir(), 2)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+
+	for _, key := range []string{"a", "b", "a", "c"} {
+		if err = queue.Put(key, key); err != nil {
+			t.Fatalf("put %s: %v", key, err)
+		}
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	records, err := queue.List()
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+
+	if len(records) != 2 || records[0].Key != "b" || records[1].Key != "c" {
+		t.Fatalf("expected [b c] after trimming, got %+v", records)
+	}
+}
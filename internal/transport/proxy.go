@@ -0,0 +1,108 @@
+package transport
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/NowakAdmin/BizantiAgent/internal/config"
+)
+
+// ProxyFunc decides which proxy, if any, a request goes through.
+type ProxyFunc func(*http.Request) (*url.URL, error)
+
+// NewProxy builds the proxy selector for both the HTTP client and the
+// WebSocket dialer, plus a short description for the status display.
+//
+// An explicit proxy URL (http or socks5) wins; credentials from the
+// config are added to it and sent as Proxy-Authorization (HTTP) or in the
+// SOCKS5 handshake. Without a URL the HTTP(S)_PROXY/NO_PROXY environment
+// variables are used unless use_environment is false.
+func NewProxy(cfg config.ProxyConfig) (ProxyFunc, string, error) {
+	rawURL := strings.TrimSpace(cfg.URL)
+	if rawURL == "" {
+		if cfg.UseEnvironment != nil && !*cfg.UseEnvironment {
+			return nil, "brak", nil
+		}
+		return http.ProxyFromEnvironment, environmentProxyDescription(), nil
+	}
+
+	proxyURL, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, "", fmt.Errorf("proxy.url: %w", err)
+	}
+
+	// The WebSocket dialer speaks only HTTP CONNECT and SOCKS5 to proxies,
+	// so TLS-to-proxy (https://) is rejected rather than failing at dial time.
+	switch proxyURL.Scheme {
+	case "http", "socks5":
+	default:
+		return nil, "", fmt.Errorf("proxy.url: nieobsługiwany schemat %q (http, socks5)", proxyURL.Scheme)
+	}
+	if proxyURL.Host == "" {
+		return nil, "", fmt.Errorf("proxy.url: brak hosta w %q", rawURL)
+	}
+
+	if username := strings.TrimSpace(cfg.Username); username != "" {
+		proxyURL.User = url.UserPassword(username, cfg.Password)
+	}
+
+	noProxy := cfg.NoProxy
+	proxy := func(request *http.Request) (*url.URL, error) {
+		if bypassProxy(request.URL.Hostname(), noProxy) {
+			return nil, nil
+		}
+		return proxyURL, nil
+	}
+
+	return proxy, proxyURL.Scheme + "://" + proxyURL.Host, nil
+}
+
+func environmentProxyDescription() string {
+	for _, name := range []string{"HTTPS_PROXY", "https_proxy", "HTTP_PROXY", "http_proxy"} {
+		if value := strings.TrimSpace(os.Getenv(name)); value != "" {
+			if parsed, err := url.Parse(value); err == nil && parsed.Host != "" {
+				value = parsed.Scheme + "://" + parsed.Host
+			}
+			return value + " (" + name + ")"
+		}
+	}
+
+	return "brak"
+}
+
+// bypassProxy reports whether host matches the no-proxy list. Entries are
+// "*", exact host names, domain suffixes ("example.com" also matches
+// "api.example.com", a leading dot is optional), IP addresses and CIDR ranges.
+func bypassProxy(host string, noProxy []string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	ip := net.ParseIP(host)
+
+	for _, entry := range noProxy {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		switch {
+		case entry == "":
+			continue
+		case entry == "*":
+			return true
+		case strings.Contains(entry, "/"):
+			if _, network, err := net.ParseCIDR(entry); err == nil && ip != nil && network.Contains(ip) {
+				return true
+			}
+		case ip != nil:
+			if entryIP := net.ParseIP(entry); entryIP != nil && entryIP.Equal(ip) {
+				return true
+			}
+		default:
+			domain := strings.TrimPrefix(entry, ".")
+			if host == domain || strings.HasSuffix(host, "."+domain) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,92 @@
+package transport
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/NowakAdmin/BizantiAgent/internal/config"
+)
+
+func TestBypassProxy(t *testing.T) {
+	noProxy := []string{"localhost", ".internal.example", "10.0.0.0/8", "192.168.1.5"}
+
+	cases := map[string]bool{
+		"localhost":              true,
+		"api.internal.example":   true,
+		"internal.example":       true,
+		"notinternal.example":    false,
+		"10.20.30.40":            true,
+		"192.168.1.5":            true,
+		"192.168.1.6":            false,
+		"bizanti.pl":             false,
+		"bizanti.pl.internal.pl": false,
+	}
+	for host, want := range cases {
+		if got := bypassProxy(host, noProxy); got != want {
+			t.Fatalf("bypassProxy(%q) = %v, want %v", host, got, want)
+		}
+	}
+
+	if !bypassProxy("anything", []string{"*"}) {
+		t.Fatalf("* must bypass every host")
+	}
+}
+
+func TestNewProxyValidatesURL(t *testing.T) {
+	for _, raw := range []string{"ftp://proxy:21", "https://proxy:443", "http://"} {
+		if _, _, err := NewProxy(config.ProxyConfig{URL: raw}); err == nil {
+			t.Fatalf("expected error for %q", raw)
+		}
+	}
+
+	disabled := false
+	proxy, description, err := NewProxy(config.ProxyConfig{UseEnvironment: &disabled})
+	if err != nil || proxy != nil || description != "brak" {
+		t.Fatalf("disabled environment must mean no proxy: %v %q %v", proxy != nil, description, err)
+	}
+}
+
+func TestHTTPClientUsesAuthenticatedProxy(t *testing.T) {
+	var proxyAuth, requestedURL string
+	proxyServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxyAuth = r.Header.Get("Proxy-Authorization")
+		requestedURL = r.URL.String()
+		_, _ = io.WriteString(w, "ok")
+	}))
+	defer proxyServer.Close()
+
+	clients, err := New(&config.Config{Proxy: config.ProxyConfig{
+		URL:      proxyServer.URL,
+		Username: "magazyn",
+		Password: "tajne",
+		NoProxy:  []string{"direct.example"},
+	}})
+	if err != nil {
+		t.Fatalf("new clients: %v", err)
+	}
+	if !strings.HasPrefix(clients.Proxy, "http://127.0.0.1:") || strings.Contains(clients.Proxy, "tajne") {
+		t.Fatalf("unexpected proxy description: %q", clients.Proxy)
+	}
+
+	response, err := clients.HTTP.Get("http://bizanti.example/api/bizanticore/agent/heartbeat")
+	if err != nil {
+		t.Fatalf("request through proxy: %v", err)
+	}
+	_ = response.Body.Close()
+
+	if requestedURL != "http://bizanti.example/api/bizanticore/agent/heartbeat" {
+		t.Fatalf("request did not go through the proxy: %q", requestedURL)
+	}
+	if !strings.HasPrefix(proxyAuth, "Basic ") {
+		t.Fatalf("missing Proxy-Authorization, got %q", proxyAuth)
+	}
+
+	direct, _ := url.Parse("http://direct.example/")
+	if proxied, _ := clients.Dialer.Proxy(&http.Request{URL: direct}); proxied != nil {
+		t.Fatalf("no_proxy host routed through %v", proxied)
+	}
+}
@@ -0,0 +1,152 @@
+package transport
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/NowakAdmin/BizantiAgent/internal/config"
+)
+
+const (
+	pinPrefix = "sha256/"
+	// tlsMinVersion is the lowest protocol version offered to the server.
+	tlsMinVersion = tls.VersionTLS12
+)
+
+// PinMismatchError reports a server whose certificate chain matches none of
+// the configured SPKI pins. It usually means TLS interception by a proxy or a
+// server certificate replaced without updating the pins.
+type PinMismatchError struct {
+	Host string
+	// Presented lists the pins of the verified chain (or the leaf alone).
+	Presented []string
+}
+
+func (e *PinMismatchError) Error() string {
+	return fmt.Sprintf("certyfikat serwera %s nie pasuje do żadnego skonfigurowanego pinu SPKI (serwer przedstawił: %s)",
+		e.Host, strings.Join(e.Presented, ", "))
+}
+
+// NewTLSConfig builds the client TLS configuration. System roots are always
+// trusted; CAFile adds to them.
+func NewTLSConfig(cfg config.TLSConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tlsMinVersion}
+
+	if caFile := strings.TrimSpace(cfg.CAFile); caFile != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+
+		pem, err := os.ReadFile(resolvePath(caFile))
+		if err != nil {
+			return nil, fmt.Errorf("tls.ca_file: %w", err)
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("tls.ca_file: brak certyfikatów PEM w %s", caFile)
+		}
+
+		tlsConfig.RootCAs = pool
+	}
+
+	certFile := strings.TrimSpace(cfg.ClientCertFile)
+	keyFile := strings.TrimSpace(cfg.ClientKeyFile)
+	if certFile != "" || keyFile != "" {
+		if certFile == "" || keyFile == "" {
+			return nil, errors.New("tls: client_cert_file i client_key_file muszą być podane razem")
+		}
+
+		cert, err := tls.LoadX509KeyPair(resolvePath(certFile), resolvePath(keyFile))
+		if err != nil {
+			return nil, fmt.Errorf("tls: certyfikat klienta: %w", err)
+		}
+
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if len(cfg.SPKIPins) > 0 {
+		pins := make(map[string]bool, len(cfg.SPKIPins))
+		for _, pin := range cfg.SPKIPins {
+			normalized, err := normalizePin(pin)
+			if err != nil {
+				return nil, err
+			}
+			pins[normalized] = true
+		}
+
+		// Pins are checked in addition to normal chain verification, on
+		// every certificate of a verified chain (leaf, intermediate or root).
+		tlsConfig.VerifyConnection = func(state tls.ConnectionState) error {
+			presented := make([]string, 0, len(state.PeerCertificates))
+			for _, cert := range pinnableCertificates(state, tlsConfig.InsecureSkipVerify) {
+				pin := SPKIPin(cert)
+				if pins[pin] {
+					return nil
+				}
+				presented = append(presented, pin)
+			}
+
+			return &PinMismatchError{Host: state.ServerName, Presented: presented}
+		}
+	}
+
+	return tlsConfig, nil
+}
+
+// pinnableCertificates returns the certificates a pin may match. The server
+// can send any extra certificates along with its chain, so only those in a
+// verified chain count. Without verification only the leaf is trusted to
+// belong to the server.
+func pinnableCertificates(state tls.ConnectionState, skipVerify bool) []*x509.Certificate {
+	if skipVerify || len(state.VerifiedChains) == 0 {
+		if len(state.PeerCertificates) == 0 {
+			return nil
+		}
+		return state.PeerCertificates[:1]
+	}
+
+	seen := make(map[*x509.Certificate]bool)
+	var certs []*x509.Certificate
+	for _, chain := range state.VerifiedChains {
+		for _, cert := range chain {
+			if !seen[cert] {
+				seen[cert] = true
+				certs = append(certs, cert)
+			}
+		}
+	}
+
+	return certs
+}
+
+// SPKIPin returns the "sha256/<base64>" pin of a certificate's public key.
+func SPKIPin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return pinPrefix + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func normalizePin(pin string) (string, error) {
+	value := strings.TrimPrefix(strings.TrimSpace(pin), pinPrefix)
+
+	decoded, err := base64.StdEncoding.DecodeString(value)
+	if err != nil || len(decoded) != sha256.Size {
+		return "", fmt.Errorf("tls.spki_pins: nieprawidłowy pin %q (oczekiwano sha256/<base64>)", pin)
+	}
+
+	return pinPrefix + value, nil
+}
+
+func resolvePath(path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+
+	return filepath.Join(config.Dir(), path)
+}
@@ -0,0 +1,186 @@
+package transport
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/NowakAdmin/BizantiAgent/internal/config"
+)
+
+func writePEM(t *testing.T, dir, name, blockType string, der []byte) string {
+	t.Helper()
+
+	path := filepath.Join(dir, name)
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatalf("write %s: %v", name, err)
+	}
+
+	return path
+}
+
+// writeServerCA stores the test server's self-signed certificate as a CA bundle.
+func writeServerCA(t *testing.T, server *httptest.Server) string {
+	t.Helper()
+	return writePEM(t, t.TempDir(), "ca.pem", "CERTIFICATE", server.Certificate().Raw)
+}
+
+// newClientCert creates a self-signed client certificate and key on disk.
+func newClientCert(t *testing.T) (certPath, keyPath string, cert *x509.Certificate) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "agent-test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		IsCA:         true,
+
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+	cert, err = x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("parse certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("marshal key: %v", err)
+	}
+
+	dir := t.TempDir()
+	return writePEM(t, dir, "client.pem", "CERTIFICATE", der), writePEM(t, dir, "client.key", "EC PRIVATE KEY", keyDER), cert
+}
+
+func get(t *testing.T, cfg config.TLSConfig, url string) error {
+	t.Helper()
+
+	clients, err := New(&config.Config{TLS: cfg})
+	if err != nil {
+		t.Fatalf("new clients: %v", err)
+	}
+
+	response, err := clients.HTTP.Get(url)
+	if err != nil {
+		return err
+	}
+	_ = response.Body.Close()
+
+	return nil
+}
+
+func TestCABundleTrustsPrivateServer(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	if err := get(t, config.TLSConfig{}, server.URL); err == nil {
+		t.Fatalf("system roots must not trust the test server")
+	}
+	if err := get(t, config.TLSConfig{CAFile: writeServerCA(t, server)}, server.URL); err != nil {
+		t.Fatalf("ca_file not applied: %v", err)
+	}
+}
+
+func TestClientCertificateForMutualTLS(t *testing.T) {
+	certPath, keyPath, clientCert := newClientCert(t)
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCert)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	server.StartTLS()
+	defer server.Close()
+
+	caFile := writeServerCA(t, server)
+	if err := get(t, config.TLSConfig{CAFile: caFile}, server.URL); err == nil {
+		t.Fatalf("server requiring a client certificate accepted none")
+	}
+
+	cfg := config.TLSConfig{CAFile: caFile, ClientCertFile: certPath, ClientKeyFile: keyPath}
+	if err := get(t, cfg, server.URL); err != nil {
+		t.Fatalf("mutual TLS failed: %v", err)
+	}
+}
+
+func TestSPKIPinMismatchIsTyped(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	caFile := writeServerCA(t, server)
+	goodPin := SPKIPin(server.Certificate())
+	if err := get(t, config.TLSConfig{CAFile: caFile, SPKIPins: []string{goodPin}}, server.URL); err != nil {
+		t.Fatalf("matching pin rejected: %v", err)
+	}
+
+	_, _, other := newClientCert(t)
+	err := get(t, config.TLSConfig{CAFile: caFile, SPKIPins: []string{SPKIPin(other)}}, server.URL)
+
+	var pinErr *PinMismatchError
+	if !errors.As(err, &pinErr) {
+		t.Fatalf("expected PinMismatchError, got %v", err)
+	}
+	if len(pinErr.Presented) == 0 || pinErr.Presented[0] != goodPin {
+		t.Fatalf("error should list the presented pin: %+v", pinErr)
+	}
+}
+
+func TestSPKIPinIgnoresCertificatesOutsideTheChain(t *testing.T) {
+	_, _, pinned := newClientCert(t)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.StartTLS()
+	defer server.Close()
+	// The server appends the pinned certificate to a chain it does not
+	// belong to.
+	server.TLS.Certificates[0].Certificate = append(server.TLS.Certificates[0].Certificate, pinned.Raw)
+
+	err := get(t, config.TLSConfig{CAFile: writeServerCA(t, server), SPKIPins: []string{SPKIPin(pinned)}}, server.URL)
+
+	var pinErr *PinMismatchError
+	if !errors.As(err, &pinErr) {
+		t.Fatalf("expected PinMismatchError, got %v", err)
+	}
+}
+
+func TestInvalidTLSConfigIsRejected(t *testing.T) {
+	cases := []config.TLSConfig{
+		{CAFile: filepath.Join(t.TempDir(), "missing.pem")},
+		{ClientCertFile: "client.pem"},
+		{SPKIPins: []string{"sha256/not-base64"}},
+	}
+
+	for _, cfg := range cases {
+		if _, err := New(&config.Config{TLS: cfg}); err == nil {
+			t.Fatalf("expected error for %+v", cfg)
+		}
+	}
+
+	if _, err := normalizePin(" " + strings.TrimPrefix(SPKIPin(&x509.Certificate{}), pinPrefix)); err != nil {
+		t.Fatalf("bare base64 pin must be accepted: %v", err)
+	}
+}
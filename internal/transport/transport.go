@@ -0,0 +1,61 @@
+// Package transport builds the HTTP client and WebSocket dialer the agent uses
+// to reach the Bizanti server, so that TLS and proxy settings apply to every
+// connection.
+package transport
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/NowakAdmin/BizantiAgent/internal/config"
+)
+
+// Clients holds the configured HTTP client and WebSocket dialer.
+type Clients struct {
+	HTTP   *http.Client
+	Dialer *websocket.Dialer
+
+	// Proxy describes the proxy in use, for the status display.
+	Proxy string
+}
+
+// New builds clients from the agent configuration. It fails when a configured
+// CA bundle, client certificate, pin or proxy cannot be used, rather than
+// silently connecting without them.
+func New(cfg *config.Config) (*Clients, error) {
+	tlsConfig, err := NewTLSConfig(cfg.TLS)
+	if err != nil {
+		return nil, err
+	}
+
+	proxy, proxyDescription, err := NewProxy(cfg.Proxy)
+	if err != nil {
+		return nil, err
+	}
+
+	httpTransport := http.DefaultTransport.(*http.Transport).Clone()
+	httpTransport.TLSClientConfig = tlsConfig
+	httpTransport.Proxy = proxy
+
+	return &Clients{
+		HTTP: &http.Client{Transport: httpTransport},
+		Dialer: &websocket.Dialer{
+			Proxy:            proxy,
+			HandshakeTimeout: 45 * time.Second,
+			TLSClientConfig:  tlsConfig.Clone(),
+		},
+		Proxy: proxyDescription,
+	}, nil
+}
+
+// Default returns clients equivalent to http.DefaultClient and
+// websocket.DefaultDialer.
+func Default() *Clients {
+	return &Clients{
+		HTTP:   http.DefaultClient,
+		Dialer: websocket.DefaultDialer,
+		Proxy:  environmentProxyDescription(),
+	}
+}
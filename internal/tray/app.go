@@ -57,6 +57,10 @@ func (a *App) onReady() {
 
 	status := systray.AddMenuItem("Status: offline", "Status połączenia")
 	status.Disable()
+	proxyItem := systray.AddMenuItem("Proxy: brak", "Proxy używane do połączenia z Bizanti")
+	proxyItem.Disable()
+	breakerItem := systray.AddMenuItem("Połączenie: zamknięty", "Stan ponawiania połączenia (circuit breaker)")
+	breakerItem.Disable()
 
 	start := systray.AddMenuItem("Połącz", "Połącz z Bizanti")
 	stop := systray.AddMenuItem("Rozłącz", "Rozłącz agenta")
@@ -264,6 +268,8 @@ func (a *App) onReady() {
 				// Update status item and tooltip with current connection state
 				statusStr := a.agent.GetStatus()
 				status.SetTitle("Status: " + statusStr)
+				proxyItem.SetTitle("Proxy: " + a.agent.ProxyStatus())
+				breakerItem.SetTitle("Połączenie: " + a.agent.Breaker().String())
 				systray.SetTooltip(fmt.Sprintf("Bizanti Agent v%s - %s", version.Version, statusStr))
 
 			case <-quit.ClickedCh:
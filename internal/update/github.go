@@ -8,9 +8,13 @@ import (
 	"strings"
 	"time"
 
+	"github.com/NowakAdmin/BizantiAgent/internal/metrics"
 	"github.com/NowakAdmin/BizantiAgent/internal/version"
 )
 
+var updateChecks = metrics.NewCounter("bizanti_agent_update_checks_total",
+	"Update checks by result (update_available, up_to_date, error).", "result")
+
 type LatestRelease struct {
 	TagName string `json:"tag_name"`
 	HTMLURL string `json:"html_url"`
@@ -28,7 +32,18 @@ type Result struct {
 	Notes     string
 }
 
-func CheckGitHubRelease(ctx context.Context, repo string) (Result, error) {
+func CheckGitHubRelease(ctx context.Context, repo string) (result Result, err error) {
+	defer func() {
+		switch {
+		case err != nil:
+			updateChecks.Inc("error")
+		case result.HasUpdate:
+			updateChecks.Inc("update_available")
+		default:
+			updateChecks.Inc("up_to_date")
+		}
+	}()
+
 	repo = strings.TrimSpace(repo)
 	if repo == "" {
 		return Result{}, fmt.Errorf("repozytorium GitHub nie może być puste")
@@ -36,7 +51,7 @@ func CheckGitHubRelease(ctx context.Context, repo string) (Result, error) {
 
 	// Spróbuj releases/latest (published releases)
 	url := fmt.Sprintf("https://api.github.com/repos/%s/releases/latest", repo)
-	result, err := checkReleaseURL(ctx, url)
+	result, err = checkReleaseURL(ctx, url)
 
 	// Jeśli nie ma published release (404), spróbuj tags
 	if err != nil && strings.Contains(err.Error(), "status: 404") {
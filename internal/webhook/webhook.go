@@ -0,0 +1,344 @@
+// Package webhook notifies local systems, e.g. an ERP box, of job results and
+// device events with signed JSON POSTs. Deliveries are kept in a spool on disk
+// until the target accepts them, so they survive restarts and outages of the
+// target.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/NowakAdmin/BizantiAgent/internal/config"
+	"github.com/NowakAdmin/BizantiAgent/internal/spool"
+	"github.com/NowakAdmin/BizantiAgent/internal/version"
+)
+
+// Delivery types.
+const (
+	TypeResult = "command_result"
+	TypeEvent  = "event"
+)
+
+const (
+	// spoolLimit bounds pending deliveries; the oldest go first.
+	spoolLimit = 1000
+	// maxAge is how long a delivery is retried before it is dropped.
+	maxAge = 24 * time.Hour
+	// requestTimeout bounds one POST.
+	requestTimeout = 10 * time.Second
+	// Retry delays double from initialBackoff up to maxBackoff.
+	initialBackoff = 5 * time.Second
+	maxBackoff     = 10 * time.Minute
+	// idleWait is how long Run sleeps with nothing to retry.
+	idleWait = time.Hour
+)
+
+// Request headers. The signature is "sha256=" followed by the hex HMAC-SHA256
+// of the timestamp, a dot and the body, keyed with the target's secret.
+const (
+	HeaderDelivery  = "X-Bizanti-Delivery"
+	HeaderType      = "X-Bizanti-Type"
+	HeaderTimestamp = "X-Bizanti-Timestamp"
+	HeaderSignature = "X-Bizanti-Signature"
+)
+
+var deliverySeq atomic.Uint64
+
+// errRejected marks a response that retrying cannot fix.
+var errRejected = errors.New("odrzucone przez odbiorcę")
+
+// target is one configured webhook and its retry state, owned by Run.
+type target struct {
+	name     string
+	url      string
+	secret   string
+	types    []string
+	commands []string
+	events   []string
+
+	failures int
+	retryAt  time.Time
+}
+
+// delivery is one spooled POST.
+type delivery struct {
+	ID     string          `json:"id"`
+	Target string          `json:"target"`
+	Type   string          `json:"type"`
+	Body   json.RawMessage `json:"body"`
+}
+
+// Dispatcher spools notifications and delivers them in order per target.
+type Dispatcher struct {
+	targets []*target
+	queue   *spool.Queue
+	client  *http.Client
+	logger  *log.Logger
+	wake    chan struct{}
+}
+
+// New validates the targets and opens the spool in dir.
+func New(cfgs []config.WebhookConfig, dir string, logger *log.Logger) (*Dispatcher, error) {
+	d := &Dispatcher{
+		logger: logger,
+		wake:   make(chan struct{}, 1),
+	}
+
+	for i, cfg := range cfgs {
+		rawURL := strings.TrimSpace(cfg.URL)
+		parsed, err := url.Parse(rawURL)
+		if err != nil || parsed.Host == "" || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+			return nil, fmt.Errorf("webhooks[%d].url: nieprawidłowy adres %q", i, cfg.URL)
+		}
+
+		name := strings.TrimSpace(cfg.Name)
+		if name == "" {
+			name = parsed.Host
+		}
+
+		d.targets = append(d.targets, &target{
+			name:     name,
+			url:      rawURL,
+			secret:   cfg.Secret,
+			types:    normalize(cfg.Types),
+			commands: normalize(cfg.Commands),
+			events:   normalize(cfg.Events),
+		})
+	}
+
+	queue, err := spool.Open(dir, spoolLimit)
+	if err != nil {
+		return nil, err
+	}
+	d.queue = queue
+
+	// Local systems are reached directly, never through the server proxy.
+	httpTransport := http.DefaultTransport.(*http.Transport).Clone()
+	httpTransport.Proxy = nil
+	d.client = &http.Client{Timeout: requestTimeout, Transport: httpTransport}
+
+	return d, nil
+}
+
+func normalize(values []string) []string {
+	normalized := make([]string, 0, len(values))
+	for _, value := range values {
+		if value = strings.ToLower(strings.TrimSpace(value)); value != "" {
+			normalized = append(normalized, value)
+		}
+	}
+
+	return normalized
+}
+
+// accepts reports whether t wants a delivery of kind about name (the command
+// of a result or the event name).
+func (t *target) accepts(kind, name string) bool {
+	if len(t.types) > 0 && !slices.Contains(t.types, kind) {
+		return false
+	}
+
+	filter := t.events
+	if kind == TypeResult {
+		filter = t.commands
+	}
+
+	return len(filter) == 0 || slices.Contains(filter, strings.ToLower(name))
+}
+
+// Notify spools message for every target that accepts it and wakes Run. kind
+// is TypeResult or TypeEvent; name is the command or event name.
+func (d *Dispatcher) Notify(kind, name string, message any) {
+	body, err := json.Marshal(message)
+	if err != nil {
+		d.logger.Printf("Webhook: nie można zakodować %s: %v", kind, err)
+		return
+	}
+
+	id := fmt.Sprintf("%d-%06d", time.Now().UnixNano(), deliverySeq.Add(1)%1000000)
+	queued := false
+	for _, t := range d.targets {
+		if !t.accepts(kind, name) {
+			continue
+		}
+
+		item := delivery{ID: id, Target: t.url, Type: kind, Body: body}
+		if putErr := d.queue.Put(id+" "+t.url, item); putErr != nil {
+			d.logger.Printf("Webhook %s: nie udało się zapisać %s: %v", t.name, id, putErr)
+			continue
+		}
+		queued = true
+	}
+
+	if queued {
+		select {
+		case d.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Run delivers spooled notifications until ctx ends.
+func (d *Dispatcher) Run(ctx context.Context) error {
+	for {
+		wait := d.flush(ctx)
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		case <-d.wake:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// flush sends every due delivery, oldest first, and returns how long to wait
+// for the next retry. After a failure the target's later deliveries wait as
+// well, so each target receives notifications in order.
+func (d *Dispatcher) flush(ctx context.Context) time.Duration {
+	records, err := d.queue.List()
+	if err != nil {
+		d.logger.Printf("Webhook: błąd odczytu kolejki: %v", err)
+		return initialBackoff
+	}
+
+	next := idleWait
+	blocked := make(map[*target]bool)
+
+	for _, record := range records {
+		if ctx.Err() != nil {
+			return next
+		}
+
+		var item delivery
+		if decodeErr := record.Decode(&item); decodeErr != nil {
+			_ = d.queue.Remove(record.Key)
+			continue
+		}
+
+		t := d.target(item.Target)
+		if t == nil {
+			// The target was removed from the configuration.
+			_ = d.queue.Remove(record.Key)
+			continue
+		}
+		if time.Since(record.CreatedAt) > maxAge {
+			d.logger.Printf("Webhook %s: porzucono %s — brak doręczenia przez %v", t.name, item.ID, maxAge)
+			_ = d.queue.Remove(record.Key)
+			continue
+		}
+		if blocked[t] {
+			continue
+		}
+		if wait := time.Until(t.retryAt); wait > 0 {
+			blocked[t] = true
+			next = min(next, wait)
+			continue
+		}
+
+		postErr := d.post(ctx, t, item)
+		switch {
+		case postErr == nil:
+			t.failures = 0
+			_ = d.queue.Remove(record.Key)
+		case errors.Is(postErr, errRejected):
+			d.logger.Printf("Webhook %s: %s porzucone: %v", t.name, item.ID, postErr)
+			_ = d.queue.Remove(record.Key)
+		case ctx.Err() != nil:
+			return next
+		default:
+			t.failures++
+			delay := backoff(t.failures)
+			t.retryAt = time.Now().Add(delay)
+			blocked[t] = true
+			next = min(next, delay)
+			d.logger.Printf("Webhook %s: %v — ponowna próba za %v", t.name, postErr, delay)
+		}
+	}
+
+	return next
+}
+
+func (d *Dispatcher) target(rawURL string) *target {
+	for _, t := range d.targets {
+		if t.url == rawURL {
+			return t
+		}
+	}
+
+	return nil
+}
+
+func backoff(failures int) time.Duration {
+	delay := initialBackoff
+	for i := 1; i < failures && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+
+	return min(delay, maxBackoff)
+}
+
+// post sends one delivery. Rejections that retrying cannot fix, i.e. 4xx
+// responses other than 408 and 429, wrap errRejected.
+func (d *Dispatcher) post(ctx context.Context, t *target, item delivery) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, t.url, bytes.NewReader(item.Body))
+	if err != nil {
+		return fmt.Errorf("%w: %v", errRejected, err)
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "BizantiAgent/"+version.Version)
+	request.Header.Set(HeaderDelivery, item.ID)
+	request.Header.Set(HeaderType, item.Type)
+	request.Header.Set(HeaderTimestamp, timestamp)
+	if t.secret != "" {
+		request.Header.Set(HeaderSignature, Sign(t.secret, timestamp, item.Body))
+	}
+
+	response, err := d.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_, _ = io.Copy(io.Discard, io.LimitReader(response.Body, 64<<10))
+		_ = response.Body.Close()
+	}()
+
+	switch status := response.StatusCode; {
+	case status >= 200 && status < 300:
+		return nil
+	case status >= 400 && status < 500 && status != http.StatusRequestTimeout && status != http.StatusTooManyRequests:
+		return fmt.Errorf("%w: status %d", errRejected, status)
+	default:
+		return fmt.Errorf("status %d", status)
+	}
+}
+
+// Sign returns the X-Bizanti-Signature value for a body sent at timestamp
+// (Unix seconds). Receivers recompute it with their copy of the secret.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
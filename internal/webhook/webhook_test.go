@@ -0,0 +1,150 @@
+package webhook
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/NowakAdmin/BizantiAgent/internal/config"
+)
+
+// receiver records webhook requests and answers with the queued statuses,
+// then 200.
+type receiver struct {
+	mu       sync.Mutex
+	statuses []int
+	requests []*http.Request
+	bodies   []string
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.requests = append(r.requests, req)
+	r.bodies = append(r.bodies, string(body))
+	status := http.StatusOK
+	if len(r.statuses) > 0 {
+		status, r.statuses = r.statuses[0], r.statuses[1:]
+	}
+	w.WriteHeader(status)
+}
+
+func (r *receiver) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return len(r.requests)
+}
+
+func newTestDispatcher(t *testing.T, dir string, cfgs ...config.WebhookConfig) *Dispatcher {
+	t.Helper()
+
+	d, err := New(cfgs, dir, log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	return d
+}
+
+func TestDeliversSignedFilteredNotifications(t *testing.T) {
+	recv := &receiver{}
+	server := httptest.NewServer(recv)
+	defer server.Close()
+
+	d := newTestDispatcher(t, t.TempDir(), config.WebhookConfig{
+		URL:      server.URL,
+		Secret:   "s3cret",
+		Types:    []string{TypeResult},
+		Commands: []string{"Print_Label"},
+	})
+
+	d.Notify(TypeResult, "read_weight", map[string]any{"job_id": "1"})
+	d.Notify(TypeEvent, "dibal_connected", map[string]any{"event": "dibal_connected"})
+	d.Notify(TypeResult, "print_label", map[string]any{"job_id": "2"})
+	d.flush(context.Background())
+
+	if recv.count() != 1 {
+		t.Fatalf("expected 1 delivery, got %d: %v", recv.count(), recv.bodies)
+	}
+	req, body := recv.requests[0], recv.bodies[0]
+	if body != `{"job_id":"2"}` || req.Header.Get(HeaderType) != TypeResult || req.Header.Get(HeaderDelivery) == "" {
+		t.Fatalf("unexpected delivery %q %v", body, req.Header)
+	}
+	if want := Sign("s3cret", req.Header.Get(HeaderTimestamp), []byte(body)); req.Header.Get(HeaderSignature) != want {
+		t.Fatalf("signature %q, want %q", req.Header.Get(HeaderSignature), want)
+	}
+}
+
+func TestFailedDeliveriesSurviveRestartInOrder(t *testing.T) {
+	recv := &receiver{statuses: []int{http.StatusServiceUnavailable}}
+	server := httptest.NewServer(recv)
+	defer server.Close()
+
+	dir := t.TempDir()
+	target := config.WebhookConfig{URL: server.URL}
+
+	d := newTestDispatcher(t, dir, target)
+	d.Notify(TypeEvent, "a", map[string]any{"n": 1})
+	time.Sleep(2 * time.Millisecond)
+	d.Notify(TypeEvent, "b", map[string]any{"n": 2})
+
+	// The first delivery fails; the second must wait behind it.
+	if wait := d.flush(context.Background()); wait != initialBackoff {
+		t.Fatalf("retry in %v, want %v", wait, initialBackoff)
+	}
+	if recv.count() != 1 {
+		t.Fatalf("expected 1 attempt, got %d", recv.count())
+	}
+
+	// After a restart both are delivered from the spool, in order.
+	restarted := newTestDispatcher(t, dir, target)
+	restarted.flush(context.Background())
+
+	if recv.count() != 3 || recv.bodies[1] != `{"n":1}` || recv.bodies[2] != `{"n":2}` {
+		t.Fatalf("deliveries after restart: %v", recv.bodies)
+	}
+	if restarted.queue.Len() != 0 {
+		t.Fatalf("%d deliveries left in the spool", restarted.queue.Len())
+	}
+}
+
+func TestRejectedDeliveryIsDropped(t *testing.T) {
+	recv := &receiver{statuses: []int{http.StatusBadRequest}}
+	server := httptest.NewServer(recv)
+	defer server.Close()
+
+	d := newTestDispatcher(t, t.TempDir(), config.WebhookConfig{URL: server.URL})
+	d.Notify(TypeEvent, "a", map[string]any{})
+
+	if wait := d.flush(context.Background()); wait != idleWait || d.queue.Len() != 0 {
+		t.Fatalf("rejected delivery kept: wait %v, %d queued", wait, d.queue.Len())
+	}
+}
+
+func TestBackoffDoublesUpToMax(t *testing.T) {
+	for failures, want := range map[int]time.Duration{
+		1:  initialBackoff,
+		2:  2 * initialBackoff,
+		3:  4 * initialBackoff,
+		20: maxBackoff,
+	} {
+		if got := backoff(failures); got != want {
+			t.Errorf("backoff(%d) = %v, want %v", failures, got, want)
+		}
+	}
+}
+
+func TestNewRejectsInvalidURL(t *testing.T) {
+	if _, err := New([]config.WebhookConfig{{URL: "ftp://erp"}}, t.TempDir(), log.New(io.Discard, "", 0)); err == nil {
+		t.Fatalf("expected an error")
+	}
+}